@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// endpointScheme names the resolver that serves a comma separated -s list.
+const endpointScheme = "chitchat"
+
+const (
+	balancePickFirst  = "pick_first"
+	balanceRoundRobin = "round_robin"
+)
+
+// balancer is the load balancing policy spreading calls over the server's
+// backends, see -lb.
+var balancer = balancePickFirst
+
+// endpointList splits the -s value into its host:port endpoints. A dns:///
+// target is a single endpoint whose name may resolve to many backends.
+func endpointList(address string) []string {
+	address = strings.TrimPrefix(address, "dns:///")
+	var endpoints []string
+	for _, endpoint := range strings.Split(address, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// multipleBackends reports whether address can reach more than one server,
+// in which case losing one of them must not tear the connection down.
+func multipleBackends(address string) bool {
+	return len(endpointList(address)) > 1 || strings.HasPrefix(address, "dns:///")
+}
+
+// dialTarget turns the -s value into a gRPC target. A list of endpoints is
+// served by a resolver of its own so the balancer sees every address.
+func dialTarget(address string) (string, []grpc.DialOption) {
+	endpoints := endpointList(address)
+	if len(endpoints) < 2 {
+		return address, nil
+	}
+
+	var state resolver.State
+	for _, endpoint := range endpoints {
+		state.Endpoints = append(state.Endpoints, resolver.Endpoint{
+			Addresses: []resolver.Address{{Addr: endpoint}},
+		})
+	}
+	r := manual.NewBuilderWithScheme(endpointScheme)
+	r.InitialState(state)
+	return endpointScheme + ":///backends", []grpc.DialOption{grpc.WithResolvers(r)}
+}
+
+// serviceConfigDialOptions installs the balancing and retry policies as the
+// default service config, used unless the server's resolver supplies one.
+func serviceConfigDialOptions() []grpc.DialOption {
+	config, err := json.Marshal(struct {
+		LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig"`
+		MethodConfig        []methodConfig        `json:"methodConfig"`
+	}{
+		LoadBalancingConfig: []map[string]struct{}{{balancer: {}}},
+		MethodConfig:        retries.methodConfigs(),
+	})
+	if err != nil {
+		logger.Error("failed to build service config", "err", err)
+		return nil
+	}
+	logger.Debug("service config", "config", string(config))
+	return []grpc.DialOption{grpc.WithDefaultServiceConfig(string(config))}
+}
+
+func validateBalancer(name string) error {
+	switch name {
+	case balancePickFirst, balanceRoundRobin:
+		return nil
+	}
+	return fmt.Errorf("unknown load balancing policy %q, expected %s or %s", name, balancePickFirst, balanceRoundRobin)
+}
+
+// streamBackend returns the address of the backend a stream is pinned to.
+func streamBackend(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
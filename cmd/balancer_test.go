@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// startBackends starts n fake servers that all know the test account, and
+// returns them with the -s value listing them.
+func startBackends(t *testing.T, n int) ([]*fakeServer, string) {
+	t.Helper()
+	var servers []*fakeServer
+	var addrs []string
+	for range n {
+		srv := startFakeServer(t)
+		srv.addAccount(testEmail, "pass-word1")
+		servers = append(servers, srv)
+		addrs = append(addrs, srv.addr)
+	}
+	return servers, strings.Join(addrs, ",")
+}
+
+// useBalancer sets the load balancing policy for the rest of the test.
+func useBalancer(t *testing.T, name string) {
+	t.Helper()
+	saved := balancer
+	balancer = name
+	t.Cleanup(func() { balancer = saved })
+}
+
+func TestEndpointList(t *testing.T) {
+	tests := []struct {
+		address  string
+		want     []string
+		multiple bool
+	}{
+		{"localhost:3000", []string{"localhost:3000"}, false},
+		{"a:1, b:2 ,,c:3", []string{"a:1", "b:2", "c:3"}, true},
+		{"dns:///chat.example.com:3000", []string{"chat.example.com:3000"}, true},
+	}
+	for _, tt := range tests {
+		if got := endpointList(tt.address); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("endpointList(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+		if got := multipleBackends(tt.address); got != tt.multiple {
+			t.Errorf("multipleBackends(%q) = %v, want %v", tt.address, got, tt.multiple)
+		}
+	}
+}
+
+func TestStreamFailsOverToAnotherBackend(t *testing.T) {
+	useBalancer(t, balancePickFirst)
+	servers, address := startBackends(t, 2)
+	loginTo(t, servers[0], address, testEmail)
+	if got := len(servers[0].loggedIn()); got != 1 {
+		t.Fatalf("logged in %d times on the first backend, want once", got)
+	}
+	conn := connection()
+
+	servers[0].stop()
+	eventually(t, "the stream moves to the second backend", func() bool {
+		return len(servers[1].loggedIn()) == 1 && !recovering.Load()
+	})
+	if connection() != conn {
+		t.Error("the connection was redialed instead of failing the stream over")
+	}
+	if got := currentState(); got != stateStreaming {
+		t.Errorf("the session is %s after failover, want streaming", got)
+	}
+}
+
+func TestUnaryCallsSpreadOverBackends(t *testing.T) {
+	tests := []struct {
+		balancer string
+		want     []int
+	}{
+		{balanceRoundRobin, []int{10, 10, 10}},
+		{balancePickFirst, []int{30, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.balancer, func(t *testing.T) {
+			useBalancer(t, tt.balancer)
+			servers, address := startBackends(t, 3)
+			connectTo(t, address)
+			signIn := func() {
+				t.Helper()
+				if _, err := authClient().SignIn(context.Background(), &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"}); err != nil {
+					t.Fatalf("SignIn: %v", err)
+				}
+			}
+			// Round robin only spreads calls over the backends it has
+			// connected to, so wait for all of them first.
+			if tt.balancer == balanceRoundRobin {
+				eventually(t, "every backend is connected", func() bool {
+					signIn()
+					for _, srv := range servers {
+						if srv.callCount("SignIn") == 0 {
+							return false
+						}
+					}
+					return true
+				})
+			}
+			before := make([]int, len(servers))
+			for i, srv := range servers {
+				before[i] = srv.callCount("SignIn")
+			}
+
+			for range 30 {
+				signIn()
+			}
+			for i, srv := range servers {
+				if got := srv.callCount("SignIn") - before[i]; got != tt.want[i] {
+					t.Errorf("backend %d got %d of 30 calls, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
+)
+
+const (
+	defaultHistoryLines = 10
+	actionPrefix        = "/me "
+	topicPrefix         = "/topic "
+)
+
+// errLeaveChat is returned by a slash command to close the chat view.
+var errLeaveChat = errors.New("leave chat")
+
+// slashCommands holds the commands available in the chat view. Plugins and
+// bots add their own with slashCommands.Register.
+var slashCommands = slash.New()
+
+var history = &messageHistory{
+	messages: map[string][]*pkg.ConversationMessage{},
+	pending:  map[string][]*pkg.ConversationMessage{},
+	ids:      map[string]map[string]bool{},
+	split:    map[string]map[string]bool{},
+}
+var topics sync.Map
+var mutedConversations sync.Map
+
+// messageHistory keeps the messages seen in each conversation this session,
+// oldest first. Messages we send are recorded straight away and remembered
+// as pending so the copy the server echoes back is not recorded twice.
+type messageHistory struct {
+	mu       sync.Mutex
+	messages map[string][]*pkg.ConversationMessage
+	pending  map[string][]*pkg.ConversationMessage
+	// ids holds the server ids recorded in each conversation, and split the
+	// ones of messages that arrived in several parts. The id of such a
+	// message is only its last part's.
+	ids   map[string]map[string]bool
+	split map[string]map[string]bool
+}
+
+func (h *messageHistory) addSent(conversationId string, msg *pkg.ConversationMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages[conversationId] = append(h.messages[conversationId], msg)
+	h.pending[conversationId] = append(h.pending[conversationId], msg)
+}
+
+// dropSent forgets a message we failed to send, unless the server has
+// already echoed it.
+func (h *messageHistory) dropSent(conversationId string, msg *pkg.ConversationMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := slices.Index(h.pending[conversationId], msg); i >= 0 {
+		h.pending[conversationId] = slices.Delete(h.pending[conversationId], i, i+1)
+		if j := slices.Index(h.messages[conversationId], msg); j >= 0 {
+			h.messages[conversationId] = slices.Delete(h.messages[conversationId], j, j+1)
+		}
+	}
+}
+
+// echoed reports whether echo is the server's copy of a message we sent,
+// consuming the pending entry and taking the server's id and time if so.
+// Like update, it swaps in a copy rather than change a message handed out.
+func (h *messageHistory) echoed(conversationId string, echo *pkg.ConversationMessage) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, sent := range h.pending[conversationId] {
+		if sent.GetContent() != echo.GetContent() {
+			continue
+		}
+		h.pending[conversationId] = append(h.pending[conversationId][:i], h.pending[conversationId][i+1:]...)
+		confirmed := proto.Clone(sent).(*pkg.ConversationMessage)
+		confirmed.Id, confirmed.ReceivedAt = echo.GetId(), echo.GetReceivedAt()
+		if j := slices.Index(h.messages[conversationId], sent); j >= 0 {
+			h.messages[conversationId][j] = confirmed
+		}
+		h.recordId(conversationId, confirmed.GetId())
+		return true
+	}
+	return false
+}
+
+// add records a received message in time order. It returns false for a
+// message already recorded under the same id.
+func (h *messageHistory) add(conversationId string, msg *pkg.ConversationMessage) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.recordId(conversationId, msg.GetId()) {
+		return false
+	}
+	msgs := append(h.messages[conversationId], msg)
+	at := messageTime(msg)
+	for i := len(msgs) - 1; i > 0 && !at.IsZero() && messageTime(msgs[i-1]).After(at); i-- {
+		msgs[i], msgs[i-1] = msgs[i-1], msgs[i]
+	}
+	h.messages[conversationId] = msgs
+	return true
+}
+
+// recordId notes id as seen in the conversation, returning false if it
+// already was. Messages without an id are never repeats.
+func (h *messageHistory) recordId(conversationId string, id string) bool {
+	if id == "" {
+		return true
+	}
+	if h.ids[conversationId] == nil {
+		h.ids[conversationId] = map[string]bool{}
+	}
+	if h.ids[conversationId][id] {
+		return false
+	}
+	h.ids[conversationId][id] = true
+	return true
+}
+
+// markSplit notes that the messages with ids arrived in several parts.
+func (h *messageHistory) markSplit(conversationId string, ids ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, id := range ids {
+		if h.split[conversationId] == nil {
+			h.split[conversationId] = map[string]bool{}
+		}
+		h.split[conversationId][id] = true
+	}
+}
+
+// isSplit reports whether the message with id arrived in several parts.
+func (h *messageHistory) isSplit(conversationId string, id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.split[conversationId][id]
+}
+
+// replace swaps a conversation's history for msgs, which must already be
+// ordered.
+func (h *messageHistory) replace(conversationId string, msgs []*pkg.ConversationMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages[conversationId] = append([]*pkg.ConversationMessage(nil), msgs...)
+	delete(h.ids, conversationId)
+	delete(h.split, conversationId)
+	for _, msg := range msgs {
+		h.recordId(conversationId, msg.GetId())
+	}
+}
+
+func (h *messageHistory) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clear(h.messages)
+	clear(h.pending)
+	clear(h.ids)
+	clear(h.split)
+}
+
+func (h *messageHistory) last(conversationId string, n int) []*pkg.ConversationMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msgs := h.messages[conversationId]
+	if n < len(msgs) {
+		msgs = msgs[len(msgs)-n:]
+	}
+	return append([]*pkg.ConversationMessage(nil), msgs...)
+}
+
+func isMuted(conversationId string) bool {
+	_, ok := mutedConversations.Load(conversationId)
+	return ok
+}
+
+// noteTopic records the topic a received message sets, if any, so /topic
+// shows topics set by others too.
+func noteTopic(conversationId string, content string) {
+	if strings.HasPrefix(content, topicPrefix) {
+		topics.Store(conversationId, strings.TrimPrefix(content, topicPrefix))
+	}
+}
+
+// formatMessage renders a chat line, turning /me actions and topic changes
+// sent by other clients into prose.
+func formatMessage(from *pkg.Client, content string) string {
+	switch {
+	case strings.HasPrefix(content, actionPrefix):
+		return fmt.Sprintf("* %s %s", from.GetName(), strings.TrimPrefix(content, actionPrefix))
+	case strings.HasPrefix(content, topicPrefix):
+		return fmt.Sprintf("%s set the topic to %q", from.GetName(), strings.TrimPrefix(content, topicPrefix))
+	}
+	return fmt.Sprintf("From %s: %s", from.GetName(), content)
+}
+
+// sendMessage sends content to the open conversation, in several parts when
+// it is long, and records it locally. It is recorded before the first part
+// goes out, as the server's echo may be handled before the send returns.
+func sendMessage(content string) error {
+	if err := checkMessageSize(content); err != nil {
+		return err
+	}
+	conversationId := conversation.GetId()
+	sentAt := timestamppb.Now()
+	sent := &pkg.ConversationMessage{From: me(), Content: content, SentAt: sentAt}
+	history.addSent(conversationId, sent)
+	for _, part := range splitMessage(content) {
+		message := pkg.Message{
+			Conversation: &conversation,
+			From:         me(),
+			Content:      part,
+			SentAt:       sentAt,
+		}
+		err := sendEvent(ctx, &pkg.ChatEvent{
+			Command: &pkg.ChatEvent_Message{Message: &message},
+		})
+		if err != nil {
+			history.dropSent(conversationId, sent)
+			return err
+		}
+	}
+	return nil
+}
+
+func registerChatCommands(shell *ishell.Shell) {
+	commands := []slash.Command{
+		{
+			Name: "help",
+			Help: "List the commands available in chat",
+			Run: func(inv slash.Invocation) error {
+				shell.Print(slashCommands.HelpText())
+				shell.Println("  Start a message with // to send a literal /")
+				return nil
+			},
+		},
+		{
+			Name: "who",
+			Help: "List the members of this conversation",
+			Run: func(inv slash.Invocation) error {
+				for _, member := range conversation.GetMembers() {
+					shell.Printf("  %s (%s)\n", member.GetName(), member.GetClientId())
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "history",
+			Usage:   "[n]",
+			Help:    fmt.Sprintf("Show the last n messages (default %d)", defaultHistoryLines),
+			MaxArgs: 1,
+			Run: func(inv slash.Invocation) error {
+				n := defaultHistoryLines
+				if len(inv.Args) == 1 {
+					var err error
+					if n, err = strconv.Atoi(inv.Args[0]); err != nil || n <= 0 {
+						return fmt.Errorf("%w: n must be a positive number", slash.ErrUsage)
+					}
+				}
+				var days daySeparator
+				for _, msg := range history.last(conversation.GetId(), n) {
+					if separator, ok := days.next(messageTime(msg)); ok {
+						shell.Println(separator)
+					}
+					shell.Println(formatStamped(msg))
+				}
+				return nil
+			},
+		},
+		{
+			Name: "clear",
+			Help: "Clear the screen",
+			Run: func(inv slash.Invocation) error {
+				return shell.ClearScreen()
+			},
+		},
+		{
+			Name:    "me",
+			Usage:   "<action>",
+			Help:    "Describe an action, e.g. /me waves",
+			MinArgs: 1,
+			MaxArgs: -1,
+			Run: func(inv slash.Invocation) error {
+				return sendMessage(actionPrefix + inv.Text)
+			},
+		},
+		{
+			Name:    "edit",
+			Usage:   "[^n] <text>",
+			Help:    "Replace the text of your last message, or of your n-th last with ^n",
+			MinArgs: 1,
+			MaxArgs: -1,
+			Run: func(inv slash.Invocation) error {
+				msg, text, err := pickSentMessage(inv.Args, inv.Text)
+				if err != nil {
+					return err
+				}
+				if text == "" {
+					return fmt.Errorf("%w: the new text is missing", slash.ErrUsage)
+				}
+				updated, err := editMessage(msg, text)
+				if err != nil {
+					return err
+				}
+				if updated != nil {
+					shell.Println(formatStamped(updated))
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "delete",
+			Usage:   "[^n]",
+			Help:    "Delete your last message, or your n-th last with ^n",
+			MaxArgs: 1,
+			Run: func(inv slash.Invocation) error {
+				msg, rest, err := pickSentMessage(inv.Args, inv.Text)
+				if err != nil {
+					return err
+				}
+				if rest != "" {
+					return fmt.Errorf("%w: expected ^n", slash.ErrUsage)
+				}
+				if err := deleteMessage(msg); err != nil {
+					return err
+				}
+				shell.Println("Message deleted")
+				return nil
+			},
+		},
+		{
+			Name:    "leave",
+			Aliases: []string{"break"},
+			Help:    "Leave the conversation and return to the shell",
+			Run: func(inv slash.Invocation) error {
+				return errLeaveChat
+			},
+		},
+		{
+			Name:    "topic",
+			Usage:   "[text]",
+			Help:    "Show the topic, or set it and announce it to the conversation",
+			MaxArgs: -1,
+			Run: func(inv slash.Invocation) error {
+				if inv.Text == "" {
+					if t, ok := topics.Load(conversation.GetId()); ok {
+						shell.Printf("Topic: %s\n", t)
+					} else {
+						shell.Println("No topic set")
+					}
+					return nil
+				}
+				topics.Store(conversation.GetId(), inv.Text)
+				return sendMessage(topicPrefix + inv.Text)
+			},
+		},
+		{
+			Name:    "mute",
+			Usage:   "[on|off]",
+			Help:    "Stop printing incoming messages for this conversation; they are still kept for /history",
+			MaxArgs: 1,
+			Complete: func(args []string, prefix string) []string {
+				if len(args) == 0 {
+					return []string{"on", "off"}
+				}
+				return nil
+			},
+			Run: func(inv slash.Invocation) error {
+				id := conversation.GetId()
+				mute := !isMuted(id)
+				if len(inv.Args) == 1 {
+					switch inv.Args[0] {
+					case "on":
+						mute = true
+					case "off":
+						mute = false
+					default:
+						return fmt.Errorf("%w: expected on or off", slash.ErrUsage)
+					}
+				}
+				if mute {
+					mutedConversations.Store(id, true)
+					shell.Println("Conversation muted")
+				} else {
+					mutedConversations.Delete(id)
+					shell.Println("Conversation unmuted")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "mentions",
+			Help: "List the messages that mentioned you this session",
+			Run: func(inv slash.Invocation) error {
+				printMentions(shell.Printf)
+				return nil
+			},
+		},
+		{
+			Name:    "notify",
+			Usage:   "[on|off|mentions|default]",
+			Help:    "Choose whether messages in this conversation raise notifications, default following the notify command",
+			MaxArgs: 1,
+			Complete: func(args []string, prefix string) []string {
+				if len(args) == 0 {
+					return []string{"on", "off", "mentions", "default"}
+				}
+				return nil
+			},
+			Run: func(inv slash.Invocation) error {
+				id := conversation.GetId()
+				if len(inv.Args) == 1 && inv.Args[0] == "default" {
+					notifyRules.Delete(id)
+				} else if len(inv.Args) == 1 {
+					mode, ok := parseNotifyMode(inv.Args[0])
+					if !ok {
+						return fmt.Errorf("%w: expected on, off, mentions or default", slash.ErrUsage)
+					}
+					if mode != notifyOff && !notifications.configured() {
+						return errNoNotifiers
+					}
+					notifyRules.Store(id, mode)
+				}
+				switch rule, ok := notifyRules.Load(id); {
+				case !ok:
+					shell.Println("Notifications for this conversation follow the notify command")
+				case rule == notifyMentions:
+					shell.Println("Notifications for this conversation are on for mentions only")
+				default:
+					shell.Printf("Notifications for this conversation are %s\n", rule)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, cmd := range commands {
+		if err := slashCommands.Register(cmd); err != nil {
+			logger.Error("failed to register slash command", "command", cmd.Name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
+)
+
+// echoStream is a chat stream whose server echoes every event at once: the
+// echo is received and handled before Send returns, the way a fast server
+// can answer before the client gets on after sending. Messages are given
+// ids as the server does.
+type echoStream struct {
+	pkg.Chatroom_ConverseClient
+	recv    chan *pkg.ChatEvent
+	handled chan struct{}
+	waiting bool
+	sends   int
+	// failAt fails the send with that number, counting from 1.
+	failAt int
+}
+
+func (s *echoStream) Send(event *pkg.ChatEvent) error {
+	s.sends++
+	if s.sends == s.failAt {
+		return errors.New("stream reset")
+	}
+	echo := proto.Clone(event).(*pkg.ChatEvent)
+	if msg := echo.GetMessage(); msg != nil {
+		msg.Id = fmt.Sprintf("m%d", s.sends)
+	}
+	s.deliver(echo)
+	return nil
+}
+
+// deliver hands event to the chat and waits until it is handled.
+func (s *echoStream) deliver(event *pkg.ChatEvent) {
+	s.recv <- event
+	<-s.handled
+}
+
+// Recv hands over the next echo, first reporting the previous one handled.
+func (s *echoStream) Recv() (*pkg.ChatEvent, error) {
+	if s.waiting {
+		s.handled <- struct{}{}
+	}
+	event, ok := <-s.recv
+	if !ok {
+		return nil, io.EOF
+	}
+	s.waiting = true
+	return event, nil
+}
+
+// receiveEchoes runs the receiving side of the chat on an echoStream for
+// the rest of the test and returns the stream and what the chat printed.
+func receiveEchoes(t *testing.T, failAt int) (*echoStream, *terminal) {
+	t.Helper()
+	s := &echoStream{recv: make(chan *pkg.ChatEvent), handled: make(chan struct{}), failAt: failAt}
+	useStream(t, s)
+	savedCtx, savedSession, savedCancel, savedParts := ctx, sessionCtx, sessionCancel, partial
+	ctx = context.Background()
+	sessionCtx, sessionCancel = context.WithCancel(ctx)
+	partial = &partBuffer{messages: map[string]*partialMessage{}}
+	term := startCommand(t, func(c *ishell.Context) { receive(c, &pkg.Account{FirstName: "Alice"}) })
+	t.Cleanup(func() {
+		// With the session over, the end of the stream is not a failure.
+		sessionCancel()
+		close(s.recv)
+		term.wait()
+		ctx, sessionCtx, sessionCancel, partial = savedCtx, savedSession, savedCancel, savedParts
+	})
+	return s, term
+}
+
+// chatCommands registers the chat's slash commands afresh for the rest of
+// the test and returns what they print.
+func chatCommands(t *testing.T) *syncBuffer {
+	t.Helper()
+	out := &syncBuffer{}
+	saved := slashCommands
+	slashCommands = slash.New()
+	t.Cleanup(func() { slashCommands = saved })
+	registerChatCommands(newTestShell(t, io.NopCloser(strings.NewReader("")), out))
+	return out
+}
+
+func TestSendMessageEchoedBeforeSendReturns(t *testing.T) {
+	withHistory(t)
+	_, term := receiveEchoes(t, 0)
+
+	if err := sendMessage("hello"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := history.last("c1", 10)
+	if len(msgs) != 1 || msgs[0].GetContent() != "hello" || msgs[0].GetId() != "m1" {
+		t.Fatalf("history holds %v after the echo, want hello once with the server's id", contents(msgs))
+	}
+	if pending := len(history.pending["c1"]); pending != 0 {
+		t.Errorf("%d messages still wait for their echo", pending)
+	}
+	if strings.Contains(term.out.String(), "hello") {
+		t.Errorf("the echo of our own message was shown: %q", term.out.String())
+	}
+}
+
+func TestSendMessageInPartsEchoedBeforeSendReturns(t *testing.T) {
+	withHistory(t)
+	receiveEchoes(t, 0)
+
+	long := strings.Repeat("long message ", maxPartSize/4)
+	if err := sendMessage(long); err != nil {
+		t.Fatal(err)
+	}
+	msgs := history.last("c1", 10)
+	if len(msgs) != 1 || msgs[0].GetContent() != long || msgs[0].GetId() == "" {
+		t.Fatalf("history holds %d messages after the echo, want the long one once with the server's id", len(msgs))
+	}
+}
+
+func TestSendMessageFailureDropsPending(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		failAt  int
+	}{
+		{"first part", "hello", 1},
+		{"later part", strings.Repeat("long message ", maxPartSize/4), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHistory(t)
+			receiveEchoes(t, tt.failAt)
+
+			if err := sendMessage(tt.content); err == nil {
+				t.Fatal("sendMessage succeeded on a failing stream")
+			}
+			if msgs := history.last("c1", 10); len(msgs) != 0 {
+				t.Errorf("history holds %v after the send failed", contents(msgs))
+			}
+			if pending := len(history.pending["c1"]); pending != 0 {
+				t.Errorf("%d messages wait for an echo after the send failed", pending)
+			}
+		})
+	}
+}
+
+func TestTopicFromOthers(t *testing.T) {
+	withHistory(t)
+	t.Cleanup(topics.Clear)
+	s, term := receiveEchoes(t, 0)
+	out := chatCommands(t)
+
+	s.deliver(&pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{
+		Conversation: &conversation,
+		From:         bob,
+		Id:           "b1",
+		Content:      "/topic release planning",
+	}}})
+	if !strings.Contains(term.out.String(), `Bob set the topic to "release planning"`) {
+		t.Errorf("the chat printed %q for bob's topic", term.out.String())
+	}
+	if err := slashCommands.Execute("/topic"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Topic: release planning") {
+		t.Errorf("/topic printed %q after bob set it", out.String())
+	}
+
+	s.deliver(&pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{
+		Conversation: &pkg.Conversation{Id: "c2"},
+		From:         bob,
+		Id:           "b2",
+		Content:      "/topic elsewhere",
+	}}})
+	if topic, _ := topics.Load("c1"); topic != "release planning" {
+		t.Errorf("the topic of c1 is %q after bob set the one of c2", topic)
+	}
+	if topic, _ := topics.Load("c2"); topic != "elsewhere" {
+		t.Errorf("the topic of c2 is %q, want bob's", topic)
+	}
+}
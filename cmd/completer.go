@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/flynn-archive/go-shlex"
+
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
+)
+
+// chatActive is set while the chat view owns the input line.
+var chatActive atomic.Bool
+
+// completer completes slash commands and @mentions in the chat view and
+// shell commands everywhere else. It replaces ishell's built-in completer,
+// which cannot be switched on and off.
+type completer struct {
+	shell *ishell.Shell
+}
+
+func (cc completer) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	if cc.shell.MultiChoiceActive() {
+		return nil, len(line)
+	}
+
+	var candidates []string
+	var prefix string
+	input := string(line[:pos])
+	if chatActive.Load() && slash.IsCommand(input) {
+		candidates, prefix = slashCommands.Complete(input)
+	} else if chatActive.Load() {
+		text := composedText(input)
+		if word := text[strings.LastIndexAny(text, " \t\n")+1:]; strings.HasPrefix(word, "@") {
+			candidates, prefix = completeMention(word), word
+		}
+	} else {
+		candidates, prefix = cc.shellCandidates(input)
+	}
+
+	for _, candidate := range candidates {
+		newLine = append(newLine, []rune(strings.TrimPrefix(candidate, prefix)))
+	}
+	if len(newLine) == 1 && len(newLine[0]) == 0 {
+		newLine = [][]rune{[]rune(" ")}
+	}
+	return newLine, len([]rune(prefix))
+}
+
+func (cc completer) shellCandidates(input string) (candidates []string, prefix string) {
+	words, err := shlex.Split(input)
+	if err != nil {
+		words = strings.Fields(input)
+	}
+	if len(words) > 0 && !strings.HasSuffix(input, " ") {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	root := cc.shell.RootCmd()
+	cmd, args := root.FindCmd(words)
+	if cmd == nil {
+		cmd, args = root, words
+	}
+	switch {
+	case cmd.CompleterWithPrefix != nil:
+		candidates = cmd.CompleterWithPrefix(prefix, args)
+	case cmd.Completer != nil:
+		candidates = cmd.Completer(args)
+	default:
+		for _, child := range cmd.Children() {
+			candidates = append(candidates, child.Name)
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches, prefix
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	compressAuto = "auto"
+	compressGzip = "gzip"
+	compressNone = "none"
+)
+
+// compression is the -compress mode. In auto mode requests are gzipped until
+// the server shows it cannot decompress them, after which they are sent
+// uncompressed for the rest of the session.
+var compression = compressAuto
+
+var compressionRejected atomic.Bool
+
+func validateCompression(mode string) error {
+	switch mode {
+	case compressAuto, compressGzip, compressNone:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, expected %s, %s or %s", mode, compressAuto, compressGzip, compressNone)
+}
+
+func compressing() bool {
+	switch compression {
+	case compressNone:
+		return false
+	case compressAuto:
+		return !compressionRejected.Load()
+	}
+	return true
+}
+
+// rejectsCompression reports whether err is a server refusing a compressed
+// request because it has no decompressor for it.
+func rejectsCompression(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unimplemented && strings.Contains(s.Message(), "grpc-encoding")
+}
+
+func compressionDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unaryCompressionInterceptor),
+		grpc.WithChainStreamInterceptor(streamCompressionInterceptor),
+	}
+}
+
+func unaryCompressionInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !compressing() {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.UseCompressor(gzip.Name))...)
+	if compression == compressAuto && rejectsCompression(err) {
+		if compressionRejected.CompareAndSwap(false, true) {
+			logger.Warn("server does not accept gzip, sending uncompressed", "method", method)
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+	}
+	return err
+}
+
+// streamCompressionInterceptor compresses the Converse stream. Streams are
+// opened after SignIn, by which time auto mode knows whether the server
+// accepts gzip.
+func streamCompressionInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if compressing() {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
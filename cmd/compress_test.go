@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// useCompression sets -compress for the rest of the test, forgetting what
+// earlier servers accepted.
+func useCompression(t *testing.T, mode string) {
+	t.Helper()
+	saved := compression
+	compression = mode
+	compressionRejected.Store(false)
+	t.Cleanup(func() {
+		compression = saved
+		compressionRejected.Store(false)
+	})
+}
+
+func TestValidateCompression(t *testing.T) {
+	for _, mode := range []string{compressAuto, compressGzip, compressNone} {
+		if err := validateCompression(mode); err != nil {
+			t.Errorf("validateCompression(%q) = %v", mode, err)
+		}
+	}
+	if err := validateCompression("zstd"); err == nil {
+		t.Error("validateCompression accepted zstd")
+	}
+}
+
+func TestRejectsCompression(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unimplemented, `grpc: Decompressor is not installed for grpc-encoding "gzip"`), true},
+		{status.Error(codes.Unimplemented, "unknown method SignIn"), false},
+		{status.Error(codes.Internal, `grpc-encoding "gzip"`), false},
+		{errors.New(`grpc-encoding "gzip"`), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := rejectsCompression(tt.err); got != tt.want {
+			t.Errorf("rejectsCompression(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		rejectsGzip bool
+		// encodings is how the server saw the two SignIns and the
+		// Converse stream compressed.
+		encodings []string
+		failing   bool
+	}{
+		{"auto", compressAuto, false, []string{"SignIn gzip", "SignIn gzip", "Converse gzip"}, false},
+		{"auto falls back", compressAuto, true, []string{"SignIn gzip", "SignIn ", "SignIn ", "Converse "}, false},
+		{"gzip", compressGzip, false, []string{"SignIn gzip", "SignIn gzip", "Converse gzip"}, false},
+		{"gzip does not fall back", compressGzip, true, []string{"SignIn gzip", "SignIn gzip", "Converse gzip"}, true},
+		{"none", compressNone, true, []string{"SignIn ", "SignIn ", "Converse "}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCompression(t, tt.mode)
+			srv := startFakeServer(t)
+			srv.rejectsGzip = tt.rejectsGzip
+			srv.addAccount(testEmail, "pass-word1")
+			connectTo(t, srv.addr)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for range 2 {
+				_, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"})
+				if failed := status.Code(err) == codes.Unimplemented; failed != tt.failing || err != nil && !failed {
+					t.Fatalf("SignIn = %v, want it failing: %t", err, tt.failing)
+				}
+			}
+			stream, err := chatClient().Converse(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := stream.Send(&pkg.ChatEvent{Command: &pkg.ChatEvent_Login{Login: alice}}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Recv(); err != nil {
+				t.Fatal(err)
+			}
+			stream.CloseSend()
+
+			if got := srv.requestEncodings(); !slices.Equal(got, tt.encodings) {
+				t.Errorf("the server saw %q, want %q", got, tt.encodings)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+)
+
+// credentialProtocol is the protocol attribute passed to credential helpers,
+// alongside the server address as host.
+const credentialProtocol = "chitchat"
+
+// passwordCommand is run through the shell to print the password for the
+// email in $CHIT_CHAT_EMAIL, e.g. "pass show chit-chat/$CHIT_CHAT_EMAIL".
+var passwordCommand string
+
+// credentialHelper speaks the git credential helper protocol: it is run
+// with get, store or erase and exchanges key=value lines over stdin and
+// stdout.
+var credentialHelper string
+
+type credentialSource int
+
+const (
+	fromPrompt credentialSource = iota
+	fromVault
+	fromHelper
+	fromCommand
+)
+
+// credential is an email and password to sign in with and where they came
+// from, which decides what happens when the server accepts or rejects them.
+type credential struct {
+	email    string
+	password string
+	source   credentialSource
+}
+
+// askCredentials finds the login to sign in with: the vault's, then the
+// credential helper's, then the password command's, asking for whatever is
+// still missing.
+func askCredentials(c *ishell.Context) (credential, bool) {
+	if saved, ok := savedLogin(c); ok {
+		c.Println("Using the saved login for", saved.Email)
+		return credential{saved.Email, saved.Password, fromVault}, true
+	}
+
+	var cred credential
+	if credentialHelper != "" {
+		found, err := runCredentialHelper(commandContext(), "get", credential{})
+		if err != nil {
+			c.Println("[ERROR] Credential helper failed:", err)
+		} else if found.email != "" && found.password != "" {
+			c.Println("Using the login from the credential helper for", found.email)
+			return found, true
+		}
+	}
+
+	email, ok := prompt(c, "Email: ")
+	if !ok {
+		return cred, false
+	}
+	cred.email = strings.TrimSpace(email)
+
+	if credentialHelper != "" {
+		found, err := runCredentialHelper(commandContext(), "get", cred)
+		if err != nil {
+			c.Println("[ERROR] Credential helper failed:", err)
+		} else if found.password != "" {
+			cred.password, cred.source = found.password, fromHelper
+			return cred, true
+		}
+	}
+	if passwordCommand != "" {
+		password, err := passwordFromCommand(commandContext(), cred.email)
+		if err != nil {
+			c.Println("[ERROR] Password command failed:", err)
+		} else {
+			cred.password, cred.source = password, fromCommand
+			return cred, true
+		}
+	}
+
+	cred.password, ok = promptPassword(c, "Password: ")
+	return cred, ok
+}
+
+// approveCredentials keeps a login the server accepted where it was not
+// already kept.
+func approveCredentials(c *ishell.Context, cred credential) {
+	if cred.source == fromVault {
+		return
+	}
+	if credentialHelper != "" && cred.source != fromHelper {
+		if _, err := runCredentialHelper(commandContext(), "store", cred); err != nil {
+			c.Println("[ERROR] Credential helper could not store the login:", err)
+		}
+	}
+	if cred.source == fromPrompt {
+		offerToSave(c, cred.email, cred.password)
+	}
+}
+
+// rejectCredentials reports a failed sign in, telling the credential helper
+// to forget a password the server refused.
+func rejectCredentials(c *ishell.Context, cred credential, err error) {
+	if !errors.Is(err, rpcerr.AuthFailed) {
+		printRPCError(c, err, "Unable to sign in with email "+cred.email)
+		return
+	}
+	switch cred.source {
+	case fromVault:
+		c.Println("[ERROR] The saved password for", cred.email, "was not accepted; run vault remove", profileName, "and login again")
+	case fromHelper:
+		if _, err := runCredentialHelper(commandContext(), "erase", cred); err != nil {
+			logger.Error("credential helper erase failed", "email", cred.email, "err", err)
+		}
+		c.Println("[ERROR] The password from the credential helper for", cred.email, "was not accepted and has been erased")
+	case fromCommand:
+		c.Println("[ERROR] The password from the password command for", cred.email, "was not accepted")
+	default:
+		printRPCError(c, err, "Unable to sign in with email "+cred.email)
+	}
+}
+
+func passwordFromCommand(ctx context.Context, email string) (string, error) {
+	cmd := shellCommand(ctx, passwordCommand)
+	cmd.Env = append(os.Environ(), "CHIT_CHAT_EMAIL="+email, "CHIT_CHAT_SERVER="+serverAddress)
+	out, err := cmd.Output()
+	if err != nil {
+		logger.Error("password command failed", "email", email, "err", err)
+		return "", err
+	}
+	password, _, _ := strings.Cut(string(out), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("no password printed")
+	}
+	logger.Info("password read from command", "email", email)
+	return password, nil
+}
+
+// runCredentialHelper runs the helper for action. For get the answer is
+// cred with whatever the helper filled in; store and erase return cred.
+func runCredentialHelper(ctx context.Context, action string, cred credential) (credential, error) {
+	var in bytes.Buffer
+	fmt.Fprintf(&in, "protocol=%s\nhost=%s\n", credentialProtocol, serverAddress)
+	if cred.email != "" {
+		fmt.Fprintf(&in, "username=%s\n", cred.email)
+	}
+	if cred.password != "" && action != "get" {
+		fmt.Fprintf(&in, "password=%s\n", cred.password)
+	}
+	in.WriteString("\n")
+
+	cmd := shellCommand(ctx, credentialHelper, action)
+	cmd.Stdin = &in
+	out, err := cmd.Output()
+	if err != nil {
+		logger.Error("credential helper failed", "action", action, "email", cred.email, "err", err)
+		return cred, err
+	}
+	if action != "get" {
+		logger.Info("credential helper done", "action", action, "email", cred.email)
+		return cred, nil
+	}
+
+	found := cred
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSuffix(scanner.Text(), "\r"), "=")
+		if !ok {
+			break
+		}
+		switch key {
+		case "username":
+			if cred.email == "" {
+				found.email = value
+			}
+		case "password":
+			found.password = value
+		case "quit":
+			if value == "1" || value == "true" {
+				return cred, nil
+			}
+		}
+	}
+	if found.password != "" {
+		found.source = fromHelper
+	}
+	logger.Info("credential helper done", "action", action, "email", found.email, "found", found.password != "")
+	return found, nil
+}
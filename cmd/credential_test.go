@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+)
+
+// stubHelper installs a credential helper script that appends its action
+// and input to a log, then runs body. It returns the log's path.
+func stubHelper(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub helper is a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	script := filepath.Join(dir, "helper")
+	contents := "#!/bin/sh\n{ echo \"action=$1\"; cat; } >> '" + log + "'\n" + body + "\n"
+	if err := os.WriteFile(script, []byte(contents), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	helper, address := credentialHelper, serverAddress
+	credentialHelper, serverAddress = "'"+script+"'", "chat.test:443"
+	t.Cleanup(func() { credentialHelper, serverAddress = helper, address })
+	return log
+}
+
+// helperLog returns what the stub helper was given, empty if it never ran.
+func helperLog(t *testing.T, log string) string {
+	t.Helper()
+	raw, err := os.ReadFile(log)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func TestCredentialHelper(t *testing.T) {
+	alice := credential{email: testEmail, password: "pass-word1", source: fromPrompt}
+	tests := []struct {
+		name   string
+		body   string
+		action string
+		cred   credential
+		want   credential
+		err    bool
+		input  string
+	}{
+		{
+			name:   "get without an email",
+			body:   `printf 'username=%s\npassword=from-helper\n' alice@example.com`,
+			action: "get",
+			want:   credential{email: testEmail, password: "from-helper", source: fromHelper},
+			input:  "action=get\nprotocol=chitchat\nhost=chat.test:443\n\n",
+		},
+		{
+			name:   "get keeps the email asked for",
+			body:   `printf 'username=bob@example.com\r\npassword=from-helper\r\n'`,
+			action: "get",
+			cred:   credential{email: testEmail},
+			want:   credential{email: testEmail, password: "from-helper", source: fromHelper},
+			input:  "action=get\nprotocol=chitchat\nhost=chat.test:443\nusername=alice@example.com\n\n",
+		},
+		{
+			name:   "get never sends a password",
+			body:   `true`,
+			action: "get",
+			cred:   credential{email: testEmail, password: "pass-word1", source: fromHelper},
+			want:   credential{email: testEmail, password: "pass-word1", source: fromHelper},
+			input:  "action=get\nprotocol=chitchat\nhost=chat.test:443\nusername=alice@example.com\n\n",
+		},
+		{
+			name:   "get with nothing printed",
+			body:   `true`,
+			action: "get",
+			cred:   credential{email: testEmail},
+			want:   credential{email: testEmail},
+		},
+		{
+			name:   "get told to quit",
+			body:   `printf 'password=ignored\nquit=1\n'`,
+			action: "get",
+			cred:   credential{email: testEmail},
+			want:   credential{email: testEmail},
+		},
+		{
+			name:   "get stops at a line without a key",
+			body:   `printf 'username=x\n\npassword=ignored\n'`,
+			action: "get",
+			want:   credential{email: "x"},
+		},
+		{
+			name:   "store",
+			body:   `true`,
+			action: "store",
+			cred:   alice,
+			want:   alice,
+			input:  "action=store\nprotocol=chitchat\nhost=chat.test:443\nusername=alice@example.com\npassword=pass-word1\n\n",
+		},
+		{
+			name:   "erase",
+			body:   `true`,
+			action: "erase",
+			cred:   alice,
+			want:   alice,
+			input:  "action=erase\nprotocol=chitchat\nhost=chat.test:443\nusername=alice@example.com\npassword=pass-word1\n\n",
+		},
+		{
+			name:   "failing",
+			body:   `echo 'helper broke' >&2; exit 3`,
+			action: "get",
+			cred:   credential{email: testEmail},
+			want:   credential{email: testEmail},
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := stubHelper(t, tt.body)
+			got, err := runCredentialHelper(context.Background(), tt.action, tt.cred)
+			if (err != nil) != tt.err {
+				t.Fatalf("runCredentialHelper error = %v, want an error %t", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("runCredentialHelper = %+v, want %+v", got, tt.want)
+			}
+			if tt.input != "" {
+				if input := helperLog(t, log); input != tt.input {
+					t.Errorf("the helper was given %q, want %q", input, tt.input)
+				}
+			}
+		})
+	}
+}
+
+func TestCredentialHelperLogin(t *testing.T) {
+	t.Run("get", func(t *testing.T) {
+		useVault(t)
+		log := stubHelper(t, `[ "$1" = get ] && printf 'username=alice@example.com\npassword=from-helper\n'`)
+		var cred credential
+		var ok bool
+		out := runCommand(t, func(c *ishell.Context) { cred, ok = askCredentials(c) })
+		if !ok || cred != (credential{testEmail, "from-helper", fromHelper}) {
+			t.Fatalf("askCredentials = %+v, %t; printed %q", cred, ok, out)
+		}
+		if !strings.Contains(out, "Using the login from the credential helper for "+testEmail) || strings.Contains(out, "Email: ") {
+			t.Errorf("askCredentials printed %q", out)
+		}
+		if got := strings.Count(helperLog(t, log), "action="); got != 1 {
+			t.Errorf("the helper ran %d times, want once", got)
+		}
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		useVault(t)
+		log := stubHelper(t, `true`)
+		var cred credential
+		var ok bool
+		out := runCommand(t, func(c *ishell.Context) { cred, ok = askCredentials(c) }, testEmail, "typed")
+		if !ok || cred != (credential{testEmail, "typed", fromPrompt}) {
+			t.Fatalf("askCredentials = %+v, %t; printed %q", cred, ok, out)
+		}
+		// Asked once for anyone, then once for the email typed in.
+		if got := helperLog(t, log); strings.Count(got, "action=get") != 2 || !strings.Contains(got, "username="+testEmail) {
+			t.Errorf("the helper was given %q", got)
+		}
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		useVault(t)
+		stubHelper(t, `exit 1`)
+		var cred credential
+		out := runCommand(t, func(c *ishell.Context) { cred, _ = askCredentials(c) }, testEmail, "typed")
+		if got := strings.Count(out, "[ERROR] Credential helper failed"); got != 2 {
+			t.Errorf("reported %d helper failures, want 2: %q", got, out)
+		}
+		if cred.source != fromPrompt || cred.password != "typed" {
+			t.Errorf("askCredentials = %+v after the helper failed, want the typed password", cred)
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		log := stubHelper(t, `true`)
+		runCommand(t, func(c *ishell.Context) {
+			approveCredentials(c, credential{testEmail, "pass-word1", fromCommand})
+		})
+		if got := helperLog(t, log); !strings.HasPrefix(got, "action=store\n") || !strings.Contains(got, "password=pass-word1\n") {
+			t.Errorf("the helper was given %q, want the login stored", got)
+		}
+	})
+
+	t.Run("not stored again", func(t *testing.T) {
+		log := stubHelper(t, `true`)
+		runCommand(t, func(c *ishell.Context) {
+			approveCredentials(c, credential{testEmail, "from-helper", fromHelper})
+		})
+		if got := helperLog(t, log); got != "" {
+			t.Errorf("the helper was given %q for its own login", got)
+		}
+	})
+
+	t.Run("erase", func(t *testing.T) {
+		log := stubHelper(t, `true`)
+		rejected := rpcerr.From(status.Error(codes.Unauthenticated, "wrong password"))
+		out := runCommand(t, func(c *ishell.Context) {
+			rejectCredentials(c, credential{testEmail, "from-helper", fromHelper}, rejected)
+		})
+		if got := helperLog(t, log); !strings.HasPrefix(got, "action=erase\n") || !strings.Contains(got, "password=from-helper\n") {
+			t.Errorf("the helper was given %q, want the login erased", got)
+		}
+		if !strings.Contains(out, "was not accepted and has been erased") {
+			t.Errorf("rejectCredentials printed %q", out)
+		}
+	})
+
+	t.Run("kept when the server is down", func(t *testing.T) {
+		log := stubHelper(t, `true`)
+		runCommand(t, func(c *ishell.Context) {
+			rejectCredentials(c, credential{testEmail, "from-helper", fromHelper}, rpcerr.From(status.Error(codes.Unavailable, "down")))
+		})
+		if got := helperLog(t, log); got != "" {
+			t.Errorf("the helper was given %q for a server that is down", got)
+		}
+	})
+}
+
+func TestPasswordCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the password commands are shell commands")
+	}
+	tests := []struct {
+		name    string
+		command string
+		want    string
+		err     string
+	}{
+		{"first line", `printf 'secret\nignored\n'`, "secret", ""},
+		{"crlf", `printf 'secret\r\n'`, "secret", ""},
+		{"environment", `echo "pw-$CHIT_CHAT_EMAIL-$CHIT_CHAT_SERVER"`, "pw-alice@example.com-chat.test:443", ""},
+		{"empty", `true`, "", "no password printed"},
+		{"failing", `echo 'no such entry' >&2; exit 2`, "", "exit status 2"},
+	}
+	address, command := serverAddress, passwordCommand
+	t.Cleanup(func() { serverAddress, passwordCommand = address, command })
+	serverAddress = "chat.test:443"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwordCommand = tt.command
+			got, err := passwordFromCommand(context.Background(), testEmail)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("passwordFromCommand error = %v, want %q", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("passwordFromCommand = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
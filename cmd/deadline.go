@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc"
+
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+)
+
+// rpcTimeouts holds the deadline applied to each RPC that is made without
+// one, keyed by method name, e.g. "SignIn".
+type rpcTimeouts struct {
+	fallback  time.Duration
+	perMethod map[string]time.Duration
+}
+
+var timeouts = rpcTimeouts{fallback: 10 * time.Second, perMethod: map[string]time.Duration{}}
+
+func (t *rpcTimeouts) String() string {
+	var parts []string
+	for method, d := range t.perMethod {
+		parts = append(parts, method+"="+d.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses a comma separated list of Method=duration pairs.
+func (t *rpcTimeouts) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		method, duration, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected Method=duration, got %q", part)
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return err
+		}
+		t.perMethod[method] = d
+	}
+	return nil
+}
+
+// forMethod returns the timeout for a method given either its bare name or
+// its full gRPC path, "/pkg.Auth/SignIn".
+func (t *rpcTimeouts) forMethod(method string) time.Duration {
+	if d, ok := t.perMethod[path.Base(method)]; ok {
+		return d
+	}
+	return t.fallback
+}
+
+func unaryDeadlineInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok {
+		if d := timeouts.forMethod(method); d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// The running command's context is cancelled by Ctrl-C, which reaches us as
+// SIGINT because the terminal is only in raw mode while reading input.
+var running = struct {
+	sync.Mutex
+	cancel context.CancelFunc
+	ctx    context.Context
+}{ctx: context.Background()}
+
+func beginCommand() {
+	running.Lock()
+	defer running.Unlock()
+	running.ctx, running.cancel = context.WithCancel(ctx)
+}
+
+func endCommand() {
+	running.Lock()
+	defer running.Unlock()
+	if running.cancel != nil {
+		running.cancel()
+	}
+	running.ctx, running.cancel = ctx, nil
+}
+
+// commandContext returns the context of the shell command being run.
+func commandContext() context.Context {
+	running.Lock()
+	defer running.Unlock()
+	return running.ctx
+}
+
+func cancelCommand() bool {
+	running.Lock()
+	defer running.Unlock()
+	if running.cancel == nil {
+		return false
+	}
+	running.cancel()
+	return true
+}
+
+// handleSignals cancels the running command on SIGINT and shuts the client
+// down on SIGTERM, or on a second SIGINT for a command that will not stop.
+func handleSignals(shutdown context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	watchSignals(signals, shutdown)
+}
+
+// watchSignals acts on the signals received until the channel is closed or
+// the client shuts down.
+func watchSignals(signals <-chan os.Signal, shutdown context.CancelFunc) {
+	var lastInterrupt time.Time
+	for sig := range signals {
+		if sig == os.Interrupt && time.Since(lastInterrupt) > time.Second {
+			lastInterrupt = time.Now()
+			if cancelCommand() {
+				logger.Info("command cancelled")
+				fmt.Fprintln(os.Stdout, "^C cancelled")
+			}
+			continue
+		}
+		logger.Info("shutting down", "signal", sig.String())
+		shutdown()
+		shell.Close()
+		return
+	}
+}
+
+// prompt reads a line after printing label. It returns false when the user
+// gives up with Ctrl-C or Ctrl-D.
+func prompt(c *ishell.Context, label string) (string, bool) {
+	c.Print(label)
+	line, err := c.ReadLineErr()
+	return line, err == nil
+}
+
+// promptPassword is prompt without echo.
+func promptPassword(c *ishell.Context, label string) (string, bool) {
+	c.Print(label)
+	line, err := c.ReadPasswordErr()
+	return line, err == nil
+}
+
+// printRPCError reports a failed RPC with what the user can do about it.
+// Cancelled calls were already announced by handleSignals.
+func printRPCError(c *ishell.Context, err error, msg string) {
+	e := rpcerr.From(err)
+	if errors.Is(e, rpcerr.Canceled) {
+		return
+	}
+	c.Println("[ERROR]", msg+": "+e.Hint())
+}
+
+// printSlashError reports a slash command that failed. Failed calls to the
+// server are explained like any other; usage and parse errors are printed
+// as they are.
+func printSlashError(c *ishell.Context, line string, err error) {
+	var e *rpcerr.Error
+	if !errors.As(err, &e) {
+		c.Println("[ERROR]", err)
+		return
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+	printRPCError(c, err, "Unable to run "+name)
+}
+
+// interruptPrompt handles Ctrl-C typed at the shell prompt, where the
+// terminal is in raw mode and no signal is raised.
+func interruptPrompt(shutdown context.CancelFunc) func(*ishell.Context, int, string) {
+	return func(c *ishell.Context, count int, line string) {
+		if count >= 2 {
+			shutdown()
+			c.Stop()
+			return
+		}
+		c.Println("Input Ctrl-c once more to exit")
+	}
+}
+
+// closeSession ends the chat session and closes the stream and connection
+// on the way out.
+func closeSession() {
+	endSession()
+	if conn := connection(); conn != nil {
+		conn.Close()
+	}
+	connectionWatchers.Wait()
+	logger.Info("client stopped")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
+)
+
+func TestPrintSlashError(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		err  error
+		want string
+	}{
+		{"usage", "/history a b", fmt.Errorf("%w: usage: /history [n]", slash.ErrUsage), "[ERROR] " + slash.ErrUsage.Error() + ": usage: /history [n]\n"},
+		{"server", "  /add bob", fmt.Errorf("adding bob: %w", rpcerr.From(status.Error(codes.NotFound, "no account bob"))), "[ERROR] Unable to run /add: it does not exist on the server\n"},
+		{"server without args", "/who", rpcerr.From(status.Error(codes.Unavailable, "")), "[ERROR] Unable to run /who: the server is unavailable, check your connection and try again shortly\n"},
+		{"canceled", "/who", rpcerr.From(context.Canceled), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := runCommand(t, func(c *ishell.Context) { printSlashError(c, tt.line, tt.err) })
+			if !strings.HasSuffix(out, tt.want) || tt.want == "" && strings.Contains(out, "[ERROR]") {
+				t.Errorf("printSlashError printed %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+// useTimeouts sets -rpc-timeout to spec for the rest of the test.
+func useTimeouts(t *testing.T, spec string) {
+	t.Helper()
+	saved := timeouts
+	timeouts = rpcTimeouts{fallback: saved.fallback, perMethod: map[string]time.Duration{}}
+	if err := timeouts.Set(spec); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { timeouts = saved })
+}
+
+func TestRPCTimeouts(t *testing.T) {
+	useTimeouts(t, "SignIn=2s, SearchAccounts=500ms,,")
+	tests := []struct {
+		method string
+		want   time.Duration
+	}{
+		{"SignIn", 2 * time.Second},
+		{"/pkg.Auth/SignIn", 2 * time.Second},
+		{"/pkg.Auth/SearchAccounts", 500 * time.Millisecond},
+		{"/pkg.Auth/SignUp", timeouts.fallback},
+	}
+	for _, tt := range tests {
+		if got := timeouts.forMethod(tt.method); got != tt.want {
+			t.Errorf("forMethod(%q) = %s, want %s", tt.method, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"SignIn", "SignIn=soon"} {
+		if err := timeouts.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		timeout time.Duration
+	}{
+		{"per method", "SignIn=50ms", 0},
+		{"caller's deadline kept", "SignIn=10ms", 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTimeouts(t, tt.spec)
+			srv := startFakeServer(t)
+			srv.block("SignIn")
+			connectTo(t, srv.addr)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			_, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"})
+			if status.Code(err) != codes.DeadlineExceeded || !errors.Is(err, rpcerr.Deadline) {
+				t.Fatalf("SignIn = %v, want the deadline exceeded", err)
+			}
+			if elapsed := time.Since(start); elapsed < tt.timeout || elapsed > 5*time.Second {
+				t.Errorf("SignIn gave up after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestInterruptCancelsCommand(t *testing.T) {
+	useTimeouts(t, "SignIn=1h")
+	srv := startFakeServer(t)
+	arrived := srv.block("SignIn")
+	connectTo(t, srv.addr)
+
+	signals := make(chan os.Signal)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		watchSignals(signals, func() { t.Error("an interrupt shut the client down") })
+	}()
+	defer func() {
+		close(signals)
+		<-stopped
+	}()
+
+	var err error
+	cmd := &ishell.Cmd{Name: "signin", Func: func(c *ishell.Context) {
+		_, err = authClient().SignIn(commandContext(), &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"})
+		printRPCError(c, err, "Unable to sign in")
+	}}
+	trackCommands([]*ishell.Cmd{cmd})
+	t.Cleanup(func() {
+		// Commands end with the client's context, which ends with the test.
+		running.Lock()
+		defer running.Unlock()
+		running.ctx = context.Background()
+	})
+	term := startCommand(t, cmd.Func)
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SignIn never reached the server")
+	}
+	signals <- os.Interrupt
+	out := term.wait()
+
+	if status.Code(err) != codes.Canceled || !errors.Is(err, rpcerr.Canceled) {
+		t.Errorf("SignIn = %v, want it canceled", err)
+	}
+	if strings.Contains(out, "[ERROR]") {
+		t.Errorf("the canceled command printed %q", out)
+	}
+	if commandContext().Err() != nil {
+		t.Error("the command's cancellation outlived it")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+const doctorTimeout = 5 * time.Second
+
+type checkStatus string
+
+const (
+	checkPass checkStatus = "PASS"
+	checkFail checkStatus = "FAIL"
+	checkSkip checkStatus = "SKIP"
+)
+
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+	hint   string
+}
+
+// runDoctor checks every hop between the client and a working chat session
+// and reports each one, stopping early once a failure makes the remaining
+// checks meaningless.
+func runDoctor(ctx context.Context, target string) []checkResult {
+	var results []checkResult
+	add := func(r checkResult) checkResult {
+		results = append(results, r)
+		logger.Info("doctor check", "check", r.name, "status", string(r.status), "detail", r.detail)
+		return r
+	}
+
+	if isUnixTarget(target) || isWebSocketTarget(target) {
+		check := checkUnixSocket
+		if isWebSocketTarget(target) {
+			check = checkWebSocket
+		}
+		if r := add(check(ctx, target)); r.status == checkFail {
+			return results
+		}
+		runGRPCChecks(ctx, add)
+		return results
+	}
+
+	// With several endpoints the gRPC checks still make sense as long as
+	// one of them is reachable.
+	endpoints := endpointList(target)
+	if len(endpoints) == 0 {
+		add(checkResult{"Server address", checkFail, "no server given", "Pass the server as host:port with -s"})
+		return results
+	}
+	reachable := 0
+	for _, endpoint := range endpoints {
+		label := ""
+		if len(endpoints) > 1 {
+			label = " " + endpoint
+		}
+		if checkEndpoint(ctx, endpoint, label, add) {
+			reachable++
+		}
+	}
+	if reachable == 0 {
+		return results
+	}
+
+	runGRPCChecks(ctx, add)
+	return results
+}
+
+// runGRPCChecks exercises the client's own connection once the server is
+// known to be reachable.
+func runGRPCChecks(ctx context.Context, add func(checkResult) checkResult) {
+	if r := add(checkHealth(ctx)); r.status == checkFail {
+		return
+	}
+	if r := add(checkSearch(ctx)); r.status == checkFail {
+		return
+	}
+	add(checkStreamRoundTrip(ctx))
+}
+
+// checkUnixSocket dials a unix:///path or unix-abstract:name target.
+func checkUnixSocket(ctx context.Context, target string) checkResult {
+	address := strings.TrimPrefix(target, "unix-abstract:")
+	if address != target {
+		address = "@" + address
+	} else {
+		address = strings.TrimPrefix(strings.TrimPrefix(target, "unix://"), "unix:")
+	}
+
+	dialer := &net.Dialer{Timeout: doctorTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", address)
+	if err != nil {
+		return checkResult{"Unix socket", checkFail, err.Error(), "Make sure the sidecar is running and the socket path is right"}
+	}
+	conn.Close()
+	return checkResult{"Unix socket", checkPass, address, ""}
+}
+
+// checkEndpoint resolves and dials a single host:port endpoint, returning
+// false when it is unreachable.
+func checkEndpoint(ctx context.Context, endpoint string, label string, add func(checkResult) checkResult) bool {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		add(checkResult{"Server address" + label, checkFail, err.Error(), "Pass the server as host:port with -s"})
+		return false
+	}
+
+	proxyURL, err := proxyFor(endpoint)
+	if err != nil {
+		add(checkResult{"Proxy" + label, checkFail, err.Error(), "Check -proxy and the HTTPS_PROXY and ALL_PROXY variables"})
+		return false
+	}
+	if proxyURL != nil {
+		add(checkResult{"Proxy" + label, checkPass, redactProxy(proxyURL), ""})
+		add(checkResult{"DNS resolution" + label, checkSkip, "resolved by the proxy", ""})
+	} else {
+		lookupCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+		addrs, err := net.DefaultResolver.LookupHost(lookupCtx, host)
+		cancel()
+		if err != nil {
+			add(checkResult{"DNS resolution" + label, checkFail, err.Error(), "Check the hostname passed with -s and your DNS settings"})
+			return false
+		}
+		add(checkResult{"DNS resolution" + label, checkPass, fmt.Sprintf("%s -> %v", host, addrs), ""})
+	}
+
+	address := net.JoinHostPort(host, port)
+	start := time.Now()
+	dialCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	conn, err := dialViaProxy(dialCtx, address)
+	cancel()
+	if err != nil {
+		add(checkResult{"TCP connect" + label, checkFail, err.Error(), "Make sure the server is running and no firewall blocks port " + port})
+		return false
+	}
+	conn.Close()
+	add(checkResult{"TCP connect" + label, checkPass, fmt.Sprintf("%s in %s", address, time.Since(start).Round(time.Millisecond)), ""})
+
+	r := checkTLS(ctx, address, host)
+	r.name += label
+	add(r)
+	return true
+}
+
+// checkTLS reports whether the server also accepts TLS. The client dials in
+// plaintext today, so a failed handshake is informational only.
+func checkTLS(ctx context.Context, address string, host string) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	raw, err := dialViaProxy(ctx, address)
+	if err != nil {
+		return checkResult{"TLS handshake", checkSkip, "server does not offer TLS: " + err.Error(), ""}
+	}
+	conn := tls.Client(raw, &tls.Config{ServerName: host})
+	defer conn.Close()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return checkResult{"TLS handshake", checkSkip, "server does not offer TLS: " + err.Error(), ""}
+	}
+	state := conn.ConnectionState()
+	return checkResult{"TLS handshake", checkPass, fmt.Sprintf("TLS version %s", tls.VersionName(state.Version)), ""}
+}
+
+func checkHealth(ctx context.Context) checkResult {
+	conn := connection()
+	if conn == nil {
+		return checkResult{"gRPC health", checkFail, "no connection", "Restart the client; the initial dial failed"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	switch status.Code(err) {
+	case codes.OK:
+		if response.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			return checkResult{"gRPC health", checkFail, response.GetStatus().String(), "The server reports it is not serving; check its logs"}
+		}
+		return checkResult{"gRPC health", checkPass, response.GetStatus().String(), ""}
+	case codes.Unimplemented:
+		return checkResult{"gRPC health", checkSkip, "server does not expose the health service", ""}
+	default:
+		return checkResult{"gRPC health", checkFail, err.Error(), "The port is open but is not answering gRPC; make sure -s points at chit-chat-go"}
+	}
+}
+
+func checkSearch(ctx context.Context) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := authClient().SearchAccounts(ctx, &pkg.SearchAccountsRequest{SearchQuery: me().GetName(), Size: 1})
+	if err != nil {
+		return checkResult{"SearchAccounts round-trip", checkFail, err.Error(), "The Auth service is failing; check the server and its database"}
+	}
+	return checkResult{"SearchAccounts round-trip", checkPass, time.Since(start).Round(time.Millisecond).String(), ""}
+}
+
+// newProbeClient returns a throwaway identity for checkStreamRoundTrip.
+// Logging in as the user instead would take the live session's place on
+// the server, which routes each client's messages to one stream.
+func newProbeClient() *pkg.Client {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return &pkg.Client{ClientId: "doctor-" + hex.EncodeToString(id), Name: "doctor"}
+}
+
+// checkStreamRoundTrip opens a separate Converse stream as a probe client,
+// sends a message addressed only to the probe and waits for it to come back.
+func checkStreamRoundTrip(ctx context.Context) checkResult {
+	const name = "Converse round-trip"
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	probeClient := newProbeClient()
+
+	probe, err := chatClient().Converse(ctx)
+	if err != nil {
+		return checkResult{name, checkFail, err.Error(), "The server refused the chat stream"}
+	}
+	defer probe.CloseSend()
+
+	if err := probe.Send(&pkg.ChatEvent{Command: &pkg.ChatEvent_Login{Login: probeClient}}); err != nil {
+		return checkResult{name, checkFail, err.Error(), "The chat stream closed before login"}
+	}
+	if _, err := probe.Recv(); err != nil {
+		return checkResult{name, checkFail, err.Error(), "The server did not acknowledge login on the chat stream"}
+	}
+
+	content := fmt.Sprintf("doctor probe %d", time.Now().UnixNano())
+	start := time.Now()
+	err = probe.Send(&pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{
+		Conversation: &pkg.Conversation{Members: []*pkg.Client{probeClient}},
+		From:         probeClient,
+		Content:      content,
+	}}})
+	if err != nil {
+		return checkResult{name, checkFail, err.Error(), "The chat stream closed while sending"}
+	}
+
+	for {
+		in, err := probe.Recv()
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return checkResult{name, checkFail, "no echo within " + doctorTimeout.String(), "Messages are not being routed back; a proxy may be buffering the stream"}
+			}
+			return checkResult{name, checkFail, err.Error(), "The chat stream closed while waiting for the echo"}
+		}
+		if in.GetMessage().GetContent() == content {
+			return checkResult{name, checkPass, time.Since(start).Round(time.Millisecond).String(), ""}
+		}
+	}
+}
+
+func printDoctorReport(c *ishell.Context, target string, results []checkResult) {
+	c.Printf("Diagnostics for %s\n", target)
+	failed := 0
+	for _, r := range results {
+		c.Printf("  [%s] %-26s %s\n", r.status, r.name, r.detail)
+		if r.status == checkFail {
+			failed++
+			if r.hint != "" {
+				c.Printf("         hint: %s\n", r.hint)
+			}
+		}
+	}
+	if failed == 0 {
+		c.Println("All checks passed")
+	} else {
+		c.Printf("%d check(s) failed\n", failed)
+	}
+}
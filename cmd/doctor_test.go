@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStreamRoundTripLeavesSessionAlone(t *testing.T) {
+	srv := startFakeServer(t)
+	loginTo(t, srv, srv.addr, testEmail)
+	openConversation(t)
+
+	if r := checkStreamRoundTrip(context.Background()); r.status != checkPass {
+		t.Fatalf("checkStreamRoundTrip = %+v, want a pass", r)
+	}
+	logins := srv.loggedIn()
+	if len(logins) != 2 || logins[0] != testEmail || !strings.HasPrefix(logins[1], "doctor-") {
+		t.Errorf("the server saw logins %q, want the user's then a probe's", logins)
+	}
+
+	// The live stream still gets the user's messages.
+	if err := sendMessage("still here"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the message sent after the check is echoed", func() bool {
+		msgs := history.last(conversation.GetId(), 1)
+		return len(msgs) == 1 && msgs[0].GetId() != ""
+	})
+	if got := contents(history.last(conversation.GetId(), 10)); !slices.Equal(got, []string{"still here"}) {
+		t.Errorf("history = %q, want only the user's message", got)
+	}
+	if got := currentState(); got != stateStreaming {
+		t.Errorf("the session is %s after the check, want streaming", got)
+	}
+}
+
+func TestStreamRoundTripWithoutLogin(t *testing.T) {
+	srv := startFakeServer(t)
+	connectTo(t, srv.addr)
+
+	if r := checkStreamRoundTrip(context.Background()); r.status != checkPass {
+		t.Fatalf("checkStreamRoundTrip = %+v, want a pass", r)
+	}
+}
+
+func TestStreamRoundTripProbesAreDistinct(t *testing.T) {
+	a, b := newProbeClient(), newProbeClient()
+	if a.GetClientId() == b.GetClientId() {
+		t.Errorf("two probes share the id %q", a.GetClientId())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
+)
+
+var errNoSentMessage = errors.New("you have not sent that many messages in this conversation")
+
+// errUnconfirmed is returned for messages the server has not given an id,
+// which edits and deletes refer to.
+var errUnconfirmed = errors.New("the server has not confirmed that message yet, so it cannot be changed")
+
+// errSplitMessage is returned for messages sent in several parts. Edits and
+// deletes name one message id, and each part has its own.
+var errSplitMessage = errors.New("that message was sent in several parts, which cannot be edited or deleted")
+
+// messageBack matches the ^n picking the n-th last message the user sent.
+var messageBack = regexp.MustCompile(`^\^(\d+)$`)
+
+// sentMessage returns the n-th last message from clientId that is not
+// deleted, 1 being the last.
+func (h *messageHistory) sentMessage(conversationId string, clientId string, n int) (*pkg.ConversationMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msgs := h.messages[conversationId]
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].GetFrom().GetClientId() != clientId || msgs[i].GetDeleted() {
+			continue
+		}
+		if n--; n == 0 {
+			return msgs[i], true
+		}
+	}
+	return nil, false
+}
+
+// update replaces the message with id sent by clientId with a copy changed
+// by change, and returns it. When change reports that it left the copy as
+// it was, the message is kept and returned with false; without such a
+// message nil and false are returned. Copies are made so messages already
+// handed out are never modified.
+func (h *messageHistory) update(conversationId string, id string, clientId string, change func(*pkg.ConversationMessage) bool) (*pkg.ConversationMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id == "" {
+		return nil, false
+	}
+	for i, msg := range h.messages[conversationId] {
+		if msg.GetId() != id || msg.GetFrom().GetClientId() != clientId || msg.GetDeleted() {
+			continue
+		}
+		updated := proto.Clone(msg).(*pkg.ConversationMessage)
+		if !change(updated) {
+			return msg, false
+		}
+		h.messages[conversationId][i] = updated
+		return updated, true
+	}
+	return nil, false
+}
+
+// applyEdit records an edit in the history. An edit already applied, such
+// as the server's echo of one made here, changes nothing.
+func applyEdit(conversationId string, edit *pkg.MessageEdit) (*pkg.ConversationMessage, bool) {
+	return history.update(conversationId, edit.GetId(), edit.GetFrom().GetClientId(), func(msg *pkg.ConversationMessage) bool {
+		if edit.GetEditedAt() != nil && proto.Equal(msg.GetEditedAt(), edit.GetEditedAt()) {
+			return false
+		}
+		msg.Content, msg.EditedAt = edit.GetContent(), edit.GetEditedAt()
+		if msg.EditedAt == nil {
+			msg.EditedAt = timestamppb.Now()
+		}
+		return true
+	})
+}
+
+// applyDelete leaves a tombstone in the history in place of the message.
+func applyDelete(conversationId string, del *pkg.MessageDelete) (*pkg.ConversationMessage, bool) {
+	return history.update(conversationId, del.GetId(), del.GetFrom().GetClientId(), func(msg *pkg.ConversationMessage) bool {
+		msg.Content, msg.EditedAt, msg.Deleted = "", nil, true
+		return true
+	})
+}
+
+// pickSentMessage finds the message an /edit or /delete refers to: the
+// user's last one, or the n-th last when args starts with ^n. It returns
+// the message and the arguments after the ^n.
+func pickSentMessage(args []string, text string) (*pkg.ConversationMessage, string, error) {
+	n := 1
+	if len(args) > 0 {
+		if m := messageBack.FindStringSubmatch(args[0]); m != nil {
+			var err error
+			if n, err = strconv.Atoi(m[1]); err != nil || n <= 0 {
+				return nil, "", fmt.Errorf("%w: ^n must be a positive number", slash.ErrUsage)
+			}
+			text = strings.TrimSpace(strings.TrimPrefix(text, args[0]))
+		}
+	}
+	msg, ok := history.sentMessage(conversation.GetId(), me().GetClientId(), n)
+	if !ok {
+		return nil, "", errNoSentMessage
+	}
+	if msg.GetId() == "" {
+		return nil, "", errUnconfirmed
+	}
+	if history.isSplit(conversation.GetId(), msg.GetId()) {
+		return nil, "", errSplitMessage
+	}
+	return msg, text, nil
+}
+
+// editMessage replaces the content of one of the user's messages.
+func editMessage(msg *pkg.ConversationMessage, content string) (*pkg.ConversationMessage, error) {
+	if len(content) > maxPartSize {
+		return nil, fmt.Errorf("%w: an edit can be at most %d bytes", errMessageTooLarge, maxPartSize)
+	}
+	edit := &pkg.MessageEdit{
+		Conversation: &conversation,
+		From:         me(),
+		Id:           msg.GetId(),
+		Content:      content,
+		EditedAt:     timestamppb.Now(),
+	}
+	if err := sendEvent(ctx, &pkg.ChatEvent{Command: &pkg.ChatEvent_Edit{Edit: edit}}); err != nil {
+		return nil, err
+	}
+	updated, _ := applyEdit(conversation.GetId(), edit)
+	return updated, nil
+}
+
+// deleteMessage removes one of the user's messages.
+func deleteMessage(msg *pkg.ConversationMessage) error {
+	del := &pkg.MessageDelete{
+		Conversation: &conversation,
+		From:         me(),
+		Id:           msg.GetId(),
+	}
+	if err := sendEvent(ctx, &pkg.ChatEvent{Command: &pkg.ChatEvent_Delete{Delete: del}}); err != nil {
+		return err
+	}
+	applyDelete(conversation.GetId(), del)
+	return nil
+}
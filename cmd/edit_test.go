@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
+)
+
+var (
+	alice = &pkg.Client{ClientId: "alice", Name: "Alice"}
+	bob   = &pkg.Client{ClientId: "bob", Name: "Bob"}
+)
+
+// emptyHistory returns a messageHistory with nothing recorded.
+func emptyHistory() *messageHistory {
+	return &messageHistory{
+		messages: map[string][]*pkg.ConversationMessage{},
+		pending:  map[string][]*pkg.ConversationMessage{},
+		ids:      map[string]map[string]bool{},
+		split:    map[string]map[string]bool{},
+	}
+}
+
+// withHistory gives the test a history holding msgs, in conversation c1
+// with the user logged in as alice.
+func withHistory(t *testing.T, msgs ...*pkg.ConversationMessage) {
+	t.Helper()
+	saved, savedMe := history, me()
+	history = emptyHistory()
+	self.Store(alice)
+	conversation = pkg.Conversation{Id: "c1"}
+	t.Cleanup(func() {
+		history = saved
+		self.Store(savedMe)
+		conversation = pkg.Conversation{}
+	})
+	for _, msg := range msgs {
+		history.add("c1", msg)
+	}
+}
+
+func stored(from *pkg.Client, id string, content string) *pkg.ConversationMessage {
+	return &pkg.ConversationMessage{From: from, Id: id, Content: content}
+}
+
+func TestApplyEdit(t *testing.T) {
+	editedAt := timestamppb.New(time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name string
+		edit *pkg.MessageEdit
+		ok   bool
+	}{
+		{"own message", &pkg.MessageEdit{From: alice, Id: "m1", Content: "fixed", EditedAt: editedAt}, true},
+		{"without a time", &pkg.MessageEdit{From: alice, Id: "m1", Content: "fixed"}, true},
+		{"someone else's message", &pkg.MessageEdit{From: bob, Id: "m1", Content: "fixed"}, false},
+		{"unknown id", &pkg.MessageEdit{From: alice, Id: "m9", Content: "fixed"}, false},
+		{"no id", &pkg.MessageEdit{From: alice, Content: "fixed"}, false},
+		{"deleted message", &pkg.MessageEdit{From: bob, Id: "m3", Content: "back"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := stored(alice, "m1", "typo")
+			deleted := stored(bob, "m3", "")
+			deleted.Deleted = true
+			withHistory(t, original, stored(bob, "m2", "hi"), deleted)
+
+			updated, ok := applyEdit("c1", tt.edit)
+			if ok != tt.ok {
+				t.Fatalf("applyEdit ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if updated.GetContent() != "fixed" || updated.GetEditedAt() == nil {
+				t.Errorf("edited message = %v", updated)
+			}
+			if tt.edit.GetEditedAt() != nil && !updated.GetEditedAt().AsTime().Equal(editedAt.AsTime()) {
+				t.Errorf("edited at %v, want the edit's time %v", updated.GetEditedAt().AsTime(), editedAt.AsTime())
+			}
+			if got := history.last("c1", 3)[0]; got != updated {
+				t.Errorf("history holds %v, want the edited copy", got)
+			}
+			if original.GetContent() != "typo" {
+				t.Error("applyEdit changed the message handed out instead of a copy")
+			}
+			if !strings.HasSuffix(formatStamped(updated), "(edited)") {
+				t.Errorf("formatStamped = %q, want an (edited) marker", formatStamped(updated))
+			}
+		})
+	}
+}
+
+func TestApplyEditTwice(t *testing.T) {
+	withHistory(t, stored(alice, "m1", "typo"))
+	edit := &pkg.MessageEdit{From: alice, Id: "m1", Content: "fixed", EditedAt: timestamppb.New(time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC))}
+
+	first, ok := applyEdit("c1", edit)
+	if !ok {
+		t.Fatal("the edit was not applied")
+	}
+	again, ok := applyEdit("c1", proto.Clone(edit).(*pkg.MessageEdit))
+	if ok || again != first {
+		t.Errorf("applying the same edit again = %v, %t; want the message unchanged", again, ok)
+	}
+	if got := history.last("c1", 1)[0]; got != first {
+		t.Errorf("history holds %v after the repeated edit, want the first edit's copy", got)
+	}
+
+	later := &pkg.MessageEdit{From: alice, Id: "m1", Content: "fixed again", EditedAt: timestamppb.New(time.Date(2025, 1, 15, 12, 5, 0, 0, time.UTC))}
+	if updated, ok := applyEdit("c1", later); !ok || updated.GetContent() != "fixed again" {
+		t.Errorf("a later edit = %v, %t; want it applied", updated, ok)
+	}
+}
+
+func TestEditEchoedBeforeSendReturns(t *testing.T) {
+	withHistory(t, stored(alice, "m1", "typo"), stored(alice, "m2", "oops"))
+	_, term := receiveEchoes(t, 0)
+	out := chatCommands(t)
+
+	for _, line := range []string{"/edit ^2 fixed", "/delete"} {
+		if err := slashCommands.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+	printed := out.String() + term.out.String()
+	if got := strings.Count(printed, "fixed"); got != 1 {
+		t.Errorf("the edit was printed %d times, want once: %q", got, printed)
+	}
+	if got := strings.Count(printed, "Message deleted") + strings.Count(printed, "deleted a message"); got != 1 {
+		t.Errorf("the delete was reported %d times, want once: %q", got, printed)
+	}
+	msgs := history.last("c1", 10)
+	if len(msgs) != 2 || msgs[0].GetContent() != "fixed" || !msgs[1].GetDeleted() {
+		t.Errorf("history holds %v", msgs)
+	}
+}
+
+func TestApplyDelete(t *testing.T) {
+	withHistory(t, stored(alice, "m1", "oops"), stored(bob, "m2", "hi"))
+
+	if _, ok := applyDelete("c1", &pkg.MessageDelete{From: bob, Id: "m1"}); ok {
+		t.Error("bob deleted alice's message")
+	}
+	tombstone, ok := applyDelete("c1", &pkg.MessageDelete{From: alice, Id: "m1"})
+	if !ok {
+		t.Fatal("alice could not delete her message")
+	}
+	if !tombstone.GetDeleted() || tombstone.GetContent() != "" {
+		t.Errorf("tombstone = %v", tombstone)
+	}
+	if got, want := formatStamped(tombstone), "(Alice deleted a message)"; !strings.HasSuffix(got, want) {
+		t.Errorf("formatStamped = %q, want it to end in %q", got, want)
+	}
+	if _, ok := applyDelete("c1", &pkg.MessageDelete{From: alice, Id: "m1"}); ok {
+		t.Error("a deleted message was deleted again")
+	}
+	if _, ok := applyEdit("c1", &pkg.MessageEdit{From: alice, Id: "m1", Content: "back"}); ok {
+		t.Error("a deleted message was edited")
+	}
+}
+
+func TestPickSentMessage(t *testing.T) {
+	deleted := stored(alice, "m4", "")
+	deleted.Deleted = true
+	msgs := []*pkg.ConversationMessage{
+		stored(alice, "m1", "first"),
+		stored(alice, "m2", "[part 1/2 0badf00d] split"),
+		stored(alice, "", "unconfirmed"),
+		stored(alice, "m3", "third"),
+		stored(bob, "m5", "bob's"),
+		deleted,
+	}
+	tests := []struct {
+		name     string
+		args     []string
+		text     string
+		want     string
+		wantText string
+		err      error
+	}{
+		{"last", []string{"new", "text"}, "new text", "m3", "new text", nil},
+		{"n-th last", []string{"^2", "new"}, "^2 new", "", "", errUnconfirmed},
+		{"skips others and deleted", []string{"^1"}, "^1", "m3", "", nil},
+		{"split", []string{"^3"}, "^3", "", "", errSplitMessage},
+		{"oldest", []string{"^4", "x"}, "^4   x", "m1", "x", nil},
+		{"too far back", []string{"^5"}, "^5", "", "", errNoSentMessage},
+		{"zero", []string{"^0"}, "^0", "", "", slash.ErrUsage},
+		{"caret in text", []string{"^up"}, "^up", "m3", "^up", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withHistory(t, msgs...)
+			history.markSplit("c1", "m2")
+
+			msg, text, err := pickSentMessage(tt.args, tt.text)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("pickSentMessage error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if msg.GetId() != tt.want || text != tt.wantText {
+				t.Errorf("pickSentMessage = %s, %q; want %s, %q", msg.GetId(), text, tt.want, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestEditThroughServer(t *testing.T) {
+	partSize := maxPartSize
+	maxPartSize = minPartSize
+	t.Cleanup(func() { maxPartSize = partSize })
+	srv := startFakeServer(t)
+	loginTo(t, srv, srv.addr, testEmail)
+	openConversation(t)
+	confirmed := func() bool {
+		msgs := history.last(conversation.GetId(), 1)
+		return len(msgs) == 1 && msgs[0].GetId() != ""
+	}
+
+	if err := sendMessage(strings.Repeat("x", 3*minPartSize)); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the split message is confirmed", confirmed)
+	if _, _, err := pickSentMessage(nil, ""); !errors.Is(err, errSplitMessage) {
+		t.Errorf("picking a split message: %v, want %v", err, errSplitMessage)
+	}
+
+	if err := sendMessage("tpyo"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the short message is confirmed", confirmed)
+	msg, _, err := pickSentMessage(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := editMessage(msg, "typo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := history.last(conversation.GetId(), 1)[0]; got.GetContent() != "typo" || got.GetEditedAt() == nil {
+		t.Errorf("after the edit the history holds %v", got)
+	}
+	if err := deleteMessage(history.last(conversation.GetId(), 1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pickSentMessage([]string{"^2"}, "^2"); !errors.Is(err, errNoSentMessage) {
+		t.Errorf("picking past the deleted message: %v, want %v", err, errNoSentMessage)
+	}
+
+	// Stored history names the split message by the same id as the live one.
+	response, err := chatClient().CreateConversation(context.Background(), &pkg.ConversationRequest{Members: []*pkg.Client{me()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, split := joinStoredParts(orderMessages(response.GetMessages()))
+	if live := history.last(conversation.GetId(), 2)[0].GetId(); len(split) != 1 || split[0] != live {
+		t.Errorf("stored history has split ids %q, want %q", split, live)
+	}
+}
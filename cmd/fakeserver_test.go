@@ -0,0 +1,782 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/abiosoft/readline"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// breachedPassword passes the client's own strength check, so only the
+// server can reject it.
+const breachedPassword = "password123"
+
+// unreachablePhone is a well formed phone number the server refuses.
+const unreachablePhone = "+15550100000"
+
+// fakeServer is an in-process chit-chat server for tests. It keeps accounts
+// in memory and delivers password reset codes to a file instead of mailing
+// them.
+type fakeServer struct {
+	pkg.UnimplementedAuthServer
+	pkg.UnimplementedChatroomServer
+
+	addr       string
+	server     *grpc.Server
+	resetCodes string
+
+	// answersPings has Converse send pings back, as servers that check
+	// stream liveness do.
+	answersPings bool
+	// rejectsGzip has unary calls fail the way a server without a gzip
+	// decompressor fails compressed requests.
+	rejectsGzip bool
+	// streamsStarted counts the Converse streams opened; the ones up to
+	// stalledUpTo are no longer served but stay open.
+	streamsStarted atomic.Int64
+	stalledUpTo    atomic.Int64
+
+	mu        sync.Mutex
+	passwords map[string]string
+	codes     map[string]string
+	logins    []string
+	logouts   []string
+	signups   []*pkg.SignUpRequest
+	// accounts holds each account by id, which stays the email it was
+	// created with.
+	accounts map[string]*pkg.Account
+	// streams holds the chat stream each logged in client is on, and
+	// conversations the messages sent in each conversation, stamped with an
+	// id and the time the server received them.
+	streams       map[string]*fakeStream
+	conversations map[string][]*pkg.ConversationMessage
+	lastId        int
+	// calls counts the unary calls to each method, and failures the ones
+	// still to fail before the method works again.
+	calls    map[string]int
+	failures map[string]failure
+	// blocking holds the methods whose calls wait for the client to give
+	// up, each with a channel told when a call arrives.
+	blocking map[string]chan struct{}
+	// encodings lists how each call's requests were compressed, as
+	// "method encoding", the encoding empty when they were not.
+	encodings []string
+}
+
+// failure is how a method fails and how many more times it does.
+type failure struct {
+	code codes.Code
+	n    int
+}
+
+// startFakeServer serves a fakeServer on a local port until the test ends.
+func startFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	s := &fakeServer{
+		resetCodes: filepath.Join(t.TempDir(), "reset-codes"),
+		passwords:  map[string]string{},
+		codes:      map[string]string{},
+		calls:      map[string]int{},
+		failures:   map[string]failure{},
+		blocking:   map[string]chan struct{}{},
+		accounts:   map[string]*pkg.Account{},
+
+		streams:       map[string]*fakeStream{},
+		conversations: map[string][]*pkg.ConversationMessage{},
+	}
+	s.serve(t, "127.0.0.1:0")
+	return s
+}
+
+// serve starts serving on addr, which may be the address the server had
+// before it was stopped.
+func (s *fakeServer) serve(t *testing.T, addr string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.addr = lis.Addr().String()
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(s.intercept), grpc.StatsHandler(encodingRecorder{s}))
+	pkg.RegisterAuthServer(s.server, s)
+	pkg.RegisterChatroomServer(s.server, s)
+	go s.server.Serve(lis)
+	t.Cleanup(s.server.Stop)
+}
+
+// stop takes the server down, closing every connection to it.
+func (s *fakeServer) stop() {
+	s.server.Stop()
+}
+
+// failFirst makes the next n calls to method fail with code.
+func (s *fakeServer) failFirst(method string, n int, code codes.Code) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = failure{code, n}
+}
+
+// callCount returns how many calls to method reached the server.
+func (s *fakeServer) callCount(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// block has calls to method wait until the client cancels them or their
+// deadline passes. The channel returned is told of each call as it arrives.
+func (s *fakeServer) block(method string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arrived := make(chan struct{}, 1)
+	s.blocking[method] = arrived
+	return arrived
+}
+
+// intercept counts every unary call, holds the ones block asked for and
+// fails the ones failFirst asked for.
+func (s *fakeServer) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	s.mu.Lock()
+	s.calls[method]++
+	arrived, blocked := s.blocking[method]
+	f, failing := s.failures[method]
+	if failing {
+		if f.n--; f.n <= 0 {
+			delete(s.failures, method)
+		} else {
+			s.failures[method] = f
+		}
+	}
+	s.mu.Unlock()
+	if blocked {
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if failing {
+		return nil, status.Errorf(f.code, "%s failed on purpose", method)
+	}
+	if s.rejectsGzip && requestEncoding(ctx) == "gzip" {
+		return nil, status.Error(codes.Unimplemented, `grpc: Decompressor is not installed for grpc-encoding "gzip"`)
+	}
+	return handler(ctx, req)
+}
+
+// requestEncodings returns how the requests of each call were compressed.
+func (s *fakeServer) requestEncodings() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.encodings...)
+}
+
+// encodingRecorder notes the compression of every call to a fakeServer,
+// and keeps it in the call's context for its handler.
+type encodingRecorder struct {
+	s *fakeServer
+}
+
+type encodingKey struct{}
+
+func requestEncoding(ctx context.Context) string {
+	if encoding, ok := ctx.Value(encodingKey{}).(*string); ok {
+		return *encoding
+	}
+	return ""
+}
+
+func (r encodingRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, encodingKey{}, new(string))
+}
+
+func (r encodingRecorder) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	header, ok := s.(*stats.InHeader)
+	if !ok {
+		return
+	}
+	if encoding, ok := ctx.Value(encodingKey{}).(*string); ok {
+		*encoding = header.Compression
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.encodings = append(r.s.encodings, path.Base(header.FullMethod)+" "+header.Compression)
+}
+
+func (r encodingRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r encodingRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+// addAccount creates an account that can login with password.
+func (s *fakeServer) addAccount(email string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwords[email] = password
+	if _, ok := s.accounts[email]; !ok {
+		s.accounts[email] = &pkg.Account{Id: email, Email: email, FirstName: email}
+	}
+}
+
+// account returns a copy of the account with id as the server has it.
+func (s *fakeServer) account(id string) *pkg.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return proto.Clone(s.accounts[id]).(*pkg.Account)
+}
+
+// accountByEmail returns the account using email, if any. s.mu must be held.
+func (s *fakeServer) accountByEmail(email string) *pkg.Account {
+	for _, acc := range s.accounts {
+		if acc.GetEmail() == email {
+			return acc
+		}
+	}
+	return nil
+}
+
+func (s *fakeServer) password(email string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.passwords[email]
+}
+
+// resetCode returns the last reset code delivered for email.
+func (s *fakeServer) resetCode(t *testing.T, email string) string {
+	t.Helper()
+	f, err := os.Open(s.resetCodes)
+	if err != nil {
+		t.Fatalf("no reset code was delivered: %v", err)
+	}
+	defer f.Close()
+	code := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if to, c, ok := strings.Cut(scanner.Text(), " "); ok && to == email {
+			code = c
+		}
+	}
+	if code == "" {
+		t.Fatalf("no reset code was delivered to %s", email)
+	}
+	return code
+}
+
+// checkNewPassword is the server's password policy, reported as a field
+// violation the way the real server does.
+func checkNewPassword(password string) error {
+	if password != breachedPassword {
+		return nil
+	}
+	st, _ := status.New(codes.InvalidArgument, "invalid password").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "new_password", Description: "appears in a known data breach"},
+		},
+	})
+	return st.Err()
+}
+
+func (s *fakeServer) SignIn(ctx context.Context, r *pkg.SignInRequest) (*pkg.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pw, ok := s.passwords[r.GetEmail()]; !ok || pw != r.GetPassword() {
+		return nil, status.Error(codes.Unauthenticated, "wrong email or password")
+	}
+	if acc := s.accountByEmail(r.GetEmail()); acc != nil {
+		return proto.Clone(acc).(*pkg.Account), nil
+	}
+	return &pkg.Account{Id: r.GetEmail(), Email: r.GetEmail(), FirstName: r.GetEmail()}, nil
+}
+
+// SearchAccounts returns the accounts whose name or email contains the
+// query, ignoring case.
+func (s *fakeServer) SearchAccounts(ctx context.Context, r *pkg.SearchAccountsRequest) (*pkg.SearchAccountsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := strings.ToLower(r.GetSearchQuery())
+	response := &pkg.SearchAccountsResponse{}
+	for _, acc := range s.accounts {
+		text := strings.ToLower(acc.GetFirstName() + " " + acc.GetLastName() + " " + acc.GetEmail())
+		if strings.Contains(text, query) {
+			response.Members = append(response.Members, proto.Clone(acc).(*pkg.Account))
+		}
+	}
+	slices.SortFunc(response.Members, func(a, b *pkg.Account) int { return strings.Compare(a.GetId(), b.GetId()) })
+	return response, nil
+}
+
+func (s *fakeServer) GetAccount(ctx context.Context, r *pkg.GetAccountRequest) (*pkg.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, ok := s.accounts[r.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such account")
+	}
+	return proto.Clone(acc).(*pkg.Account), nil
+}
+
+// UpdateAccount changes the fields named in the request, refusing an email
+// another account uses and an unreachable phone number.
+func (s *fakeServer) UpdateAccount(ctx context.Context, r *pkg.UpdateAccountRequest) (*pkg.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, ok := s.accounts[r.GetAccount().GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no such account")
+	}
+	updated := proto.Clone(acc).(*pkg.Account)
+	for _, field := range r.GetUpdateFields() {
+		switch field {
+		case "first_name":
+			updated.FirstName = r.GetAccount().GetFirstName()
+		case "last_name":
+			updated.LastName = r.GetAccount().GetLastName()
+		case "email":
+			if other := s.accountByEmail(r.GetAccount().GetEmail()); other != nil && other != acc {
+				return nil, status.Error(codes.AlreadyExists, "an account with that email exists")
+			}
+			updated.Email = r.GetAccount().GetEmail()
+		case "phone_number":
+			if r.GetAccount().GetPhoneNumber() == unreachablePhone {
+				st, _ := status.New(codes.InvalidArgument, "invalid account").WithDetails(&errdetails.BadRequest{
+					FieldViolations: []*errdetails.BadRequest_FieldViolation{
+						{Field: "phone_number", Description: "is not in service"},
+					},
+				})
+				return nil, st.Err()
+			}
+			updated.PhoneNumber = r.GetAccount().GetPhoneNumber()
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field %s", field)
+		}
+	}
+	s.accounts[acc.GetId()] = updated
+	return proto.Clone(updated).(*pkg.Account), nil
+}
+
+// SignUp creates an account, refusing a taken email, a breached password
+// and an unreachable phone number the way the real server does.
+func (s *fakeServer) SignUp(ctx context.Context, r *pkg.SignUpRequest) (*pkg.SignUpResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signups = append(s.signups, r)
+	if _, ok := s.passwords[r.GetEmail()]; ok {
+		return nil, status.Error(codes.AlreadyExists, "an account with that email exists")
+	}
+	var violations []*errdetails.BadRequest_FieldViolation
+	if r.GetPassword() == breachedPassword {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: "password", Description: "appears in a known data breach"})
+	}
+	if r.GetPhoneNumber() == unreachablePhone {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: "phoneNumber", Description: "is not in service"})
+	}
+	if len(violations) > 0 {
+		st, _ := status.New(codes.InvalidArgument, "invalid signup").WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+		return nil, st.Err()
+	}
+	s.passwords[r.GetEmail()] = r.GetPassword()
+	s.accounts[r.GetEmail()] = &pkg.Account{
+		Id:          r.GetEmail(),
+		Email:       r.GetEmail(),
+		FirstName:   r.GetFirstName(),
+		LastName:    r.GetLastName(),
+		PhoneNumber: r.GetPhoneNumber(),
+	}
+	return &pkg.SignUpResponse{Id: r.GetEmail()}, nil
+}
+
+// signedUp returns the signup requests the server received, in order.
+func (s *fakeServer) signedUp() []*pkg.SignUpRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*pkg.SignUpRequest(nil), s.signups...)
+}
+
+func (s *fakeServer) ChangePassword(ctx context.Context, r *pkg.ChangePasswordRequest) (*pkg.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.passwords[r.GetEmail()] != r.GetCurrentPassword() {
+		return nil, status.Error(codes.Unauthenticated, "wrong password")
+	}
+	if err := checkNewPassword(r.GetNewPassword()); err != nil {
+		return nil, err
+	}
+	s.passwords[r.GetEmail()] = r.GetNewPassword()
+	return &pkg.Empty{}, nil
+}
+
+func (s *fakeServer) RequestPasswordReset(ctx context.Context, r *pkg.PasswordResetRequest) (*pkg.Empty, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return nil, err
+	}
+	code := fmt.Sprintf("%06d", n)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.passwords[r.GetEmail()]; !ok {
+		return &pkg.Empty{}, nil
+	}
+	s.codes[r.GetEmail()] = code
+	f, err := os.OpenFile(s.resetCodes, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, r.GetEmail(), code)
+	return &pkg.Empty{}, err
+}
+
+func (s *fakeServer) ConfirmPasswordReset(ctx context.Context, r *pkg.ConfirmPasswordResetRequest) (*pkg.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code := s.codes[r.GetEmail()]; code == "" || code != r.GetCode() {
+		return nil, status.Error(codes.NotFound, "no such reset code")
+	}
+	if err := checkNewPassword(r.GetNewPassword()); err != nil {
+		return nil, err
+	}
+	delete(s.codes, r.GetEmail())
+	s.passwords[r.GetEmail()] = r.GetNewPassword()
+	return &pkg.Empty{}, nil
+}
+
+// loggedIn returns the client ids that logged in on a chat stream, in order.
+func (s *fakeServer) loggedIn() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.logins...)
+}
+
+// loggedOut returns the client ids that sent a logout event, in order.
+func (s *fakeServer) loggedOut() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.logouts...)
+}
+
+// stallStreams stops serving the chat streams open now without closing
+// them, like a server whose stream handlers hang. Later streams are served.
+func (s *fakeServer) stallStreams() {
+	s.stalledUpTo.Store(s.streamsStarted.Load())
+}
+
+// fakeStream is a chat stream several server goroutines may send on.
+type fakeStream struct {
+	mu     sync.Mutex
+	stream pkg.Chatroom_ConverseServer
+}
+
+func (f *fakeStream) send(event *pkg.ChatEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stream.Send(event)
+}
+
+func (s *fakeServer) Converse(stream pkg.Chatroom_ConverseServer) error {
+	n := s.streamsStarted.Add(1)
+	fs := &fakeStream{stream: stream}
+	var clientId string
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.streams[clientId] == fs {
+			delete(s.streams, clientId)
+		}
+	}()
+	for {
+		in, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		if n <= s.stalledUpTo.Load() {
+			<-stream.Context().Done()
+			return nil
+		}
+		switch {
+		case in.GetLogin() != nil:
+			clientId = in.GetLogin().GetClientId()
+			s.mu.Lock()
+			s.logins = append(s.logins, clientId)
+			s.streams[clientId] = fs
+			s.mu.Unlock()
+			err = fs.send(in)
+		case in.GetLogout() != nil:
+			s.mu.Lock()
+			s.logouts = append(s.logouts, in.GetLogout().GetClientId())
+			s.mu.Unlock()
+		case in.GetMessage() != nil:
+			s.stamp(in.GetMessage())
+			s.forward(in.GetMessage().GetConversation(), in)
+		case in.GetEdit() != nil:
+			s.forward(in.GetEdit().GetConversation(), in)
+		case in.GetDelete() != nil:
+			s.forward(in.GetDelete().GetConversation(), in)
+		case in.GetPing() != nil && s.answersPings:
+			err = fs.send(in)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stamp gives msg an id and the time it was received, and stores it in its
+// conversation.
+func (s *fakeServer) stamp(msg *pkg.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastId++
+	msg.Id = fmt.Sprintf("m%d", s.lastId)
+	msg.ReceivedAt = timestamppb.Now()
+	id := msg.GetConversation().GetId()
+	s.conversations[id] = append(s.conversations[id], &pkg.ConversationMessage{
+		From:       msg.GetFrom(),
+		Content:    msg.GetContent(),
+		Id:         msg.GetId(),
+		SentAt:     msg.GetSentAt(),
+		ReceivedAt: msg.GetReceivedAt(),
+	})
+}
+
+// forward sends event to the members of conversation that are logged in,
+// the sender included.
+func (s *fakeServer) forward(conversation *pkg.Conversation, event *pkg.ChatEvent) {
+	for _, member := range conversation.GetMembers() {
+		s.mu.Lock()
+		fs := s.streams[member.GetClientId()]
+		s.mu.Unlock()
+		if fs != nil {
+			fs.send(event)
+		}
+	}
+}
+
+// redeliver sends the messages stored in a conversation to its members
+// again, as a server replaying after a reconnect does.
+func (s *fakeServer) redeliver(conversation *pkg.Conversation) {
+	s.mu.Lock()
+	stored := append([]*pkg.ConversationMessage(nil), s.conversations[conversation.GetId()]...)
+	s.mu.Unlock()
+	for _, msg := range stored {
+		s.forward(conversation, &pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{
+			Conversation: conversation,
+			From:         msg.GetFrom(),
+			Content:      msg.GetContent(),
+			Id:           msg.GetId(),
+			SentAt:       msg.GetSentAt(),
+			ReceivedAt:   msg.GetReceivedAt(),
+		}}})
+	}
+}
+
+// CreateConversation names a conversation after its members and returns the
+// messages stored in it.
+func (s *fakeServer) CreateConversation(ctx context.Context, r *pkg.ConversationRequest) (*pkg.ConversationResponse, error) {
+	var ids []string
+	for _, member := range r.GetMembers() {
+		ids = append(ids, member.GetClientId())
+	}
+	slices.Sort(ids)
+	id := strings.Join(ids, ",")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &pkg.ConversationResponse{
+		Id:       id,
+		Members:  r.GetMembers(),
+		Messages: append([]*pkg.ConversationMessage(nil), s.conversations[id]...),
+	}, nil
+}
+
+// connectTo points the client's globals at addr for the rest of the test.
+func connectTo(t *testing.T, addr string) {
+	t.Helper()
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := connect(addr); err != nil {
+		t.Fatalf("connect(%q): %v", addr, err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		connection().Close()
+		connectionWatchers.Wait()
+	})
+}
+
+// openConversation starts a conversation with only the logged in user in it
+// and makes it the open one.
+func openConversation(t *testing.T) {
+	t.Helper()
+	response, err := chatClient().CreateConversation(context.Background(), &pkg.ConversationRequest{Members: []*pkg.Client{me()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation = pkg.Conversation{Id: response.GetId(), Members: response.GetMembers()}
+	t.Cleanup(func() { conversation = pkg.Conversation{} })
+}
+
+// syncBuffer is a bytes.Buffer readline can write to from its goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestShell returns a shell reading from in and printing to out.
+func newTestShell(t *testing.T, in io.ReadCloser, out io.Writer) *ishell.Shell {
+	t.Helper()
+	return ishell.NewWithReadline(newTestReadline(t, &readline.Config{}, in, out))
+}
+
+// newTestReadline returns a line editor configured by config, reading from
+// in and printing to out, that is closed when the test ends.
+func newTestReadline(t *testing.T, config *readline.Config, in io.ReadCloser, out io.Writer) *readline.Instance {
+	t.Helper()
+	stdin := &startedReader{ReadCloser: in, started: make(chan struct{})}
+	config.Stdin, config.Stdout, config.Stderr = stdin, out, out
+	config.FuncIsTerminal = func() bool { return false }
+	rl, err := readline.NewEx(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// readline's reading goroutine registers itself with the wait group
+		// Close waits on, so closing before it runs is a data race. Have it
+		// read once to be sure it is running.
+		rl.Terminal.KickRead()
+		select {
+		case <-stdin.started:
+		case <-time.After(5 * time.Second):
+		}
+		rl.Close()
+	})
+	return rl
+}
+
+// startedReader tells when readline first reads from it.
+type startedReader struct {
+	io.ReadCloser
+	once    sync.Once
+	started chan struct{}
+}
+
+func (r *startedReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { close(r.started) })
+	return r.ReadCloser.Read(p)
+}
+
+// terminal drives a shell command the way a user at a terminal would.
+type terminal struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *syncBuffer
+	seen int
+	done chan struct{}
+}
+
+// startCommand runs a shell command in the background, reading its input
+// from the returned terminal.
+func startCommand(t *testing.T, run func(*ishell.Context)) *terminal {
+	t.Helper()
+	in, w := io.Pipe()
+	term := &terminal{t: t, in: w, out: &syncBuffer{}, done: make(chan struct{})}
+	sh := newTestShell(t, in, term.out)
+	sh.AddCmd(&ishell.Cmd{Name: "test", Func: run})
+	go func() {
+		defer close(term.done)
+		sh.Process("test")
+	}()
+	t.Cleanup(func() {
+		w.Close()
+		<-term.done
+	})
+	return term
+}
+
+// expect waits for the command to print text after what was already seen.
+func (term *terminal) expect(text string) {
+	term.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out := term.out.String()
+		if i := strings.Index(out[term.seen:], text); i >= 0 {
+			term.seen += i + len(text)
+			return
+		}
+		if time.Now().After(deadline) {
+			term.t.Fatalf("timed out waiting for %q; the command printed %q", text, out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// answer waits for prompt and types line in reply.
+func (term *terminal) answer(prompt string, line string) {
+	term.t.Helper()
+	term.expect(prompt)
+	if _, err := io.WriteString(term.in, line+"\n"); err != nil {
+		term.t.Fatalf("typing %q: %v", line, err)
+	}
+}
+
+// wait waits for the command to finish and returns everything it printed.
+func (term *terminal) wait() string {
+	term.t.Helper()
+	select {
+	case <-term.done:
+	case <-time.After(5 * time.Second):
+		term.t.Fatalf("the command did not finish; it printed %q", term.out.String())
+	}
+	return term.out.String()
+}
+
+// runCommand runs a shell command, answering its prompts with input one
+// line at a time, and returns everything it printed.
+func runCommand(t *testing.T, run func(*ishell.Context), input ...string) string {
+	t.Helper()
+	term := startCommand(t, run)
+	go func() {
+		for _, line := range input {
+			io.WriteString(term.in, line+"\n")
+		}
+	}()
+	return term.wait()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+const (
+	maxMissedHeartbeats = 2
+	maxRecoveryBackoff  = 30 * time.Second
+)
+
+var keepaliveTime time.Duration
+var keepaliveTimeout time.Duration
+var heartbeatInterval time.Duration
+var heartbeatTimeout time.Duration
+
+// sessionCtx lives from login until logout and stops the heartbeat and any
+// recovery in progress.
+var sessionCtx context.Context = context.Background()
+var sessionCancel context.CancelFunc = func() {}
+
+var recovering atomic.Bool
+
+var errStreamSilent = errors.New("the chat stream stopped answering pings")
+
+// streamPings tracks the pings sent on the Converse stream. Servers that do
+// not know pings ignore them, so a silent stream only counts as dead once
+// the server has answered a ping on it.
+var streamPings struct {
+	seq       atomic.Uint64
+	answered  atomic.Bool
+	lastReply atomic.Int64
+}
+
+// sessionGoroutines tracks the goroutines working for the logged in user, so
+// logout can wait for them before the next user logs in.
+var sessionGoroutines sync.WaitGroup
+
+// goSession runs f in a goroutine tracked by sessionGoroutines.
+func goSession(f func()) {
+	sessionGoroutines.Add(1)
+	go func() {
+		defer sessionGoroutines.Done()
+		f()
+	}()
+}
+
+func keepaliveDialOptions() []grpc.DialOption {
+	if keepaliveTime <= 0 {
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:    keepaliveTime,
+		Timeout: keepaliveTimeout,
+	})}
+}
+
+// ping checks that the server is still answering on the current connection.
+// Any reply counts, including Unimplemented from servers without the health
+// service; only silence or a transport error means the connection is dead.
+func ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+	defer cancel()
+
+	_, err := healthpb.NewHealthClient(connection()).Check(ctx, &healthpb.HealthCheckRequest{})
+	switch status.Code(err) {
+	case codes.OK, codes.Unimplemented, codes.NotFound:
+		return nil
+	}
+	return err
+}
+
+// pingStream sends the next ping on the Converse stream. It fails when the
+// server, having answered pings on this stream, has not answered one for
+// longer than a heartbeat interval and timeout: the connection may be fine
+// while the stream is no longer served.
+func pingStream(ctx context.Context) error {
+	if streamPings.answered.Load() {
+		silent := time.Since(time.Unix(0, streamPings.lastReply.Load()))
+		if silent > heartbeatInterval+heartbeatTimeout {
+			return fmt.Errorf("%w for %s", errStreamSilent, silent.Round(time.Millisecond))
+		}
+	}
+	ping := &pkg.Ping{Seq: streamPings.seq.Add(1)}
+	return sendEvent(ctx, &pkg.ChatEvent{Command: &pkg.ChatEvent_Ping{Ping: ping}})
+}
+
+// pingAnswered records a ping coming back on the Converse stream.
+func pingAnswered() {
+	streamPings.lastReply.Store(time.Now().UnixNano())
+	streamPings.answered.Store(true)
+}
+
+// resetStreamPings forgets the pings answered on the previous stream, which
+// may have been served by another backend.
+func resetStreamPings() {
+	streamPings.answered.Store(false)
+}
+
+// heartbeat pings the server and the chat stream every heartbeatInterval for
+// as long as ctx lives and starts recovery once too many pings in a row go
+// unanswered.
+func heartbeat(ctx context.Context, c *ishell.Context) {
+	if heartbeatInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if recovering.Load() {
+			continue
+		}
+
+		err := ping(ctx)
+		if err == nil {
+			err = pingStream(ctx)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			missed++
+			setDegraded(true)
+			logger.Warn("heartbeat missed", "missed", missed, "err", err)
+			if missed >= maxMissedHeartbeats {
+				missed = 0
+				recoverSession(ctx, c, "server stopped answering heartbeats")
+			}
+			continue
+		}
+		if missed > 0 {
+			setDegraded(false)
+		}
+		missed = 0
+	}
+}
+
+// recoverSession replaces a dead connection: it drops the stream and the
+// underlying connection, then redials and logs back in with backoff until it
+// succeeds or the session ends. When the server has several backends the
+// stream first fails over on the existing connection.
+func recoverSession(ctx context.Context, c *ishell.Context, reason string) {
+	if !recovering.CompareAndSwap(false, true) {
+		return
+	}
+	defer recovering.Store(false)
+	setReconnecting(true)
+	defer setReconnecting(false)
+
+	logger.Warn("session degraded", "reason", reason)
+
+	cancelStream()
+	// With several backends behind the connection only the one the stream
+	// was pinned to is gone, so first try moving the stream to another.
+	redial := !multipleBackends(serverAddress)
+	if redial {
+		connection().Close()
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		var err error
+		if redial {
+			err = connect(serverAddress)
+		}
+		redial = true
+		if err == nil {
+			err = startStream()
+		}
+		if err == nil {
+			err = login(ctx)
+		}
+		if err == nil {
+			break
+		}
+		logger.Warn("recovery attempt failed", "attempt", attempt, "err", err)
+		if conn := connection(); conn != nil {
+			conn.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRecoveryBackoff {
+			backoff = maxRecoveryBackoff
+		}
+	}
+
+	setDegraded(false)
+	reconnects.Add(ctx, 1)
+	logger.Info("session recovered")
+	goSession(func() { receive(c, selectedAccount) })
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// fastHeartbeat makes the heartbeat tick quickly for the rest of the test.
+func fastHeartbeat(t *testing.T) {
+	t.Helper()
+	interval, timeout := heartbeatInterval, heartbeatTimeout
+	heartbeatInterval, heartbeatTimeout = 50*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { heartbeatInterval, heartbeatTimeout = interval, timeout })
+}
+
+// loginTo connects to addr, which leads to srv, and logs in as email with
+// the login command, ending the session when the test ends.
+func loginTo(t *testing.T, srv *fakeServer, addr string, email string) *terminal {
+	t.Helper()
+	serverAddress = addr
+	t.Cleanup(func() { serverAddress = "" })
+	resetSession(t)
+	selectedAccount = &pkg.Account{}
+	connectTo(t, addr)
+	if err := setState(stateConnected); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(endSession)
+	return loginAs(t, srv, email)
+}
+
+// loginAs runs the login command as email on the connection already open,
+// failing the test unless it ends up streaming.
+func loginAs(t *testing.T, srv *fakeServer, email string) *terminal {
+	t.Helper()
+	srv.addAccount(email, "pass-word1")
+	term := startCommand(t, runLogin)
+	term.answer("Email: ", email)
+	term.answer("Password: ", "pass-word1")
+	term.wait()
+	if got := currentState(); got != stateStreaming {
+		t.Fatalf("the session is %s after login, want streaming; login printed %q", got, term.out.String())
+	}
+	return term
+}
+
+// eventually waits for cond, failing the test with what if it does not
+// hold within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// freezingProxy forwards connections to a server until frozen. Frozen, it
+// stops passing bytes on but keeps every socket open, like a server that
+// hangs without closing its connections.
+type freezingProxy struct {
+	addr   string
+	frozen atomic.Bool
+}
+
+func startFreezingProxy(t *testing.T, target string) *freezingProxy {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &freezingProxy{addr: lis.Addr().String()}
+	var conns sync.WaitGroup
+	t.Cleanup(func() {
+		lis.Close()
+		p.frozen.Store(false)
+		conns.Wait()
+	})
+	go func() {
+		for {
+			client, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			closeBoth := func() { client.Close(); server.Close() }
+			t.Cleanup(closeBoth)
+			conns.Add(2)
+			go func() { defer conns.Done(); p.copy(server, client); closeBoth() }()
+			go func() { defer conns.Done(); p.copy(client, server); closeBoth() }()
+		}
+	}()
+	return p
+}
+
+// copy passes bytes from src to dst, holding them back while frozen.
+func (p *freezingProxy) copy(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		for p.frozen.Load() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestHeartbeatRecoversFromSilentServer(t *testing.T) {
+	fastHeartbeat(t)
+	srv := startFakeServer(t)
+	proxy := startFreezingProxy(t, srv.addr)
+	loginTo(t, srv, proxy.addr, testEmail)
+
+	proxy.frozen.Store(true)
+	eventually(t, "the heartbeat notices the server stopped answering", func() bool {
+		return currentStatus() == statusReconnecting
+	})
+	if got := len(srv.loggedIn()); got != 1 {
+		t.Fatalf("logged in %d times while the server was silent, want once", got)
+	}
+
+	proxy.frozen.Store(false)
+	eventually(t, "the session is recovered", func() bool {
+		return len(srv.loggedIn()) == 2 && !recovering.Load()
+	})
+}
+
+func TestHeartbeatRecoversFromDeadStream(t *testing.T) {
+	fastHeartbeat(t)
+	srv := startFakeServer(t)
+	srv.answersPings = true
+	loginTo(t, srv, srv.addr, testEmail)
+	eventually(t, "the server answers a ping on the stream", streamPings.answered.Load)
+
+	// Unary calls still work, so only the stream pings can tell.
+	srv.stallStreams()
+	eventually(t, "the session is recovered on a new stream", func() bool {
+		return len(srv.loggedIn()) == 2 && !recovering.Load()
+	})
+	if got := srv.streamsStarted.Load(); got != 2 {
+		t.Errorf("opened %d chat streams, want 2", got)
+	}
+	eventually(t, "the new stream answers pings", streamPings.answered.Load)
+}
+
+func TestSendWhileRecovering(t *testing.T) {
+	fastHeartbeat(t)
+	srv := startFakeServer(t)
+	srv.answersPings = true
+	loginTo(t, srv, srv.addr, testEmail)
+	openConversation(t)
+	eventually(t, "the server answers a ping on the stream", streamPings.answered.Load)
+
+	// Keep sending and calling the server while recovery swaps the
+	// connection and stream underneath.
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sendMessage("during recovery")
+			authClient().SignIn(context.Background(), &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"})
+			connection().GetState()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	srv.stallStreams()
+	eventually(t, "the session is recovered", func() bool {
+		return len(srv.loggedIn()) == 2 && !recovering.Load()
+	})
+	close(stop)
+	<-done
+
+	if err := sendMessage("after recovery"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the message sent after recovery is echoed", func() bool {
+		msgs := history.last(conversation.GetId(), 1)
+		return len(msgs) == 1 && msgs[0].GetContent() == "after recovery" && msgs[0].GetId() != ""
+	})
+}
+
+func TestHeartbeatIgnoresServersWithoutPings(t *testing.T) {
+	fastHeartbeat(t)
+	srv := startFakeServer(t)
+	loginTo(t, srv, srv.addr, testEmail)
+
+	time.Sleep(10 * heartbeatInterval)
+	if streamPings.answered.Load() {
+		t.Fatal("a server that does not answer pings answered one")
+	}
+	if got := len(srv.loggedIn()); got != 1 || currentStatus() != statusOnline {
+		t.Errorf("logged in %d times and the status is %s, want one login and online", got, currentStatus())
+	}
+}
+
+func TestPingStreamReportsSilence(t *testing.T) {
+	interval, timeout := heartbeatInterval, heartbeatTimeout
+	heartbeatInterval, heartbeatTimeout = time.Second, time.Second
+	t.Cleanup(func() { heartbeatInterval, heartbeatTimeout = interval, timeout })
+	sent := &sentEvents{}
+	useStream(t, sent)
+	t.Cleanup(resetStreamPings)
+
+	tests := []struct {
+		name     string
+		answered bool
+		silent   time.Duration
+		err      error
+	}{
+		{"never answered", false, time.Hour, nil},
+		{"answered recently", true, time.Second, nil},
+		{"silent too long", true, 3 * time.Second, errStreamSilent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent.events = nil
+			streamPings.answered.Store(tt.answered)
+			streamPings.lastReply.Store(time.Now().Add(-tt.silent).UnixNano())
+			err := pingStream(context.Background())
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("pingStream = %v, want %v", err, tt.err)
+			}
+			if wantSent := tt.err == nil; (len(sent.events) == 1) != wantSent {
+				t.Errorf("pingStream sent %d events", len(sent.events))
+			}
+			if len(sent.events) == 1 && sent.events[0].GetPing().GetSeq() == 0 {
+				t.Errorf("the ping has no sequence number")
+			}
+		})
+	}
+}
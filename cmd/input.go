@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abiosoft/readline"
+)
+
+const (
+	// inputNewline stands in for a newline inside the line being edited.
+	// Pasted text and Alt-Enter insert it; it becomes "\n" when sent.
+	inputNewline = '␤'
+
+	codeFence = "```"
+
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+	pasteStart        = "\x1b[200~"
+	pasteEnd          = "\x1b[201~"
+	altEnter          = "\x1b\r"
+)
+
+// lineEditor is the readline instance shared by the shell and the chat view.
+var lineEditor *readline.Instance
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func historyPath(name string) string {
+	return filepath.Join(filepath.Dir(defaultLogPath()), "history", unsafeFileChars.ReplaceAllString(name, "_"))
+}
+
+func newLineEditor(keymap string) (*readline.Instance, error) {
+	if keymap != "emacs" && keymap != "vi" {
+		return nil, fmt.Errorf("unknown keymap %q, expected emacs or vi", keymap)
+	}
+	if err := os.MkdirAll(filepath.Dir(historyPath("shell")), 0o700); err != nil {
+		logger.Error("failed to create history directory", "err", err)
+	}
+	return readline.NewEx(&readline.Config{
+		Prompt:      ">>> ",
+		HistoryFile: historyPath("shell"),
+		VimMode:     keymap == "vi",
+		Stdin:       newPasteReader(readline.NewCancelableStdin(readline.Stdin)),
+	})
+}
+
+// useHistory switches the line editor to the history kept under name,
+// e.g. one per conversation, loading it from disk.
+func useHistory(name string) {
+	config := lineEditor.Config.Clone()
+	config.HistoryFile = historyPath(name)
+	lineEditor.SetConfig(config)
+}
+
+// enterChatInput prepares the line editor for composing messages in
+// conversationId and returns a function restoring the shell's settings.
+func enterChatInput(conversationId string) func() {
+	useHistory("conversation-" + conversationId)
+	if readline.DefaultIsTerminal() {
+		io.WriteString(os.Stdout, bracketedPasteOn)
+	}
+	return func() {
+		if readline.DefaultIsTerminal() {
+			io.WriteString(os.Stdout, bracketedPasteOff)
+		}
+		useHistory("shell")
+	}
+}
+
+// composedText turns an edited line back into message text.
+func composedText(line string) string {
+	return strings.ReplaceAll(line, string(inputNewline), "\n")
+}
+
+// opensCodeBlock reports whether line starts a ``` block that it does not
+// also close, in which case the following lines belong to the same message.
+func opensCodeBlock(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), codeFence) && strings.Count(line, codeFence)%2 == 1
+}
+
+// escapeDelay is how long a trailing escape waits for the rest of a
+// sequence before it is taken as the Escape key on its own.
+const escapeDelay = 50 * time.Millisecond
+
+// pasteReader sits between the terminal and readline. Newlines inside a
+// bracketed paste, and Alt-Enter, are replaced by inputNewline so they are
+// edited and sent as part of the current message instead of submitting it.
+type pasteReader struct {
+	r io.ReadCloser
+
+	start     sync.Once
+	chunks    chan readChunk
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	pasting bool
+	pending []byte
+	out     bytes.Buffer
+	err     error
+}
+
+func newPasteReader(r io.ReadCloser) *pasteReader {
+	return &pasteReader{r: r, chunks: make(chan readChunk), closed: make(chan struct{})}
+}
+
+// readChunk is what one read of the terminal returned.
+type readChunk struct {
+	data []byte
+	err  error
+}
+
+// readAll reads the terminal until it fails, so that Read can wait for the
+// rest of an escape sequence with a timeout.
+func (p *pasteReader) readAll() {
+	for {
+		buf := make([]byte, 1024)
+		n, err := p.r.Read(buf)
+		select {
+		case p.chunks <- readChunk{buf[:n], err}:
+		case <-p.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *pasteReader) Read(b []byte) (int, error) {
+	p.start.Do(func() { go p.readAll() })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.out.Len() == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		// A partial sequence held back is passed on as it is when nothing
+		// follows soon, so the Escape key works in vi mode.
+		var timeout <-chan time.Time
+		if len(p.pending) > 0 {
+			timeout = time.After(escapeDelay)
+		}
+		select {
+		case chunk := <-p.chunks:
+			if len(chunk.data) > 0 {
+				p.filter(append(p.pending, chunk.data...))
+			}
+			if chunk.err != nil {
+				p.out.Write(p.pending)
+				p.pending, p.err = nil, chunk.err
+			}
+		case <-timeout:
+			p.out.Write(p.pending)
+			p.pending = nil
+		case <-p.closed:
+			p.err = io.EOF
+		}
+	}
+	return p.out.Read(b)
+}
+
+func (p *pasteReader) filter(in []byte) {
+	p.pending = nil
+	for len(in) > 0 {
+		switch {
+		case bytes.HasPrefix(in, []byte(pasteStart)):
+			p.pasting = true
+			in = in[len(pasteStart):]
+			continue
+		case bytes.HasPrefix(in, []byte(pasteEnd)):
+			p.pasting = false
+			in = in[len(pasteEnd):]
+			continue
+		case !p.pasting && bytes.HasPrefix(in, []byte(altEnter)):
+			p.out.WriteRune(inputNewline)
+			in = in[len(altEnter):]
+			continue
+		case in[0] == '\x1b' && isMarkerPrefix(in):
+			// Wait for the rest of an Alt-Enter or paste marker split
+			// across reads.
+			p.pending = append([]byte(nil), in...)
+			return
+		}
+
+		if p.pasting && (in[0] == '\r' || in[0] == '\n') {
+			if in[0] == '\r' && len(in) > 1 && in[1] == '\n' {
+				in = in[1:]
+			}
+			p.out.WriteRune(inputNewline)
+		} else {
+			p.out.WriteByte(in[0])
+		}
+		in = in[1:]
+	}
+}
+
+func isMarkerPrefix(in []byte) bool {
+	return len(in) < len(pasteStart) &&
+		(bytes.HasPrefix([]byte(pasteStart), in) || bytes.HasPrefix([]byte(pasteEnd), in))
+}
+
+func (p *pasteReader) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return p.r.Close()
+}
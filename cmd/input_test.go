@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/abiosoft/readline"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// typed feeds chunks to a pasteReader one read at a time, as a terminal
+// delivers input, and returns everything read from it.
+func typed(t *testing.T, chunks ...string) string {
+	t.Helper()
+	r, w := io.Pipe()
+	p := newPasteReader(r)
+	go func() {
+		for _, chunk := range chunks {
+			io.WriteString(w, chunk)
+		}
+		w.Close()
+	}()
+	out, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPasteReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"typed line", []string{"hi\r"}, "hi\r"},
+		{"paste", []string{pasteStart + "a\nb\r\nc" + pasteEnd + "\r"}, "a␤b␤c\r"},
+		{"paste in several reads", []string{pasteStart + "a\n", "b\n", "c" + pasteEnd + "\r"}, "a␤b␤c\r"},
+		{"start marker split", []string{"\x1b[20", "0~a\nb" + pasteEnd}, "a␤b"},
+		{"end marker split", []string{pasteStart + "a\nb\x1b[2", "01~\r"}, "a␤b\r"},
+		{"lone escape before a paste", []string{"\x1b", "[200~a\nb" + pasteEnd}, "a␤b"},
+		{"lone escape before the end", []string{pasteStart + "a\nb\x1b", "[201~\r"}, "a␤b\r"},
+		{"marker split byte by byte", strings.Split(pasteStart+"a\nb"+pasteEnd, ""), "a␤b"},
+		{"Alt-Enter", []string{"a" + altEnter + "b\r"}, "a␤b\r"},
+		{"Alt-Enter split", []string{"a\x1b", "\rb\r"}, "a␤b\r"},
+		{"escape then a key", []string{"\x1b", "j"}, "\x1bj"},
+		{"arrow key", []string{"\x1b[A"}, "\x1b[A"},
+		{"escape at the end", []string{"a\x1b"}, "a\x1b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := typed(t, tt.chunks...); got != tt.want {
+				t.Errorf("read %q from %q, want %q", got, tt.chunks, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasteReaderEscapeKey(t *testing.T) {
+	r, w := io.Pipe()
+	p := newPasteReader(r)
+	t.Cleanup(func() { p.Close() })
+	go io.WriteString(w, "a\x1b")
+
+	var got []byte
+	start := time.Now()
+	buf := make([]byte, 16)
+	for len(got) < 2 && time.Since(start) < time.Second {
+		n, err := p.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != "a\x1b" {
+		t.Errorf("read %q, want the escape passed on with nothing following it", got)
+	}
+}
+
+func TestComposedText(t *testing.T) {
+	if got := composedText("a␤b␤␤c"); got != "a\nb\n\nc" {
+		t.Errorf("composedText = %q", got)
+	}
+}
+
+func TestOpensCodeBlock(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"```", true},
+		{"```go", true},
+		{"  ```go", true},
+		{"```go␤x := 1", true},
+		{"```x := 1```", false},
+		{"``` a ``` b ```", true},
+		{"see ```", false},
+		{"hello", false},
+	}
+	for _, tt := range tests {
+		if got := opensCodeBlock(tt.line); got != tt.want {
+			t.Errorf("opensCodeBlock(%q) = %t, want %t", tt.line, got, tt.want)
+		}
+	}
+}
+
+// useLineEditor gives the chat a line editor keeping its history in a
+// temporary directory for the rest of the test.
+func useLineEditor(t *testing.T) {
+	t.Helper()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	t.Setenv("LocalAppData", cache)
+	if err := os.MkdirAll(filepath.Dir(historyPath("shell")), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	saved := lineEditor
+	lineEditor = newTestReadline(t, &readline.Config{HistoryFile: historyPath("shell")}, io.NopCloser(strings.NewReader("")), io.Discard)
+	t.Cleanup(func() { lineEditor = saved })
+}
+
+func TestHistoryPerConversation(t *testing.T) {
+	useLineEditor(t)
+	lineEditor.SaveHistory("connect")
+
+	restore := enterChatInput("c1")
+	if got := lineEditor.Config.HistoryFile; got != historyPath("conversation-c1") {
+		t.Errorf("the chat in c1 keeps its history in %s", got)
+	}
+	lineEditor.SaveHistory("hello c1")
+	restore()
+	if got := lineEditor.Config.HistoryFile; got != historyPath("shell") {
+		t.Errorf("the shell keeps its history in %s after the chat", got)
+	}
+	lineEditor.SaveHistory("whoami")
+
+	restore = enterChatInput("../c2")
+	lineEditor.SaveHistory("hello c2")
+	restore()
+
+	for name, want := range map[string]string{
+		"shell":              "connect\nwhoami\n",
+		"conversation-c1":    "hello c1\n",
+		"conversation-___c2": "hello c2\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(filepath.Dir(historyPath("shell")), name))
+		if err != nil || string(got) != want {
+			t.Errorf("history %s holds %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestTransmitCodeBlock(t *testing.T) {
+	withHistory(t)
+	receiveEchoes(t, 0)
+	useLineEditor(t)
+
+	left := make(chan struct{}, 1)
+	term := startCommand(t, func(c *ishell.Context) { transmit(c, left, &pkg.Account{FirstName: "Alice"}) })
+	go func() {
+		for _, line := range []string{"```go", "func main() {␤}", "```", "plain", "```go␤inline```"} {
+			io.WriteString(term.in, line+"\n")
+		}
+		// The end of the input leaves the chat.
+		term.in.Close()
+	}()
+	out := term.wait()
+	if !strings.Contains(out, "... ... ") {
+		t.Errorf("the chat did not ask for the rest of the block: %q", out)
+	}
+	want := []string{"```go\nfunc main() {\n}\n```", "plain", "```go\ninline```"}
+	if got := contents(history.last("c1", 10)); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("the chat sent %q, want %q", got, want)
+	}
+}
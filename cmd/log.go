@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+const redacted = "[REDACTED]"
+
+// logger writes to the log file only; anything meant for the user goes
+// through the shell instead so the chat pane stays readable.
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func defaultLogPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "chit-chat-go", "client.log")
+}
+
+func setupLogging(path string, debug bool) (io.Closer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
+	}
+	logger = slog.New(slog.NewTextHandler(file, &slog.HandlerOptions{Level: level}))
+	return file, nil
+}
+
+// redact returns a copy of req with any credentials blanked out so it can
+// be logged safely.
+func redact(req interface{}) interface{} {
+	switch r := req.(type) {
+	case *pkg.SignInRequest:
+		c := proto.Clone(r).(*pkg.SignInRequest)
+		c.Password = redacted
+		return c
+	case *pkg.SignUpRequest:
+		c := proto.Clone(r).(*pkg.SignUpRequest)
+		c.Password = redacted
+		return c
+	case *pkg.ChangePasswordRequest:
+		c := proto.Clone(r).(*pkg.ChangePasswordRequest)
+		c.CurrentPassword = redacted
+		c.NewPassword = redacted
+		return c
+	case *pkg.ConfirmPasswordResetRequest:
+		c := proto.Clone(r).(*pkg.ConfirmPasswordResetRequest)
+		c.Code = redacted
+		c.NewPassword = redacted
+		return c
+	}
+	return req
+}
+
+func chatEventType(msg interface{}) string {
+	event, ok := msg.(*pkg.ChatEvent)
+	if !ok {
+		return "unknown"
+	}
+	switch event.GetCommand().(type) {
+	case *pkg.ChatEvent_Login:
+		return "login"
+	case *pkg.ChatEvent_Message:
+		return "message"
+	case *pkg.ChatEvent_Logout:
+		return "logout"
+	case *pkg.ChatEvent_Edit:
+		return "edit"
+	case *pkg.ChatEvent_Delete:
+		return "delete"
+	case *pkg.ChatEvent_Ping:
+		return "ping"
+	}
+	return "empty"
+}
+
+func unaryLoggingInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	logger.Debug("unary call",
+		"method", method,
+		"request", redact(req),
+		"code", status.Code(err).String(),
+		"latency", time.Since(start),
+	)
+	return err
+}
+
+func streamLoggingInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	logger.Debug("stream opened",
+		"method", method,
+		"code", status.Code(err).String(),
+		"latency", time.Since(start),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &loggingClientStream{ClientStream: s, method: method}, nil
+}
+
+type loggingClientStream struct {
+	grpc.ClientStream
+	method string
+}
+
+func (s *loggingClientStream) SendMsg(m interface{}) error {
+	start := time.Now()
+	err := s.ClientStream.SendMsg(m)
+	logger.Debug("stream send",
+		"method", s.method,
+		"event", chatEventType(m),
+		"code", status.Code(err).String(),
+		"latency", time.Since(start),
+	)
+	return err
+}
+
+func (s *loggingClientStream) RecvMsg(m interface{}) error {
+	start := time.Now()
+	err := s.ClientStream.RecvMsg(m)
+	logger.Debug("stream recv",
+		"method", s.method,
+		"event", chatEventType(m),
+		"code", status.Code(err).String(),
+		"wait", time.Since(start),
+	)
+	return err
+}
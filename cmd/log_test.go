@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// debugLog logs at debug level to a file for the rest of the test and
+// returns its path.
+func debugLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "client.log")
+	savedLogger, savedDebug := logger, debug
+	file, err := setupLogging(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug = true
+	t.Cleanup(func() {
+		logger, debug = savedLogger, savedDebug
+		file.Close()
+	})
+	return path
+}
+
+func TestDebugLogRedactsSecrets(t *testing.T) {
+	path := debugLog(t)
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "secret-sign-in1")
+	connectTo(t, srv.addr)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The calls succeed, so the secrets reached the server unredacted.
+	if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "secret-sign-in1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authClient().SignUp(ctx, &pkg.SignUpRequest{Email: "bob@example.com", Password: "secret-sign-up1", FirstName: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authClient().ChangePassword(ctx, &pkg.ChangePasswordRequest{Email: testEmail, CurrentPassword: "secret-sign-in1", NewPassword: "secret-changed1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authClient().RequestPasswordReset(ctx, &pkg.PasswordResetRequest{Email: testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	code := srv.resetCode(t, testEmail)
+	if _, err := authClient().ConfirmPasswordReset(ctx, &pkg.ConfirmPasswordResetRequest{Email: testEmail, Code: code, NewPassword: "secret-reset1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.password(testEmail); got != "secret-reset1" {
+		t.Fatalf("the server has password %q after the reset", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, secret := range []string{"secret-sign-in1", "secret-sign-up1", "secret-changed1", "secret-reset1", code} {
+		// Requests are logged with their strings quoted, which keeps the
+		// digits of the code from matching a latency.
+		if strings.Contains(log, `\"`+secret+`\"`) {
+			t.Errorf("the debug log contains %q:\n%s", secret, log)
+		}
+	}
+	for _, method := range []string{"SignIn", "SignUp", "ChangePassword", "ConfirmPasswordReset"} {
+		var logged string
+		for _, line := range strings.Split(log, "\n") {
+			if strings.Contains(line, "msg=\"unary call\"") && strings.Contains(line, "/"+method+" ") {
+				logged = line
+			}
+		}
+		if !strings.Contains(logged, redacted) || !strings.Contains(logged, "@example.com") {
+			t.Errorf("the debug log has no redacted %s request: %q", method, logged)
+		}
+	}
+}
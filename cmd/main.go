@@ -1,105 +1,171 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/abiosoft/ishell/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+	"github.com/Madslick/chit-chat-go-client/pkg/slash"
 )
 
 var ctx context.Context
-var connection *grpc.ClientConn
-var chatClient pkg.ChatroomClient
-var authClient pkg.AuthClient
-var stream pkg.Chatroom_ConverseClient
 
-var me *pkg.Client
+// self is the client the logged in user chats as. The receiving goroutine
+// reads it while commands replace it, so it is swapped, never changed.
+var self atomic.Pointer[pkg.Client]
+
+// me returns the client the logged in user chats as.
+func me() *pkg.Client { return self.Load() }
+
+var account *pkg.Account
 var selectedAccount *pkg.Account
 var conversation pkg.Conversation
 
+var shell *ishell.Shell
+
+var debug bool
+var serverAddress string
+
 func connect(connectionString string) error {
 	//ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	//defer cancel()
 
-	var err error
-	connection, err = grpc.DialContext(ctx, connectionString, grpc.WithInsecure())
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithIdleTimeout(0)}
+	opts = append(opts,
+		grpc.WithChainUnaryInterceptor(rpcerr.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(rpcerr.StreamClientInterceptor),
+	)
+	opts = append(opts, telemetryDialOptions()...)
+	opts = append(opts, keepaliveDialOptions()...)
+	opts = append(opts, serviceConfigDialOptions()...)
+	opts = append(opts, compressionDialOptions()...)
+	opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(maxPartSize+messageOverhead)))
+	opts = append(opts, grpc.WithChainUnaryInterceptor(unaryDeadlineInterceptor))
+	if debug {
+		opts = append(opts,
+			grpc.WithChainUnaryInterceptor(unaryLoggingInterceptor),
+			grpc.WithChainStreamInterceptor(streamLoggingInterceptor),
+		)
+	}
+
+	target, transportOpts, err := transport(connectionString)
 	if err != nil {
-		fmt.Printf("Error occurred while connecting to server %s\n", err)
+		logger.Error("invalid server address", "target", connectionString, "err", err)
 		return err
 	}
+	opts = append(opts, transportOpts...)
 
-	chatClient = pkg.NewChatroomClient(connection)
-	authClient = pkg.NewAuthClient(connection)
+	conn, err := grpc.DialContext(ctx, target, opts...)
+	if err != nil {
+		logger.Error("failed to connect to server", "target", connectionString, "err", err)
+		return err
+	}
+	logger.Info("connected", "target", connectionString)
+	setConnection(conn)
+	connectionWatchers.Add(1)
+	go func() {
+		defer connectionWatchers.Done()
+		watchConnection(ctx, conn)
+	}()
+	conn.Connect()
 
 	return nil
 }
 
 func startStream() error {
-	var err error
-	stream, err = chatClient.Converse(context.Background())
+	streamCtx, cancel := context.WithCancel(ctx)
+	s, err := chatClient().Converse(streamCtx)
 	if err != nil {
-		fmt.Printf("Error occurred while starting the bi-directional stream with the server %s\n", err)
+		cancel()
+		logger.Error("failed to start stream", "err", err)
 		return err
 	}
+	// Cancel the stream being replaced, if any, so its context and the
+	// goroutines watching it do not outlive it.
+	swapStream(s, cancel)()
+	resetStreamPings()
+	logger.Info("chat stream opened", "backend", streamBackend(s.Context()))
 	return nil
 }
 
-func login() error {
+// login announces the user on the chat stream and waits for the server to
+// acknowledge it, giving up when ctx is done or the Login timeout passes.
+func login(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, timeouts.forMethod("Login"))
+	defer cancel()
+	s := currentStream()
+	stop := context.AfterFunc(ctx, cancelStream)
+	defer stop()
 
 	loginEvent := pkg.ChatEvent{
 		Command: &pkg.ChatEvent_Login{
-			Login: me,
+			Login: me(),
 		},
 	}
-	sendErr := stream.Send(&loginEvent)
+	sendErr := sendEvent(ctx, &loginEvent)
 	if sendErr != nil {
-		fmt.Printf("Failed to send message to server: %v\n", sendErr)
+		logger.Error("failed to send login event", "err", sendErr)
 		return sendErr
 	}
 
 	// Receive Login Response
-	_, err := stream.Recv()
+	response, err := s.Recv()
 	if err != nil {
-		fmt.Printf("Failed to login to server: %v\n", err)
+		if ctx.Err() != nil {
+			err = status.FromContextError(ctx.Err()).Err()
+		}
+		logger.Error("failed to receive login response", "err", err)
 		return err
 	}
+	traceReceived(ctx, response)
 
 	return nil
 }
 
-func authenticate(email string, password string) {
+func authenticate(ctx context.Context, email string, password string) error {
 
-	response, err := authClient.SignIn(
-		context.TODO(),
+	response, err := authClient().SignIn(
+		ctx,
 		&pkg.SignInRequest{
 			Email:    email,
 			Password: password,
 		},
 	)
 	if err != nil {
-		fmt.Printf("Problem signing in: %v\n", err)
-		return
+		logger.Error("sign in failed", "email", email, "err", err)
+		return err
 	}
-	me = &pkg.Client{
+	if err := setState(stateAuthenticated); err != nil {
+		return err
+	}
+	account = response
+	client := &pkg.Client{
 		ClientId: response.GetId(),
 		Name:     response.GetFirstName(),
 	}
-
-	fmt.Printf("Hello %s, your ClientId is %s\n", me.Name, me.ClientId)
+	self.Store(client)
+	logger.Info("signed in", "client_id", client.ClientId)
+	fmt.Printf("Hello %s, your ClientId is %s\n", client.Name, client.ClientId)
+	return nil
 }
 
-func signup(email string, password string, first string, last string, phone string) error {
+func signup(ctx context.Context, email string, password string, first string, last string, phone string) error {
 
-	response, err := authClient.SignUp(
-		context.TODO(),
+	response, err := authClient().SignUp(
+		ctx,
 		&pkg.SignUpRequest{
 			Email:       email,
 			Password:    password,
@@ -110,7 +176,7 @@ func signup(email string, password string, first string, last string, phone stri
 	)
 
 	if err != nil {
-		fmt.Printf("Unable to signup new user. Error: %v\n", err)
+		logger.Error("sign up failed", "email", email, "err", err)
 		return err
 	}
 
@@ -118,9 +184,9 @@ func signup(email string, password string, first string, last string, phone stri
 	return nil
 }
 
-func searchAccounts(query string) ([]*pkg.Account, error) {
-	searchResponse, err := authClient.SearchAccounts(
-		context.TODO(),
+func searchAccounts(ctx context.Context, query string) ([]*pkg.Account, error) {
+	searchResponse, err := authClient().SearchAccounts(
+		ctx,
 		&pkg.SearchAccountsRequest{
 			SearchQuery: query,
 			Page:        0,
@@ -128,81 +194,295 @@ func searchAccounts(query string) ([]*pkg.Account, error) {
 		},
 	)
 	if err != nil {
-		fmt.Printf("Error searching accounts with %s. Error: %v\n", query, err)
+		logger.Error("account search failed", "query", query, "err", err)
 		return nil, err
 	}
 	return searchResponse.GetMembers(), nil
 }
 
-func receive(c *ishell.Context, ch chan struct{}, acc *pkg.Account) {
+func receive(c *ishell.Context, acc *pkg.Account) {
+	days := daySeparator{last: time.Now()}
+	// Recovery starts another receive for the stream replacing this one.
+	s := currentStream()
+	// show prints a line above the prompt being edited.
+	show := func(line string) {
+		c.Printf("\n%s\n", line)
+		if chatActive.Load() {
+			c.Print(chatPrompt(acc.GetFirstName()))
+		} else {
+			c.Print(shellPrompt())
+		}
+	}
 	for {
-		in, err := stream.Recv()
+		in, err := s.Recv()
 		if err == io.EOF || err != nil {
-			c.Printf("Stream was closed\n>>>")
-			ch <- struct{}{}
+			logger.Info("stream closed", "err", err)
+			if sessionCtx.Err() != nil || recovering.Load() {
+				return
+			}
+			userCtx := sessionCtx
+			goSession(func() { recoverSession(userCtx, c, "Stream was closed") })
 			return
 		}
+		traceReceived(ctx, in)
 
-		if login := in.GetLogin(); login != nil {
+		if in.GetPing() != nil {
+			pingAnswered()
+		} else if login := in.GetLogin(); login != nil {
 			c.Println(login.GetName(), "logged in")
+		} else if logout := in.GetLogout(); logout != nil {
+			c.Println(logout.GetName(), "logged out")
 		} else if message := in.GetMessage(); message != nil {
-			c.Printf("\nFrom %s: %s\n", message.GetFrom().GetName(), message.GetContent())
-			if acc != nil {
-				c.Printf("To %s: ", acc.FirstName)
-			} else {
-				c.Print(">>> ")
+			conversationId := message.GetConversation().GetId()
+			content, complete := partial.add(message.GetFrom().GetClientId(), message.GetContent())
+			if !complete {
+				continue
+			}
+			received := &pkg.ConversationMessage{
+				From:       message.GetFrom(),
+				Content:    content,
+				Id:         message.GetId(),
+				SentAt:     message.GetSentAt(),
+				ReceivedAt: message.GetReceivedAt(),
+			}
+			if received.ReceivedAt == nil {
+				// Servers that do not stamp messages get them stamped on arrival.
+				received.ReceivedAt = timestamppb.Now()
+			}
+			if content != message.GetContent() {
+				history.markSplit(conversationId, received.GetId())
+			}
+			fromMe := message.GetFrom().GetClientId() == me().GetClientId()
+			if fromMe && history.echoed(conversationId, received) {
+				continue
+			}
+			if !history.add(conversationId, received) {
+				logger.Info("dropped repeated message", "conversation", conversationId, "id", received.GetId())
+				continue
+			}
+			noteTopic(conversationId, content)
+			mentioned := !fromMe && mentionsMe(content)
+			if mentioned {
+				mentions.add(conversationId, received)
+			}
+			if isMuted(conversationId) {
+				continue
+			}
+			line := formatStamped(received)
+			if mentioned {
+				line = highlight(line)
+			}
+			if separator, ok := days.next(messageTime(received)); ok {
+				line = separator + "\n" + line
+			}
+			if !fromMe {
+				notifications.message(message.GetFrom().GetName(), conversationId, content, mentioned)
+			}
+			show(line)
+		} else if edit := in.GetEdit(); edit != nil {
+			conversationId := edit.GetConversation().GetId()
+			updated, ok := applyEdit(conversationId, edit)
+			if !ok {
+				logger.Info("edit of unknown message or already applied", "conversation", conversationId, "id", edit.GetId())
+				continue
+			}
+			if edit.GetFrom().GetClientId() != me().GetClientId() && !isMuted(conversationId) {
+				show(formatStamped(updated))
+			}
+		} else if del := in.GetDelete(); del != nil {
+			conversationId := del.GetConversation().GetId()
+			updated, ok := applyDelete(conversationId, del)
+			if !ok {
+				logger.Info("delete of unknown message or already applied", "conversation", conversationId, "id", del.GetId())
+				continue
+			}
+			if del.GetFrom().GetClientId() != me().GetClientId() && !isMuted(conversationId) {
+				show(formatStamped(updated))
 			}
 		}
 	}
 }
 
 func transmit(c *ishell.Context, ch chan struct{}, acc *pkg.Account) {
-	messageScanner := bufio.NewScanner(os.Stdin)
-	c.Printf("To %s: ", acc.FirstName)
-	for messageScanner.Scan() {
-		msg := strings.TrimSpace(messageScanner.Text())
+	chatActive.Store(true)
+	restoreInput := enterChatInput(conversation.GetId())
+	leave := func() {
+		restoreInput()
+		chatActive.Store(false)
+		c.Printf("Exited Chat.\n")
+		ch <- struct{}{}
+	}
+
+	for {
+		c.Print(chatPrompt(acc.GetFirstName()))
+		line, err := c.ReadLineErr()
+		if err != nil {
+			leave()
+			return
+		}
+		msg := strings.TrimSpace(composedText(line))
 		if msg == "" {
 			continue
 		}
 
-		if msg == "/break" {
-			ch <- struct{}{}
-			c.Printf("Exited Chat.\n")
-			return
+		if opensCodeBlock(msg) {
+			block, err := readCodeBlock(c)
+			if err != nil {
+				leave()
+				return
+			}
+			msg += "\n" + block
+		} else if slash.IsCommand(msg) {
+			err := slashCommands.Execute(msg)
+			if errors.Is(err, errLeaveChat) {
+				leave()
+				return
+			}
+			if err != nil {
+				printSlashError(c, msg, err)
+			}
+			continue
 		}
 
-		message := pkg.Message{}
-		message.Conversation = &conversation
-		message.From = me
-		message.Content = msg
+		msg = slash.Unescape(msg)
+		warnUnknownMentions(c, msg)
+		if err := sendMessage(msg); errors.Is(err, errMessageTooLarge) {
+			c.Println("[ERROR] Message was not sent:", err)
+		} else if err != nil {
+			logger.Error("failed to send message", "conversation", conversation.GetId(), "err", err)
+			printRPCError(c, err, "Message was not delivered")
+		}
+	}
+}
 
-		err := stream.Send(&pkg.ChatEvent{
-			Command: &pkg.ChatEvent_Message{Message: &message},
-		})
+// readCodeBlock reads lines until the ``` that closes a block opened on the
+// previous line.
+func readCodeBlock(c *ishell.Context) (string, error) {
+	var lines []string
+	for {
+		c.Print("... ")
+		line, err := c.ReadLineErr()
 		if err != nil {
-			fmt.Printf("Failed to send message to server: %v\n", err)
+			return "", err
+		}
+		line = composedText(line)
+		lines = append(lines, line)
+		if strings.Contains(line, codeFence) {
+			return strings.Join(lines, "\n"), nil
 		}
-
-		c.Printf("To %s: ", acc.FirstName)
-
 	}
-
 }
 
 func main() {
 	// Main Function for chit-chat-go
-	ctx = context.TODO()
+	var shutdown context.CancelFunc
+	ctx, shutdown = context.WithCancel(context.Background())
+	defer shutdown()
 
 	// 1. Pull Command Line arguments
-	var serverConnection string
-	flag.StringVar(&serverConnection, "s", "chit-chat-go:3000", "The host:port to connect to the server")
+	var logPath string
+	var telemetryPath string
+	var keymap string
+	flag.StringVar(&serverAddress, "s", "chit-chat-go:3000", "The host:port of the server, a comma separated list of them, dns:///host:port for every address a name resolves to, unix:///path, or a ws:// or wss:// URL to tunnel over WebSocket")
+	flag.StringVar(&proxyAddress, "proxy", "", "Proxy to reach the server through, http://, https:// or socks5:// with optional user:password@, or direct to ignore HTTPS_PROXY and ALL_PROXY")
+	flag.StringVar(&compression, "compress", compression, "Compress requests with gzip: auto to fall back when the server cannot decompress, gzip or none")
+	flag.IntVar(&maxMessageSize, "max-message-size", maxMessageSize, "Largest message in bytes that can be sent")
+	flag.IntVar(&maxPartSize, "max-part-size", maxPartSize, "Messages longer than this many bytes are sent in several parts")
+	flag.StringVar(&balancer, "lb", balancer, "How calls are spread over several servers, pick_first or round_robin")
+	flag.StringVar(&profileName, "profile", profileName, "The vault profile whose saved login is used")
+	flag.StringVar(&vaultPath, "vault", vaultPath, "The encrypted file saved logins are kept in")
+	flag.DurationVar(&vaultIdle, "vault-idle", vaultIdle, "Lock the vault again after this long without a command, 0 to keep it unlocked")
+	flag.StringVar(&passwordCommand, "password-command", "", "Shell command printing the password for the email in $CHIT_CHAT_EMAIL, e.g. pass show chit-chat/$CHIT_CHAT_EMAIL")
+	flag.StringVar(&credentialHelper, "credential-helper", "", "Command speaking the git credential helper protocol to get, store and erase logins")
+	flag.BoolVar(&notifyLogout, "logout-event", false, "Tell the server when logging out, for servers that understand the logout event")
+	flag.BoolVar(&rememberLogin, "remember", false, "Offer to save the login in a new vault")
+	flag.StringVar(&notifyMethods, "notify", notifyMethods, "Notify of incoming messages with any of bell, osc9, osc777 and exec, comma separated, or none")
+	flag.StringVar(&notifyCommand, "notify-command", "", "Shell command run by -notify exec with $CHIT_CHAT_SENDER, $CHIT_CHAT_MESSAGE and $CHIT_CHAT_CONVERSATION set, e.g. notify-send \"$CHIT_CHAT_SENDER\" \"$CHIT_CHAT_MESSAGE\"")
+	flag.BoolVar(&notifyMentionsOnly, "notify-mentions", false, "Only notify of messages that mention you with @name")
+	flag.DurationVar(&notifyInterval, "notify-interval", notifyInterval, "Least time between notifications; messages in between are summed up in one")
+	flag.StringVar(&timeFormat, "time-format", timeFormat, "Go time layout messages are stamped with in local time, e.g. 3:04PM or 2006-01-02 15:04, empty for none")
+	flag.StringVar(&logPath, "log", defaultLogPath(), "The file to write client logs to")
+	flag.BoolVar(&debug, "debug", false, "Log every RPC and stream event to the log file")
+	flag.BoolVar(&telemetry, "telemetry", false, "Record OpenTelemetry traces and metrics for client RPCs")
+	flag.StringVar(&telemetryPath, "telemetry-out", defaultTelemetryPath(), "The file to export telemetry to when OTLP is not configured, - for stdout")
+	flag.StringVar(&keymap, "keymap", "emacs", "Line editing key bindings, emacs or vi")
+	flag.DurationVar(&keepaliveTime, "keepalive", 5*time.Minute, "Idle time before the transport pings the server, 0 to disable")
+	flag.DurationVar(&keepaliveTimeout, "keepalive-timeout", 20*time.Second, "How long to wait for a keepalive ping to be acknowledged")
+	flag.DurationVar(&heartbeatInterval, "heartbeat", 30*time.Second, "Interval between application heartbeats while logged in, 0 to disable")
+	flag.DurationVar(&heartbeatTimeout, "heartbeat-timeout", 10*time.Second, "How long to wait for a heartbeat reply")
+	flag.DurationVar(&timeouts.fallback, "timeout", timeouts.fallback, "Deadline for each RPC, 0 to wait forever")
+	flag.Var(&timeouts, "timeouts", "Per-RPC deadlines overriding -timeout, e.g. SignIn=5s,SearchAccounts=2s")
+	flag.IntVar(&retries.maxAttempts, "retry-attempts", retries.maxAttempts, "Attempts for RPCs that are safe to retry, 1 to disable retries")
+	flag.DurationVar(&retries.initialBackoff, "retry-backoff", retries.initialBackoff, "Backoff before the first retry, doubled for each one after")
+	flag.DurationVar(&retries.maxBackoff, "retry-max-backoff", retries.maxBackoff, "Upper bound on the backoff between retries")
+	flag.Var(retryCodes{&retries}, "retry-codes", "Comma separated status codes to retry on")
+	flag.Var(retryAttempts{&retries}, "retries", "Per-RPC attempts overriding -retry-attempts, e.g. SearchAccounts=5,SignIn=1")
 	flag.Parse()
+	if err := validateBalancer(balancer); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if err := validateCompression(compression); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if maxPartSize < minPartSize {
+		fmt.Printf("-max-part-size must be at least %d bytes\n", minPartSize)
+		os.Exit(2)
+	}
+	notifiers, err := parseNotifiers(notifyMethods, notifyCommand, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	mode := notifyOn
+	if notifyMentionsOnly {
+		mode = notifyMentions
+	}
+	notifications.configure(notifiers, notifyInterval, mode)
+
+	logFile, err := setupLogging(logPath, debug)
+	if err != nil {
+		fmt.Printf("Unable to open log file %s: %v\n", logPath, err)
+	} else {
+		defer logFile.Close()
+	}
+
+	if telemetry {
+		shutdown, err := setupTelemetry(ctx, telemetryPath)
+		if err != nil {
+			fmt.Printf("Unable to set up telemetry: %v\n", err)
+		} else {
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := shutdown(ctx); err != nil {
+					logger.Error("failed to flush telemetry", "err", err)
+				}
+			}()
+		}
+	}
+	initInstruments()
 
-	shell := ishell.New()
+	lineEditor, err = newLineEditor(keymap)
+	if err != nil {
+		fmt.Printf("Unable to start line editor: %v\n", err)
+		os.Exit(1)
+	}
+	shell = ishell.NewWithReadline(lineEditor)
+	shell.SetPrompt(shellPrompt())
 	shell.Println("Welcome to Chit-Chat-Go. Type help for the available commands")
 	shell.SetMultiChoicePrompt(" >>", " - ")
-
-	connect(serverConnection)
+	shell.CustomCompleter(completer{shell})
+	shell.Interrupt(interruptPrompt(shutdown))
+	go handleSignals(shutdown)
+	registerChatCommands(shell)
+
+	if err := connect(serverAddress); err != nil {
+		shell.Println("[ERROR] Unable to connect to", serverAddress, "- run doctor for details")
+	} else if err := setState(stateConnected); err != nil {
+		shell.Println("[ERROR]", err)
+	}
 	breakChan := make(chan struct{})
 	selectedAccount = &pkg.Account{}
 
@@ -213,21 +493,7 @@ func main() {
 			defer c.ShowPrompt(true)
 			c.ShowPrompt(false)
 
-			// prompt for input
-			c.Print("Email: ")
-			email := c.ReadLine()
-			c.Print("Password: ")
-			password := c.ReadPassword()
-			c.Print("First Name: ")
-			first := c.ReadLine()
-			c.Print("Last Name: ")
-			last := c.ReadLine()
-			c.Print("Phone Number: ")
-			phone := c.ReadLine()
-
-			if err := signup(email, password, first, last, phone); err != nil {
-				c.Println("[ERROR] Unable to signup user with email ", email)
-			}
+			runSignup(c)
 
 		},
 	})
@@ -235,23 +501,11 @@ func main() {
 	shell.AddCmd(&ishell.Cmd{
 		Name: "login",
 		Func: func(c *ishell.Context) {
-			defer setSelectedAccount(pkg.Account{})
+			defer setSelectedAccount(&pkg.Account{})
 			defer c.ShowPrompt(true)
 			c.ShowPrompt(false)
 
-			// prompt for input
-			c.Print("Email: ")
-			email := c.ReadLine()
-			c.Print("Password: ")
-			password := c.ReadPassword()
-
-			authenticate(email, password)
-
-			startStream()
-
-			login()
-
-			go receive(c, breakChan, selectedAccount)
+			runLogin(c)
 		},
 		Help: "Login to chit-chat-go",
 	})
@@ -263,15 +517,18 @@ func main() {
 			c.ShowPrompt(false)
 			defer c.ShowPrompt(true)
 
-			if me.GetClientId() == "" {
-				c.Println("You must login first")
+			query, ok := prompt(c, "Enter a name to search: ")
+			if !ok {
 				return
 			}
-			c.Print("Enter a name to search: ")
-			query := c.ReadLine()
-			accounts, err := searchAccounts(query)
+			accounts, err := searchAccounts(commandContext(), query)
 			if err != nil {
-				c.Err(err)
+				printRPCError(c, err, "Unable to search accounts")
+				return
+			}
+			if len(accounts) == 0 {
+				c.Println("No accounts found")
+				return
 			}
 
 			account_names := []string{}
@@ -280,56 +537,190 @@ func main() {
 			}
 
 			choice := c.MultiChoice(account_names, "One of these people ?")
-			setSelectedAccount(*accounts[choice])
+			if choice < 0 {
+				return
+			}
+			setSelectedAccount(accounts[choice])
 
-			conversationResponse, err := chatClient.CreateConversation(
-				ctx,
+			conversationResponse, err := chatClient().CreateConversation(
+				commandContext(),
 				&pkg.ConversationRequest{
 					Members: []*pkg.Client{
-						me,
+						me(),
 						&pkg.Client{
 							ClientId: selectedAccount.Id,
 							Name:     selectedAccount.FirstName,
 						},
 					},
 				})
-			for _, msg := range conversationResponse.Messages {
-				fmt.Printf("From %s: %s\n", msg.GetFrom().GetName(), msg.GetContent())
-			}
-
 			if err != nil {
-				fmt.Printf("Unable to create conversation, error returned from server: %v\n", err)
+				logger.Error("failed to create conversation", "with", selectedAccount.Id, "err", err)
+				printRPCError(c, err, "Unable to start conversation")
 				return
 			}
+			messages, split := joinStoredParts(orderMessages(conversationResponse.GetMessages()))
+			history.replace(conversationResponse.GetId(), messages)
+			history.markSplit(conversationResponse.GetId(), split...)
+			var days daySeparator
+			for _, msg := range messages {
+				noteTopic(conversationResponse.GetId(), msg.GetContent())
+				if separator, ok := days.next(messageTime(msg)); ok {
+					fmt.Println(separator)
+				}
+				fmt.Println(formatStamped(msg))
+			}
 
 			conversation = pkg.Conversation{
 				Id:      conversationResponse.GetId(),
 				Members: conversationResponse.GetMembers(),
 			}
 
+			if err := setState(stateInConversation); err != nil {
+				c.Println("[ERROR] Unable to start conversation:", err)
+				return
+			}
 			go transmit(c, breakChan, selectedAccount)
 
 			<-breakChan
-
+			if err := setState(stateStreaming); err != nil {
+				c.Println("[ERROR] The chat session ended while in the conversation:", err)
+			}
 		},
 	})
 
 	shell.AddCmd(&ishell.Cmd{
 		Name: "logout",
 		Help: "Logs current user out",
+		Func: runLogout,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "passwd",
+		Help: "Change your password",
 		Func: func(c *ishell.Context) {
-			me = &pkg.Client{}
-			if err := stream.CloseSend(); err != nil {
-				c.Println("Error while closing stream, %v", err)
-			}
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
+
+			runPasswd(c)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "reset-password",
+		Help: "Reset a forgotten password with a code sent to your email",
+		Func: func(c *ishell.Context) {
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
+
+			runResetPassword(c)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "whoami",
+		Help: "Show the account you are logged in with",
+		Func: func(c *ishell.Context) {
+			runWhoami(c)
+		},
+	})
+
+	profile := &ishell.Cmd{
+		Name: "profile",
+		Help: "Show your profile, or change it with profile edit",
+		Func: func(c *ishell.Context) {
+			runWhoami(c)
+		},
+	}
+	profile.AddCmd(&ishell.Cmd{
+		Name: "edit",
+		Help: "Change your name, email or phone number",
+		Func: func(c *ishell.Context) {
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
 
+			runProfileEdit(c)
+		},
+	})
+	shell.AddCmd(profile)
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "notify",
+		Help: "Show whether incoming messages raise notifications, or switch them with notify on|off|mentions",
+		Func: runNotify,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "mentions",
+		Help: "List the messages that mentioned you this session, or forget them with mentions clear",
+		Func: runMentions,
+	})
+
+	vaultCmd := &ishell.Cmd{
+		Name: "vault",
+		Help: "List the logins saved in the vault, or manage it with its subcommands",
+		Func: func(c *ishell.Context) {
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
+
+			runVaultList(c)
+		},
+	}
+	vaultCmd.AddCmd(&ishell.Cmd{
+		Name: "list",
+		Help: "List the saved logins",
+		Func: func(c *ishell.Context) {
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
+
+			runVaultList(c)
+		},
+	})
+	vaultCmd.AddCmd(&ishell.Cmd{
+		Name: "remove",
+		Help: "Forget the login saved for a profile",
+		Func: func(c *ishell.Context) {
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
+
+			runVaultRemove(c)
+		},
+	})
+	vaultCmd.AddCmd(&ishell.Cmd{
+		Name: "passwd",
+		Help: "Change the vault passphrase",
+		Func: func(c *ishell.Context) {
+			defer c.ShowPrompt(true)
+			c.ShowPrompt(false)
+
+			runVaultPasswd(c)
+		},
+	})
+	vaultCmd.AddCmd(&ishell.Cmd{
+		Name: "lock",
+		Help: "Lock the vault until its passphrase is entered again",
+		Func: func(c *ishell.Context) {
+			vault.lock()
+			c.Println("Vault locked")
+		},
+	})
+	shell.AddCmd(vaultCmd)
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "doctor",
+		Help: "Diagnose connectivity to the chat server",
+		Func: func(c *ishell.Context) {
+			c.Printf("Running diagnostics, this can take up to %s per check...\n", doctorTimeout)
+			printDoctorReport(c, serverAddress, runDoctor(commandContext(), serverAddress))
 		},
 	})
 
+	trackCommands(shell.Cmds())
+	gateCommands(shell)
 	shell.Run()
+	closeSession()
 }
 
-func setSelectedAccount(acc pkg.Account) {
+func setSelectedAccount(acc *pkg.Account) {
 	selectedAccount.Id = acc.GetId()
 	selectedAccount.Email = acc.GetEmail()
 	selectedAccount.FirstName = acc.GetFirstName()
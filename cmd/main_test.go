@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// The instruments are no-ops until a test installs its own providers.
+	initInstruments()
+	os.Exit(m.Run())
+}
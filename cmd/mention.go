@@ -0,0 +1,176 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/abiosoft/ishell/v2"
+	"github.com/abiosoft/readline"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// maxMentions is how many mentions the inbox keeps; older ones are dropped.
+const maxMentions = 100
+
+// mentionPattern matches @name where it is not part of a word such as an
+// email address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.-]+)`)
+
+var mentions = &mentionInbox{}
+
+// mentionName is how client is written after @: its name without spaces.
+func mentionName(client *pkg.Client) string {
+	return strings.ReplaceAll(client.GetName(), " ", "")
+}
+
+// mentionedNames returns the names written after @ in content.
+func mentionedNames(content string) []string {
+	var names []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// A full stop or dash ending a sentence is not part of the name.
+		if name := strings.TrimRight(m[1], ".-"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mentionMatches reports whether @name refers to client, by name ignoring
+// case or by client id.
+func mentionMatches(name string, client *pkg.Client) bool {
+	return client.GetClientId() != "" && name == client.GetClientId() ||
+		mentionName(client) != "" && strings.EqualFold(name, mentionName(client))
+}
+
+// resolveMentions splits the names mentioned in content into the members
+// they refer to and the ones matching nobody.
+func resolveMentions(content string, members []*pkg.Client) (resolved []*pkg.Client, unknown []string) {
+	for _, name := range mentionedNames(content) {
+		found := false
+		for _, member := range members {
+			if mentionMatches(name, member) {
+				resolved = append(resolved, member)
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	return resolved, unknown
+}
+
+// warnUnknownMentions points out the names mentioned in content that match
+// nobody in the open conversation. The message is sent all the same.
+func warnUnknownMentions(c *ishell.Context, content string) {
+	if _, unknown := resolveMentions(content, conversation.GetMembers()); len(unknown) > 0 {
+		c.Printf("Nobody in this conversation is called @%s\n", strings.Join(unknown, ", @"))
+	}
+}
+
+// mentionsMe reports whether content mentions the logged in user.
+func mentionsMe(content string) bool {
+	for _, name := range mentionedNames(content) {
+		if mentionMatches(name, me()) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight marks a line that mentions the user: bold and reversed on a
+// terminal, with a prefix otherwise.
+func highlight(line string) string {
+	if readline.DefaultIsTerminal() {
+		return "\x1b[1;7m" + line + "\x1b[0m"
+	}
+	return "[mention] " + line
+}
+
+// completeMention offers the members of the open conversation, other than
+// the user, whose @name starts with prefix.
+func completeMention(prefix string) []string {
+	var candidates []string
+	for _, member := range conversation.GetMembers() {
+		if member.GetClientId() == me().GetClientId() || mentionName(member) == "" {
+			continue
+		}
+		if candidate := "@" + mentionName(member); strings.HasPrefix(candidate, prefix) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// mention is a message that mentioned the user.
+type mention struct {
+	conversationId string
+	msg            *pkg.ConversationMessage
+}
+
+// mentionInbox keeps the mentions received this session, counting the
+// ones not yet listed with the mentions command.
+type mentionInbox struct {
+	mu       sync.Mutex
+	mentions []mention
+	unread   int
+}
+
+func (m *mentionInbox) add(conversationId string, msg *pkg.ConversationMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mentions = append(m.mentions, mention{conversationId, msg})
+	if len(m.mentions) > maxMentions {
+		m.mentions = m.mentions[len(m.mentions)-maxMentions:]
+	}
+	m.unread = min(m.unread+1, maxMentions)
+}
+
+// read returns every mention kept and how many of the last ones had not
+// been read, marking them all read.
+func (m *mentionInbox) read() ([]mention, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	unread := m.unread
+	m.unread = 0
+	return append([]mention(nil), m.mentions...), unread
+}
+
+func (m *mentionInbox) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mentions, m.unread = nil, 0
+}
+
+func runMentions(c *ishell.Context) {
+	if len(c.Args) == 1 && c.Args[0] == "clear" {
+		mentions.reset()
+		c.Println("Mentions cleared")
+		return
+	}
+	if len(c.Args) > 0 {
+		c.Println("Usage: mentions [clear]")
+		return
+	}
+	printMentions(c.Printf)
+}
+
+// printMentions lists the inbox, newest last, marking the unread mentions
+// with a star.
+func printMentions(printf func(format string, a ...interface{})) {
+	all, unread := mentions.read()
+	if len(all) == 0 {
+		printf("No mentions\n")
+		return
+	}
+	for i, m := range all {
+		marker := " "
+		if i >= len(all)-unread {
+			marker = "*"
+		}
+		printf("%s [%s] %s\n", marker, m.conversationId, formatStamped(m.msg))
+	}
+}
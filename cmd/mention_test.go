@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/abiosoft/ishell/v2"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+var (
+	maryJane    = &pkg.Client{ClientId: "mj", Name: "Mary Jane"}
+	bobbyTables = &pkg.Client{ClientId: "bt", Name: "Bobby Tables"}
+	barbara     = &pkg.Client{ClientId: "barbara", Name: "Barbara"}
+)
+
+// inConversation makes alice the logged in user in a conversation with
+// members for the rest of the test.
+func inConversation(t *testing.T, members ...*pkg.Client) {
+	t.Helper()
+	saved := me()
+	self.Store(alice)
+	conversation = pkg.Conversation{Id: "c1", Members: append([]*pkg.Client{alice}, members...)}
+	t.Cleanup(func() {
+		self.Store(saved)
+		conversation = pkg.Conversation{}
+	})
+}
+
+func TestMentionedNames(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hi @bob", []string{"bob"}},
+		{"@bob, are you there?", []string{"bob"}},
+		{"thanks @bob.", []string{"bob"}},
+		{"ask @bob-", []string{"bob"}},
+		{"(@bob) and @bob!", []string{"bob", "bob"}},
+		{"@bob's turn", []string{"bob"}},
+		{"@mary.jane hi", []string{"mary.jane"}},
+		{"@bob @alice", []string{"bob", "alice"}},
+		{"hola @José", []string{"José"}},
+		{"mail bob@example.com", nil},
+		{"email@bob", nil},
+		{"x_@bob", nil},
+		{"@@bob", nil},
+		{"just an @ sign", nil},
+		{"@.", nil},
+	}
+	for _, tt := range tests {
+		if got := mentionedNames(tt.content); !slices.Equal(got, tt.want) {
+			t.Errorf("mentionedNames(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestResolveMentions(t *testing.T) {
+	members := []*pkg.Client{alice, bob, maryJane}
+	tests := []struct {
+		content  string
+		resolved []*pkg.Client
+		unknown  []string
+	}{
+		{"hi @bob", []*pkg.Client{bob}, nil},
+		{"hi @BOB and @Alice", []*pkg.Client{bob, alice}, nil},
+		{"@maryjane and @MaryJane", []*pkg.Client{maryJane, maryJane}, nil},
+		{"@mj, by id", []*pkg.Client{maryJane}, nil},
+		{"@MJ is not an id", nil, []string{"MJ"}},
+		{"@zed and @bob, meet @eve.", []*pkg.Client{bob}, []string{"zed", "eve"}},
+		{"write to bob@example.com", nil, nil},
+	}
+	for _, tt := range tests {
+		resolved, unknown := resolveMentions(tt.content, members)
+		if !slices.Equal(resolved, tt.resolved) || !slices.Equal(unknown, tt.unknown) {
+			t.Errorf("resolveMentions(%q) = %v, %q; want %v, %q", tt.content, resolved, unknown, tt.resolved, tt.unknown)
+		}
+	}
+}
+
+func TestWarnUnknownMentions(t *testing.T) {
+	inConversation(t, bob)
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"hi @bob", ""},
+		{"hi @zed", "Nobody in this conversation is called @zed\n"},
+		{"@zed, @eve: hi @bob", "Nobody in this conversation is called @zed, @eve\n"},
+		{"mail bob@example.com", ""},
+	}
+	for _, tt := range tests {
+		out := runCommand(t, func(c *ishell.Context) { warnUnknownMentions(c, tt.content) })
+		if tt.want == "" && strings.Contains(out, "Nobody") || !strings.HasSuffix(out, tt.want) {
+			t.Errorf("warnUnknownMentions(%q) printed %q, want %q", tt.content, out, tt.want)
+		}
+	}
+}
+
+func TestMentionsMe(t *testing.T) {
+	inConversation(t, bob)
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"hey @alice!", true},
+		{"hey @ALICE", true},
+		{"@alice, look", true},
+		{"mail alice@example.com", false},
+		{"@alicex", false},
+		{"@bob only", false},
+		{"alice", false},
+	}
+	for _, tt := range tests {
+		if got := mentionsMe(tt.content); got != tt.want {
+			t.Errorf("mentionsMe(%q) = %t, want %t", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	// Tests do not run on a terminal, so the mention is marked in text.
+	if got := highlight("From Bob: hi @alice"); got != "[mention] From Bob: hi @alice" {
+		t.Errorf("highlight = %q", got)
+	}
+}
+
+func TestCompleteMention(t *testing.T) {
+	inConversation(t, bob, bobbyTables, barbara, maryJane, &pkg.Client{ClientId: "nameless"})
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"@", []string{"@Bob", "@BobbyTables", "@Barbara", "@MaryJane"}},
+		{"@B", []string{"@Bob", "@BobbyTables", "@Barbara"}},
+		{"@Bob", []string{"@Bob", "@BobbyTables"}},
+		{"@Bobb", []string{"@BobbyTables"}},
+		{"@Mary", []string{"@MaryJane"}},
+		{"@Al", nil},
+		{"@Zed", nil},
+	}
+	for _, tt := range tests {
+		if got := completeMention(tt.prefix); !slices.Equal(got, tt.want) {
+			t.Errorf("completeMention(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+
+	chatActive.Store(true)
+	t.Cleanup(func() { chatActive.Store(false) })
+	cc := completer{shell: newTestShell(t, io.NopCloser(strings.NewReader("")), io.Discard)}
+	line := []rune("ask @Bob")
+	newLine, length := cc.Do(line, len(line))
+	var got []string
+	for _, candidate := range newLine {
+		got = append(got, string(candidate))
+	}
+	if !slices.Equal(got, []string{"", "byTables"}) || length != 4 {
+		t.Errorf("completing %q = %q, %d; want the rest of @Bob and @BobbyTables", string(line), got, length)
+	}
+}
+
+func TestMentionInbox(t *testing.T) {
+	saved := mentions
+	mentions = &mentionInbox{}
+	t.Cleanup(func() { mentions = saved })
+
+	if out := runCommand(t, runMentions); !strings.Contains(out, "No mentions") {
+		t.Fatalf("mentions printed %q for an empty inbox", out)
+	}
+	for i := range maxMentions + 5 {
+		mentions.add("c1", &pkg.ConversationMessage{From: bob, Id: fmt.Sprint("m", i), Content: fmt.Sprint("@alice ", i)})
+	}
+	all, unread := mentions.read()
+	if len(all) != maxMentions || unread != maxMentions || all[0].msg.GetId() != "m5" {
+		t.Fatalf("the inbox holds %d mentions, %d unread, the oldest %s; want the last %d", len(all), unread, all[0].msg.GetId(), maxMentions)
+	}
+
+	mentions.add("c2", &pkg.ConversationMessage{From: bob, Id: "new1", Content: "@alice one"})
+	mentions.add("c2", &pkg.ConversationMessage{From: bob, Id: "new2", Content: "@alice two"})
+	out := runCommand(t, runMentions)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	starred := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, "* ") {
+			starred++
+		}
+	}
+	if starred != 2 || !strings.HasPrefix(lines[len(lines)-1], "* [c2]") || !strings.HasSuffix(lines[len(lines)-1], "@alice two") {
+		t.Errorf("mentions marked %d unread, want the 2 new ones last: %q", starred, out)
+	}
+	if out := runCommand(t, runMentions); strings.Contains(out, "* [") {
+		t.Errorf("mentions listed were still unread: %q", out)
+	}
+
+	if out := runCommand(t, withArgs(runMentions, "all")); !strings.Contains(out, "Usage: mentions [clear]") {
+		t.Errorf("mentions all printed %q", out)
+	}
+	if out := runCommand(t, withArgs(runMentions, "clear")); !strings.Contains(out, "Mentions cleared") {
+		t.Errorf("mentions clear printed %q", out)
+	}
+	if out := runCommand(t, runMentions); !strings.Contains(out, "No mentions") {
+		t.Errorf("mentions printed %q after clearing", out)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/abiosoft/ishell/v2"
+)
+
+// notifyCommandTimeout bounds how long the -notify-command hook may run.
+const notifyCommandTimeout = 10 * time.Second
+
+// maxNotificationText is how much of a message is shown in a notification.
+const maxNotificationText = 200
+
+// notifyMethods is the -notify list: any of bell, osc9, osc777 and exec, or
+// none.
+var notifyMethods = "none"
+var notifyCommand string
+var notifyInterval = 10 * time.Second
+var notifyMentionsOnly bool
+
+// notifyMode says which incoming messages raise a notification.
+type notifyMode int32
+
+const (
+	notifyOff notifyMode = iota
+	notifyOn
+	notifyMentions
+)
+
+var notifyModeNames = [...]string{"off", "on", "mentions"}
+
+func (m notifyMode) String() string {
+	if m < 0 || int(m) >= len(notifyModeNames) {
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+	return notifyModeNames[m]
+}
+
+func parseNotifyMode(s string) (notifyMode, bool) {
+	for i, name := range notifyModeNames {
+		if s == name {
+			return notifyMode(i), true
+		}
+	}
+	return notifyOff, false
+}
+
+// globalNotifyMode is the global rule, switched with the notify command.
+var globalNotifyMode atomic.Int32
+
+// notifyRules holds the per-conversation notifyMode, overriding the global
+// one.
+var notifyRules sync.Map
+
+var notifications = &notificationCenter{}
+
+// notification is an incoming message worth telling the user about.
+type notification struct {
+	sender         string
+	conversationId string
+	content        string
+	mention        bool
+	// suppressed counts the earlier messages rate limiting folded into this
+	// notification.
+	suppressed int
+}
+
+func (n notification) title() string {
+	if n.mention {
+		return "Chit-Chat-Go: " + n.sender + " mentioned you"
+	}
+	return "Chit-Chat-Go: " + n.sender
+}
+
+func (n notification) body() string {
+	if n.suppressed > 0 {
+		return fmt.Sprintf("%s (and %d more)", n.content, n.suppressed)
+	}
+	return n.content
+}
+
+// notifier delivers notifications one way, e.g. by ringing the bell.
+type notifier interface {
+	notify(n notification) error
+}
+
+type bellNotifier struct{ w io.Writer }
+
+func (b bellNotifier) notify(notification) error {
+	_, err := io.WriteString(b.w, "\a")
+	return err
+}
+
+// osc9Notifier uses the OSC 9 escape understood by iTerm2, Windows Terminal
+// and others.
+type osc9Notifier struct{ w io.Writer }
+
+func (o osc9Notifier) notify(n notification) error {
+	_, err := fmt.Fprintf(o.w, "\x1b]9;%s\x07", escapeText(n.title()+": "+n.body()))
+	return err
+}
+
+// osc777Notifier uses the OSC 777 escape understood by rxvt, foot, kitty
+// and VTE based terminals.
+type osc777Notifier struct{ w io.Writer }
+
+func (o osc777Notifier) notify(n notification) error {
+	title := strings.ReplaceAll(escapeText(n.title()), ";", ",")
+	_, err := fmt.Fprintf(o.w, "\x1b]777;notify;%s;%s\x07", title, escapeText(n.body()))
+	return err
+}
+
+// execNotifier runs a command with the message in its environment, e.g.
+// notify-send "$CHIT_CHAT_SENDER" "$CHIT_CHAT_MESSAGE".
+type execNotifier struct{ command string }
+
+func (e execNotifier) notify(n notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyCommandTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, e.command)
+	cmd.Env = append(os.Environ(),
+		"CHIT_CHAT_SENDER="+n.sender,
+		"CHIT_CHAT_CONVERSATION="+n.conversationId,
+		"CHIT_CHAT_MESSAGE="+n.body(),
+		"CHIT_CHAT_TITLE="+n.title(),
+		"CHIT_CHAT_MENTION="+strconv.FormatBool(n.mention),
+	)
+	return cmd.Run()
+}
+
+// escapeText makes text safe inside an escape sequence: control characters
+// become spaces and it is cut to maxNotificationText runes.
+func escapeText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	if runes := []rune(text); len(runes) > maxNotificationText {
+		text = string(runes[:maxNotificationText-1]) + "…"
+	}
+	return text
+}
+
+// parseNotifiers builds the notifiers named in methods, writing escapes
+// to w.
+func parseNotifiers(methods string, command string, w io.Writer) ([]notifier, error) {
+	var notifiers []notifier
+	for _, method := range strings.Split(methods, ",") {
+		switch strings.TrimSpace(method) {
+		case "none", "":
+		case "bell":
+			notifiers = append(notifiers, bellNotifier{w})
+		case "osc9":
+			notifiers = append(notifiers, osc9Notifier{w})
+		case "osc777":
+			notifiers = append(notifiers, osc777Notifier{w})
+		case "exec":
+			if command == "" {
+				return nil, fmt.Errorf("-notify exec needs -notify-command")
+			}
+			notifiers = append(notifiers, execNotifier{command})
+		default:
+			return nil, fmt.Errorf("unknown notification method %q, expected bell, osc9, osc777, exec or none", method)
+		}
+	}
+	return notifiers, nil
+}
+
+// notificationCenter sends notifications through every configured notifier,
+// at most one per interval. Messages arriving in between are summed up in a
+// single notification once the interval is over.
+type notificationCenter struct {
+	mu         sync.Mutex
+	notifiers  []notifier
+	interval   time.Duration
+	last       time.Time
+	pending    *notification
+	suppressed int
+	timer      *time.Timer
+}
+
+// configure sets how notifications are sent and, when there is a way to
+// send them, turns them on in mode.
+func (nc *notificationCenter) configure(notifiers []notifier, interval time.Duration, mode notifyMode) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.notifiers = notifiers
+	nc.interval = interval
+	if len(notifiers) == 0 {
+		mode = notifyOff
+	}
+	globalNotifyMode.Store(int32(mode))
+}
+
+func (nc *notificationCenter) configured() bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return len(nc.notifiers) > 0
+}
+
+// shouldNotify applies the per-conversation rule, falling back to the
+// global one. Muted conversations never notify.
+func shouldNotify(conversationId string, mention bool) bool {
+	if isMuted(conversationId) {
+		return false
+	}
+	mode := notifyMode(globalNotifyMode.Load())
+	if rule, ok := notifyRules.Load(conversationId); ok {
+		mode = rule.(notifyMode)
+	}
+	return mode == notifyOn || mode == notifyMentions && mention
+}
+
+// message notifies about an incoming message if the rules allow it.
+func (nc *notificationCenter) message(sender string, conversationId string, content string, mention bool) {
+	if !shouldNotify(conversationId, mention) {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if len(nc.notifiers) == 0 {
+		return
+	}
+	n := notification{sender: sender, conversationId: conversationId, content: content, mention: mention}
+	if wait := nc.interval - time.Since(nc.last); wait > 0 {
+		if nc.pending != nil {
+			nc.suppressed++
+		}
+		nc.pending = &n
+		if nc.timer == nil {
+			nc.timer = time.AfterFunc(wait, nc.flush)
+		}
+		return
+	}
+	nc.last = time.Now()
+	nc.deliver(n)
+}
+
+// flush sends the latest message held back by rate limiting, counting the
+// ones before it.
+func (nc *notificationCenter) flush() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.timer = nil
+	if nc.pending == nil {
+		return
+	}
+	n := *nc.pending
+	n.suppressed = nc.suppressed
+	nc.pending, nc.suppressed = nil, 0
+	nc.last = time.Now()
+	nc.deliver(n)
+}
+
+func (nc *notificationCenter) deliver(n notification) {
+	notifiers := nc.notifiers
+	go func() {
+		for _, nf := range notifiers {
+			if err := nf.notify(n); err != nil {
+				logger.Error("notification failed", "notifier", fmt.Sprintf("%T", nf), "err", err)
+			}
+		}
+	}()
+}
+
+// reset forgets messages held back by rate limiting.
+func (nc *notificationCenter) reset() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.timer != nil {
+		nc.timer.Stop()
+		nc.timer = nil
+	}
+	nc.pending, nc.suppressed = nil, 0
+}
+
+var errNoNotifiers = errors.New("no notification method configured; start with -notify bell, osc9, osc777 or exec")
+
+// setNotifications switches the global rule.
+func setNotifications(mode notifyMode) error {
+	if mode != notifyOff && !notifications.configured() {
+		return errNoNotifiers
+	}
+	globalNotifyMode.Store(int32(mode))
+	return nil
+}
+
+func runNotify(c *ishell.Context) {
+	mode, ok := notifyMode(globalNotifyMode.Load()), true
+	if len(c.Args) == 1 {
+		mode, ok = parseNotifyMode(c.Args[0])
+	}
+	if len(c.Args) > 1 || !ok {
+		c.Println("Usage: notify [on|off|mentions]")
+		return
+	}
+	if err := setNotifications(mode); err != nil {
+		c.Println("[ERROR]", err)
+		return
+	}
+	switch {
+	case mode == notifyOn:
+		c.Printf("Notifications are on (%s)\n", notifyMethods)
+	case mode == notifyMentions:
+		c.Printf("Notifications are on for mentions only (%s)\n", notifyMethods)
+	case notifications.configured():
+		c.Println("Notifications are off")
+	default:
+		c.Println("Notifications are off;", errNoNotifiers)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubNotifier hands every notification it is asked to send to the test.
+type stubNotifier chan notification
+
+func (s stubNotifier) notify(n notification) error {
+	s <- n
+	return nil
+}
+
+// useNotifier routes notifications to a stub for the rest of the test.
+func useNotifier(t *testing.T, interval time.Duration, mode notifyMode) stubNotifier {
+	t.Helper()
+	stub := make(stubNotifier, 10)
+	notifications.configure([]notifier{stub}, interval, mode)
+	t.Cleanup(func() {
+		notifications.reset()
+		notifications.configure(nil, 0, notifyOff)
+		notifications.mu.Lock()
+		notifications.last = time.Time{}
+		notifications.mu.Unlock()
+		notifyRules.Clear()
+		mutedConversations.Clear()
+	})
+	return stub
+}
+
+// next returns the next notification sent, failing the test if none comes.
+func (s stubNotifier) next(t *testing.T) notification {
+	t.Helper()
+	select {
+	case n := <-s:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification was sent")
+		return notification{}
+	}
+}
+
+// none fails the test if a notification is sent within wait.
+func (s stubNotifier) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case n := <-s:
+		t.Fatalf("unexpected notification %+v", n)
+	case <-time.After(wait):
+	}
+}
+
+func TestNotificationsFoldWithinInterval(t *testing.T) {
+	stub := useNotifier(t, 100*time.Millisecond, notifyOn)
+
+	for _, content := range []string{"one", "two", "three", "four"} {
+		notifications.message("Bob", "c1", content, false)
+	}
+	if n := stub.next(t); n.content != "one" || n.suppressed != 0 {
+		t.Errorf("first notification = %+v, want one on its own", n)
+	}
+	n := stub.next(t)
+	if n.content != "four" || n.suppressed != 2 {
+		t.Errorf("folded notification = %+v, want four with 2 suppressed", n)
+	}
+	if got, want := n.body(), "four (and 2 more)"; got != want {
+		t.Errorf("folded body = %q, want %q", got, want)
+	}
+	stub.none(t, 200*time.Millisecond)
+}
+
+func TestNotificationsResetDropsHeldBack(t *testing.T) {
+	stub := useNotifier(t, 50*time.Millisecond, notifyOn)
+
+	notifications.message("Bob", "c1", "one", false)
+	notifications.message("Bob", "c1", "two", false)
+	stub.next(t)
+	notifications.reset()
+	stub.none(t, 150*time.Millisecond)
+}
+
+func TestShouldNotify(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  notifyMode
+		rule    *notifyMode
+		muted   bool
+		mention bool
+		want    bool
+	}{
+		{"global on", notifyOn, nil, false, false, true},
+		{"global off", notifyOff, nil, false, true, false},
+		{"global mentions, plain message", notifyMentions, nil, false, false, false},
+		{"global mentions, mention", notifyMentions, nil, false, true, true},
+		{"rule off overrides global on", notifyOn, ptr(notifyOff), false, true, false},
+		{"rule on overrides global off", notifyOff, ptr(notifyOn), false, false, true},
+		{"rule mentions overrides global on", notifyOn, ptr(notifyMentions), false, false, false},
+		{"rule mentions, mention", notifyOff, ptr(notifyMentions), false, true, true},
+		{"muted beats global on", notifyOn, nil, true, true, false},
+		{"muted beats rule on", notifyOff, ptr(notifyOn), true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useNotifier(t, 0, tt.global)
+			if tt.rule != nil {
+				notifyRules.Store("c1", *tt.rule)
+			}
+			if tt.muted {
+				mutedConversations.Store("c1", true)
+			}
+			if got := shouldNotify("c1", tt.mention); got != tt.want {
+				t.Errorf("shouldNotify = %v, want %v", got, tt.want)
+			}
+			// Rules for one conversation leave the others alone.
+			if got, want := shouldNotify("c2", tt.mention), tt.global == notifyOn || tt.global == notifyMentions && tt.mention; got != want {
+				t.Errorf("shouldNotify for another conversation = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNotificationsFollowRules(t *testing.T) {
+	stub := useNotifier(t, 0, notifyOff)
+	notifyRules.Store("c1", notifyOn)
+
+	notifications.message("Bob", "c2", "ignored", false)
+	notifications.message("Bob", "c1", "hello", false)
+	if n := stub.next(t); n.conversationId != "c1" || n.content != "hello" {
+		t.Errorf("notification = %+v, want hello in c1", n)
+	}
+	stub.none(t, 50*time.Millisecond)
+}
+
+func TestNotifyWithoutNotifiers(t *testing.T) {
+	useNotifier(t, 0, notifyOff)
+	notifications.configure(nil, 0, notifyOn)
+	if mode := notifyMode(globalNotifyMode.Load()); mode != notifyOff {
+		t.Errorf("configure without notifiers left notifications %s", mode)
+	}
+	if err := setNotifications(notifyOn); err != errNoNotifiers {
+		t.Errorf("setNotifications(on) = %v, want %v", err, errNoNotifiers)
+	}
+	if err := setNotifications(notifyOff); err != nil {
+		t.Errorf("setNotifications(off) = %v", err)
+	}
+}
+
+func TestEscapeNotifications(t *testing.T) {
+	hostile := notification{
+		sender:  "Eve;\x1b]0;pwned\x07",
+		content: "hi\x1b]52;c;Zm9v\x07\u009c\nthere; friend",
+		mention: true,
+	}
+	tests := []struct {
+		name string
+		nf   func(w *bytes.Buffer) notifier
+		want string
+	}{
+		{
+			"osc9",
+			func(w *bytes.Buffer) notifier { return osc9Notifier{w} },
+			"\x1b]9;Chit-Chat-Go: Eve; ]0;pwned  mentioned you: hi ]52;c;Zm9v   there; friend\x07",
+		},
+		{
+			"osc777",
+			func(w *bytes.Buffer) notifier { return osc777Notifier{w} },
+			"\x1b]777;notify;Chit-Chat-Go: Eve, ]0,pwned  mentioned you;hi ]52;c;Zm9v   there; friend\x07",
+		},
+		{
+			"bell",
+			func(w *bytes.Buffer) notifier { return bellNotifier{w} },
+			"\a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := tt.nf(&out).notify(hostile); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeTextTruncates(t *testing.T) {
+	got := escapeText(strings.Repeat("é", maxNotificationText+10))
+	if runes := []rune(got); len(runes) != maxNotificationText || runes[len(runes)-1] != '…' {
+		t.Errorf("escapeText kept %d runes ending in %q, want %d ending in …", len(runes), runes[len(runes)-1], maxNotificationText)
+	}
+	if got := escapeText("short"); got != "short" {
+		t.Errorf("escapeText(%q) = %q", "short", got)
+	}
+}
+
+func TestParseNotifiers(t *testing.T) {
+	tests := []struct {
+		methods string
+		command string
+		want    int
+		err     string
+	}{
+		{"none", "", 0, ""},
+		{"bell, osc9,osc777", "", 3, ""},
+		{"exec", "notify-send x", 1, ""},
+		{"exec", "", 0, "needs -notify-command"},
+		{"popup", "", 0, "unknown notification method"},
+	}
+	for _, tt := range tests {
+		got, err := parseNotifiers(tt.methods, tt.command, &bytes.Buffer{})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseNotifiers(%q) error = %v, want %q", tt.methods, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || len(got) != tt.want {
+			t.Errorf("parseNotifiers(%q) = %d notifiers, %v; want %d", tt.methods, len(got), err, tt.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
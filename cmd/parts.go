@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// partsExpiry is how long the parts of a split message are kept waiting for
+// the rest to arrive.
+const partsExpiry = 5 * time.Minute
+
+const (
+	minPartSize = 256
+	// messageOverhead covers the part header and the rest of the event
+	// around the content when sizing the largest request.
+	messageOverhead = 4 << 10
+)
+
+var errMessageTooLarge = errors.New("message too large")
+
+// maxMessageSize bounds the text of a single message; maxPartSize is the
+// most sent in one event, longer messages going out in several parts.
+var maxMessageSize = 1 << 20
+var maxPartSize = 32 << 10
+
+// partHeader prefixes each part of a split message. Clients that do not
+// reassemble parts still show them in order with a readable marker.
+var partHeader = regexp.MustCompile(`^\[part (\d+)/(\d+) ([0-9a-f]{8})\] `)
+
+// escapedPartHeader matches a message that starts like a part, after any
+// number of partEscapes. Such messages are sent with one more partEscape so
+// they are not taken for a part, which receivers remove again.
+var escapedPartHeader = regexp.MustCompile(`^\\*\[part \d+/\d+ [0-9a-f]{8}\] `)
+
+const partEscape = `\`
+
+// maxPartialMessages bounds the split messages waiting for the rest of
+// their parts at once; beyond it the oldest is dropped.
+const maxPartialMessages = 64
+
+var partial = &partBuffer{messages: map[string]*partialMessage{}}
+
+// maxParts is the most parts a message within maxMessageSize can be split
+// into. Headers claiming more are not parts of any message we would accept.
+func maxParts() int {
+	return (maxMessageSize + minPartSize - 1) / minPartSize
+}
+
+func checkMessageSize(content string) error {
+	if len(content) > maxMessageSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", errMessageTooLarge, len(content), maxMessageSize)
+	}
+	return nil
+}
+
+// splitMessage cuts content into parts of at most maxPartSize bytes, on rune
+// boundaries, each carrying a partHeader. Short messages are returned as
+// is, escaped when they look like a part.
+func splitMessage(content string) []string {
+	if len(content) <= maxPartSize {
+		if escapedPartHeader.MatchString(content) {
+			content = partEscape + content
+		}
+		return []string{content}
+	}
+
+	var chunks []string
+	for len(content) > 0 {
+		n := min(maxPartSize, len(content))
+		for n < len(content) && n > 0 && !utf8.RuneStart(content[n]) {
+			n--
+		}
+		if n == 0 {
+			_, n = utf8.DecodeRuneInString(content)
+		}
+		chunks = append(chunks, content[:n])
+		content = content[n:]
+	}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+	for i, chunk := range chunks {
+		chunks[i] = fmt.Sprintf("[part %d/%d %s] %s", i+1, len(chunks), hex.EncodeToString(id), chunk)
+	}
+	return chunks
+}
+
+type partialMessage struct {
+	parts    []string
+	received int
+	started  time.Time
+	// seq orders messages by their first part to arrive.
+	seq uint64
+}
+
+// partBuffer collects the parts of split messages until each is complete.
+type partBuffer struct {
+	mu       sync.Mutex
+	messages map[string]*partialMessage
+	lastSeq  uint64
+}
+
+// add takes a received message body. It returns the whole message and true
+// once every part from sender has arrived, or content unescaped and true
+// when it is not a part at all. A header with an impossible index or total
+// is treated as plain text.
+func (b *partBuffer) add(sender string, content string) (string, bool) {
+	if strings.HasPrefix(content, partEscape) && escapedPartHeader.MatchString(content) {
+		return content[len(partEscape):], true
+	}
+	m := partHeader.FindStringSubmatch(content)
+	if m == nil {
+		return content, true
+	}
+	index, err1 := strconv.Atoi(m[1])
+	total, err2 := strconv.Atoi(m[2])
+	if err1 != nil || err2 != nil || index < 1 || index > total || total > maxParts() {
+		return content, true
+	}
+	body := content[len(m[0]):]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, msg := range b.messages {
+		if time.Since(msg.started) > partsExpiry {
+			delete(b.messages, key)
+		}
+	}
+
+	key := sender + "/" + m[3]
+	msg, ok := b.messages[key]
+	if !ok {
+		if len(b.messages) >= maxPartialMessages {
+			b.dropOldest()
+		}
+		b.lastSeq++
+		msg = &partialMessage{parts: make([]string, total), started: time.Now(), seq: b.lastSeq}
+		b.messages[key] = msg
+	}
+	if len(msg.parts) != total || msg.parts[index-1] != "" {
+		return "", false
+	}
+	msg.parts[index-1] = body
+	msg.received++
+	if msg.received < total {
+		return "", false
+	}
+	delete(b.messages, key)
+	return strings.Join(msg.parts, ""), true
+}
+
+// dropOldest forgets the incomplete message that started first. b.mu must
+// be held.
+func (b *partBuffer) dropOldest() {
+	var oldest string
+	for key, msg := range b.messages {
+		if oldest == "" || msg.seq < b.messages[oldest].seq {
+			oldest = key
+		}
+	}
+	delete(b.messages, oldest)
+}
+
+// reset drops every incomplete message.
+func (b *partBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.messages)
+}
+
+// joinStoredParts reassembles split messages in a conversation's stored
+// history. It also returns the ids the reassembled messages are left with,
+// their last parts'.
+func joinStoredParts(msgs []*pkg.ConversationMessage) ([]*pkg.ConversationMessage, []string) {
+	buffer := &partBuffer{messages: map[string]*partialMessage{}}
+	var joined []*pkg.ConversationMessage
+	var split []string
+	for _, msg := range msgs {
+		content, ok := buffer.add(msg.GetFrom().GetClientId(), msg.GetContent())
+		if !ok {
+			continue
+		}
+		if content != msg.GetContent() && !strings.HasPrefix(msg.GetContent(), partEscape) {
+			split = append(split, msg.GetId())
+		}
+		joined = append(joined, &pkg.ConversationMessage{
+			From:       msg.GetFrom(),
+			Content:    content,
+			Id:         msg.GetId(),
+			SentAt:     msg.GetSentAt(),
+			ReceivedAt: msg.GetReceivedAt(),
+		})
+	}
+	return joined, split
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+func TestSplitMessageRoundTrip(t *testing.T) {
+	content := strings.Repeat("héllo wörld ", maxPartSize/4)
+	parts := splitMessage(content)
+	if len(parts) < 2 {
+		t.Fatalf("splitMessage returned %d parts, want several", len(parts))
+	}
+
+	buffer := &partBuffer{messages: map[string]*partialMessage{}}
+	for i, part := range parts {
+		got, complete := buffer.add("alice", part)
+		if last := i == len(parts)-1; complete != last {
+			t.Fatalf("part %d: complete = %v, want %v", i+1, complete, last)
+		}
+		if complete && got != content {
+			t.Fatalf("reassembled message differs from the original")
+		}
+	}
+}
+
+func TestPartBufferRejectsImpossibleHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"huge total", "[part 1/4611686018427387904 deadbeef] hi"},
+		{"total overflowing int", "[part 1/99999999999999999999999 deadbeef] hi"},
+		{"total above the cap", fmt.Sprintf("[part 1/%d deadbeef] hi", maxParts()+1)},
+		{"index zero", "[part 0/2 deadbeef] hi"},
+		{"index past total", "[part 3/2 deadbeef] hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := &partBuffer{messages: map[string]*partialMessage{}}
+			got, complete := buffer.add("mallory", tt.content)
+			if !complete || got != tt.content {
+				t.Errorf("add(%q) = %q, %v; want the content back as plain text", tt.content, got, complete)
+			}
+			if len(buffer.messages) != 0 {
+				t.Errorf("add(%q) kept %d partial messages, want none", tt.content, len(buffer.messages))
+			}
+		})
+	}
+}
+
+func TestPartBufferAcceptsTotalAtCap(t *testing.T) {
+	buffer := &partBuffer{messages: map[string]*partialMessage{}}
+	content := fmt.Sprintf("[part 1/%d deadbeef] hi", maxParts())
+	if _, complete := buffer.add("alice", content); complete {
+		t.Fatalf("the first of %d parts completed the message", maxParts())
+	}
+}
+
+func TestJoinStoredPartsWithHostileHeader(t *testing.T) {
+	from := &pkg.Client{ClientId: "mallory", Name: "Mallory"}
+	msgs := []*pkg.ConversationMessage{
+		{From: from, Content: "[part 1/4611686018427387904 deadbeef] boom"},
+		{From: from, Content: "[part 1/2 0badf00d] hel"},
+		{From: from, Content: "[part 2/2 0badf00d] lo"},
+	}
+	joined, split := joinStoredParts(msgs)
+	if len(joined) != 2 {
+		t.Fatalf("joinStoredParts returned %d messages, want 2", len(joined))
+	}
+	if joined[0].GetContent() != msgs[0].GetContent() || joined[1].GetContent() != "hello" {
+		t.Errorf("joinStoredParts = %q, %q", joined[0].GetContent(), joined[1].GetContent())
+	}
+	if len(split) != 1 || split[0] != joined[1].GetId() {
+		t.Errorf("joinStoredParts reported split ids %q, want the joined message's", split)
+	}
+}
+
+func TestSplitMessageEscapesPartHeaders(t *testing.T) {
+	tests := []struct {
+		content string
+		sent    string
+	}{
+		{"[part 1/2 deadbeef] hi", `\[part 1/2 deadbeef] hi`},
+		{`\[part 1/2 deadbeef] hi`, `\\[part 1/2 deadbeef] hi`},
+		{"[part 1/2 nothex!!] hi", "[part 1/2 nothex!!] hi"},
+		{`\hi`, `\hi`},
+		{"see [part 1/2 deadbeef] hi", "see [part 1/2 deadbeef] hi"},
+	}
+	for _, tt := range tests {
+		parts := splitMessage(tt.content)
+		if len(parts) != 1 || parts[0] != tt.sent {
+			t.Errorf("splitMessage(%q) = %q, want %q", tt.content, parts, tt.sent)
+			continue
+		}
+		buffer := &partBuffer{messages: map[string]*partialMessage{}}
+		if got, complete := buffer.add("alice", parts[0]); !complete || got != tt.content {
+			t.Errorf("add(%q) = %q, %v; want %q back", parts[0], got, complete, tt.content)
+		}
+	}
+
+	joined, split := joinStoredParts([]*pkg.ConversationMessage{{Id: "m1", Content: `\[part 1/2 deadbeef] hi`}})
+	if len(joined) != 1 || joined[0].GetContent() != "[part 1/2 deadbeef] hi" || len(split) != 0 {
+		t.Errorf("joinStoredParts = %v, split %q; want the message unescaped and not split", joined, split)
+	}
+}
+
+func TestPartBufferKeepsBoundedPartialMessages(t *testing.T) {
+	buffer := &partBuffer{messages: map[string]*partialMessage{}}
+	for i := range maxPartialMessages + 10 {
+		buffer.add("mallory", fmt.Sprintf("[part 1/2 %08x] a", i))
+	}
+	if len(buffer.messages) != maxPartialMessages {
+		t.Fatalf("the buffer holds %d partial messages, want %d", len(buffer.messages), maxPartialMessages)
+	}
+	if _, complete := buffer.add("mallory", fmt.Sprintf("[part 2/2 %08x] b", 0)); complete {
+		t.Error("the oldest partial message was kept past the limit")
+	}
+	last := maxPartialMessages + 9
+	if got, complete := buffer.add("mallory", fmt.Sprintf("[part 2/2 %08x] b", last)); !complete || got != "ab" {
+		t.Errorf("completing the newest message = %q, %v; want ab", got, complete)
+	}
+}
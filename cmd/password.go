@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc/codes"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+)
+
+// maxResetCodeAttempts is how many reset codes are tried before giving up.
+const maxResetCodeAttempts = 3
+
+func changePassword(ctx context.Context, email string, current string, next string) error {
+	_, err := authClient().ChangePassword(ctx, &pkg.ChangePasswordRequest{
+		Email:           email,
+		CurrentPassword: current,
+		NewPassword:     next,
+	})
+	if err != nil {
+		logger.Error("password change failed", "email", email, "err", err)
+		return err
+	}
+	logger.Info("password changed", "email", email)
+	return nil
+}
+
+func requestPasswordReset(ctx context.Context, email string) error {
+	_, err := authClient().RequestPasswordReset(ctx, &pkg.PasswordResetRequest{Email: email})
+	if err != nil {
+		logger.Error("password reset request failed", "email", email, "err", err)
+		return err
+	}
+	logger.Info("password reset requested", "email", email)
+	return nil
+}
+
+func confirmPasswordReset(ctx context.Context, email string, code string, password string) error {
+	_, err := authClient().ConfirmPasswordReset(ctx, &pkg.ConfirmPasswordResetRequest{
+		Email:       email,
+		Code:        code,
+		NewPassword: password,
+	})
+	if err != nil {
+		logger.Error("password reset failed", "email", email, "err", err)
+		return err
+	}
+	logger.Info("password reset", "email", email)
+	return nil
+}
+
+// printFieldViolations shows the server's objections to individual fields,
+// returning false when there were none to show.
+func printFieldViolations(c *ishell.Context, err error) bool {
+	violations := rpcerr.From(err).Violations
+	for _, violation := range violations {
+		c.Printf("[ERROR] %s: %s\n", violation.Field, violation.Description)
+	}
+	return len(violations) > 0
+}
+
+func runPasswd(c *ishell.Context) {
+	email := account.GetEmail()
+	current, ok := promptPassword(c, "Current Password: ")
+	if !ok {
+		return
+	}
+	next, ok := promptNewPassword(c, "New Password: ", email)
+	if !ok {
+		return
+	}
+
+	err := changePassword(commandContext(), email, current, next)
+	switch {
+	case err == nil:
+		c.Println("Password changed")
+		updateSavedPassword(c, email, next)
+	case errors.Is(err, rpcerr.AuthFailed):
+		c.Println("[ERROR] Current password is wrong")
+	case errors.Is(err, rpcerr.Invalid):
+		if !printFieldViolations(c, err) {
+			c.Println("[ERROR] The server rejected the new password:", rpcerr.From(err).Message)
+		}
+	default:
+		printRPCError(c, err, "Unable to change password")
+	}
+}
+
+// runResetPassword asks the server to send a reset code to the account's
+// email, then sets a new password with it.
+func runResetPassword(c *ishell.Context) {
+	email, ok := promptField(c, "Email: ", validateEmail)
+	if !ok {
+		return
+	}
+	if err := requestPasswordReset(commandContext(), email); err != nil {
+		printRPCError(c, err, "Unable to request a password reset")
+		return
+	}
+	c.Printf("If an account exists for %s, a reset code is on its way there\n", email)
+
+	password := ""
+	for attempt := 1; ; attempt++ {
+		code, ok := promptField(c, "Reset Code: ", required("the reset code"))
+		if !ok {
+			return
+		}
+		if password == "" {
+			if password, ok = promptNewPassword(c, "New Password: ", email); !ok {
+				return
+			}
+		}
+
+		err := confirmPasswordReset(commandContext(), email, code, password)
+		switch e := rpcerr.From(err); {
+		case err == nil:
+			c.Println("Password reset, you can now login with it")
+			updateSavedPassword(c, email, password)
+			return
+		case e.Kind == rpcerr.NotFound, e.Kind == rpcerr.AuthFailed, e.Code == codes.FailedPrecondition:
+			if attempt >= maxResetCodeAttempts {
+				c.Println("[ERROR] The reset code is wrong or has expired; run reset-password again for a new one")
+				return
+			}
+			c.Println("  the reset code is wrong or has expired, check it and try again")
+		case e.Kind == rpcerr.Invalid:
+			if !printFieldViolations(c, err) {
+				c.Println("[ERROR] The server rejected the reset:", e.Message)
+				return
+			}
+			for _, violation := range e.Violations {
+				if strings.Contains(strings.ToLower(violation.Field), "password") {
+					password = ""
+				}
+			}
+		default:
+			printRPCError(c, err, fmt.Sprintf("Unable to reset the password for %s", email))
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+const testEmail = "alice@example.com"
+
+func TestPasswd(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		want     string
+		password string
+	}{
+		{"changed", []string{"old-pass1", "new-pass1", "new-pass1"}, "Password changed", "new-pass1"},
+		{"wrong current password", []string{"not-it1", "new-pass1", "new-pass1"}, "[ERROR] Current password is wrong", "old-pass1"},
+		{"rejected by the server", []string{"old-pass1", breachedPassword, breachedPassword}, "[ERROR] new_password: appears in a known data breach", "old-pass1"},
+		{"too weak, then strong", []string{"old-pass1", "short1", "new-pass1", "new-pass1"}, "Password changed", "new-pass1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startFakeServer(t)
+			srv.addAccount(testEmail, "old-pass1")
+			connectTo(t, srv.addr)
+			account = &pkg.Account{Id: testEmail, Email: testEmail}
+			t.Cleanup(func() { account = nil })
+
+			out := runCommand(t, runPasswd, tt.input...)
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("passwd printed %q, want it to contain %q", out, tt.want)
+			}
+			if got := srv.password(testEmail); got != tt.password {
+				t.Errorf("the password on the server is %q, want %q", got, tt.password)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "old-pass1")
+	connectTo(t, srv.addr)
+
+	term := startCommand(t, runResetPassword)
+	term.answer("Email: ", testEmail)
+	term.expect("a reset code is on its way")
+	term.answer("Reset Code: ", srv.resetCode(t, testEmail))
+	term.answer("New Password: ", "new-pass1")
+	term.answer("Confirm Password: ", "new-pass1")
+	if out := term.wait(); !strings.Contains(out, "Password reset, you can now login with it") {
+		t.Errorf("reset-password printed %q", out)
+	}
+	if got := srv.password(testEmail); got != "new-pass1" {
+		t.Errorf("the password on the server is %q, want new-pass1", got)
+	}
+}
+
+func TestResetPasswordWrongCode(t *testing.T) {
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "old-pass1")
+	connectTo(t, srv.addr)
+
+	term := startCommand(t, runResetPassword)
+	term.answer("Email: ", testEmail)
+	term.answer("Reset Code: ", "000000x")
+	term.answer("New Password: ", "new-pass1")
+	term.answer("Confirm Password: ", "new-pass1")
+	term.expect("the reset code is wrong or has expired, check it and try again")
+	// The new password is kept, so only the code is asked for again.
+	term.answer("Reset Code: ", srv.resetCode(t, testEmail))
+	out := term.wait()
+	if !strings.Contains(out, "Password reset, you can now login with it") {
+		t.Errorf("reset-password printed %q", out)
+	}
+	if strings.Count(out, "New Password: ") != 1 {
+		t.Errorf("reset-password asked for the new password again after a wrong code: %q", out)
+	}
+	if got := srv.password(testEmail); got != "new-pass1" {
+		t.Errorf("the password on the server is %q, want new-pass1", got)
+	}
+}
+
+func TestResetPasswordGivesUpAfterWrongCodes(t *testing.T) {
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "old-pass1")
+	connectTo(t, srv.addr)
+
+	input := []string{testEmail, "wrong-1", "new-pass1", "new-pass1"}
+	for range maxResetCodeAttempts - 1 {
+		input = append(input, "wrong-1")
+	}
+	out := runCommand(t, runResetPassword, input...)
+	if !strings.Contains(out, "[ERROR] The reset code is wrong or has expired") {
+		t.Errorf("reset-password printed %q", out)
+	}
+	if got := strings.Count(out, "Reset Code: "); got != maxResetCodeAttempts {
+		t.Errorf("reset-password asked for the code %d times, want %d", got, maxResetCodeAttempts)
+	}
+	if got := srv.password(testEmail); got != "old-pass1" {
+		t.Errorf("the password on the server is %q, want it unchanged", got)
+	}
+}
+
+func TestResetPasswordRejectedPassword(t *testing.T) {
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "old-pass1")
+	connectTo(t, srv.addr)
+
+	term := startCommand(t, runResetPassword)
+	term.answer("Email: ", testEmail)
+	term.expect("a reset code is on its way")
+	code := srv.resetCode(t, testEmail)
+	term.answer("Reset Code: ", code)
+	term.answer("New Password: ", breachedPassword)
+	term.answer("Confirm Password: ", breachedPassword)
+	term.expect("[ERROR] new_password: appears in a known data breach")
+	// A rejected password is asked for again, and the code is still good.
+	term.answer("Reset Code: ", code)
+	term.answer("New Password: ", "new-pass1")
+	term.answer("Confirm Password: ", "new-pass1")
+	if out := term.wait(); !strings.Contains(out, "Password reset, you can now login with it") {
+		t.Errorf("reset-password printed %q", out)
+	}
+	if got := srv.password(testEmail); got != "new-pass1" {
+		t.Errorf("the password on the server is %q, want new-pass1", got)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+)
+
+// profileField is an editable part of the account. name is the field name
+// sent in UpdateAccountRequest.update_fields.
+type profileField struct {
+	name     string
+	label    string
+	validate func(string) (string, error)
+	get      func(*pkg.Account) string
+	set      func(*pkg.Account, string)
+}
+
+var profileFields = []profileField{
+	{"first_name", "First Name", required("first name"), (*pkg.Account).GetFirstName, func(a *pkg.Account, v string) { a.FirstName = v }},
+	{"last_name", "Last Name", required("last name"), (*pkg.Account).GetLastName, func(a *pkg.Account, v string) { a.LastName = v }},
+	{"email", "Email", validateEmail, (*pkg.Account).GetEmail, func(a *pkg.Account, v string) { a.Email = v }},
+	{"phone_number", "Phone Number", normalizePhone, (*pkg.Account).GetPhoneNumber, func(a *pkg.Account, v string) { a.PhoneNumber = v }},
+}
+
+func fetchAccount(ctx context.Context) (*pkg.Account, error) {
+	response, err := authClient().GetAccount(ctx, &pkg.GetAccountRequest{Id: me().GetClientId()})
+	if err != nil {
+		logger.Error("failed to fetch account", "client_id", me().GetClientId(), "err", err)
+		return nil, err
+	}
+	return response, nil
+}
+
+func updateAccount(ctx context.Context, updated *pkg.Account, fields []string) (*pkg.Account, error) {
+	response, err := authClient().UpdateAccount(ctx, &pkg.UpdateAccountRequest{
+		Account:      updated,
+		UpdateFields: fields,
+	})
+	if err != nil {
+		logger.Error("failed to update account", "client_id", updated.GetId(), "fields", fields, "err", err)
+		return nil, err
+	}
+	logger.Info("account updated", "client_id", updated.GetId(), "fields", fields)
+	return response, nil
+}
+
+// setAccount records the signed in account and carries a new display name
+// over to the open conversation, whose members travel with every message.
+// The client and members are replaced by changed copies, as messages
+// already handed out refer to them.
+func setAccount(updated *pkg.Account) {
+	account = updated
+	client := proto.Clone(me()).(*pkg.Client)
+	client.Name = updated.GetFirstName()
+	self.Store(client)
+	members := make([]*pkg.Client, len(conversation.GetMembers()))
+	for i, member := range conversation.GetMembers() {
+		if member.GetClientId() == client.GetClientId() {
+			member = client
+		}
+		members[i] = member
+	}
+	if conversation.Members != nil {
+		conversation.Members = members
+	}
+}
+
+func printAccount(c *ishell.Context, acc *pkg.Account) {
+	c.Printf("  %-14s %s\n", "Id", acc.GetId())
+	for _, field := range profileFields {
+		value := field.get(acc)
+		if value == "" {
+			value = "-"
+		}
+		c.Printf("  %-14s %s\n", field.label, value)
+	}
+}
+
+// runWhoami shows the signed in account, as the server has it when it can
+// be reached.
+func runWhoami(c *ishell.Context) {
+	current, err := fetchAccount(commandContext())
+	if err != nil {
+		printRPCError(c, err, "Unable to fetch your account, showing what was known at login")
+		current = account
+	} else {
+		setAccount(current)
+	}
+	printAccount(c, current)
+}
+
+// runProfileEdit asks for each field with the current value as default,
+// shows what would change and saves it once confirmed.
+func runProfileEdit(c *ishell.Context) {
+	current, err := fetchAccount(commandContext())
+	if err != nil {
+		printRPCError(c, err, "Unable to fetch your account")
+		return
+	}
+	c.Println("Press enter to keep a value")
+
+	updated := proto.Clone(current).(*pkg.Account)
+	var changed []string
+	for _, field := range profileFields {
+		old := field.get(current)
+		value, ok := promptField(c, field.label+" ["+old+"]: ", func(raw string) (string, error) {
+			if strings.TrimSpace(raw) == "" {
+				return old, nil
+			}
+			return field.validate(raw)
+		})
+		if !ok {
+			return
+		}
+		if value != old {
+			field.set(updated, value)
+			changed = append(changed, field.name)
+		}
+	}
+	if len(changed) == 0 {
+		c.Println("Nothing changed")
+		return
+	}
+
+	c.Println("About to save:")
+	for _, field := range profileFields {
+		if old, value := field.get(current), field.get(updated); old != value {
+			c.Printf("  %-14s %q -> %q\n", field.label, old, value)
+		}
+	}
+	answer, ok := prompt(c, "Save these changes? [y/N] ")
+	if !ok || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		c.Println("Discarded")
+		return
+	}
+
+	saved, err := updateAccount(commandContext(), updated, changed)
+	switch {
+	case err == nil:
+		setAccount(saved)
+		c.Println("Profile saved")
+	case errors.Is(err, rpcerr.Conflict):
+		c.Println("[ERROR] Another account already uses email", updated.GetEmail())
+	case errors.Is(err, rpcerr.Invalid):
+		if !printFieldViolations(c, err) {
+			c.Println("[ERROR] The server rejected the changes:", rpcerr.From(err).Message)
+		}
+	default:
+		printRPCError(c, err, "Unable to save your profile")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// withProfile logs in as testEmail with the server holding a fuller account
+// than the login reports.
+func withProfile(t *testing.T) *fakeServer {
+	t.Helper()
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "pass-word1")
+	srv.mu.Lock()
+	srv.accounts[testEmail].LastName = "Smith"
+	srv.mu.Unlock()
+	loginTo(t, srv, srv.addr, testEmail)
+	return srv
+}
+
+func TestWhoami(t *testing.T) {
+	srv := withProfile(t)
+	out := runCommand(t, runWhoami)
+	for _, want := range []string{"Id             " + testEmail, "First Name     " + testEmail, "Last Name      Smith", "Phone Number   -"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("whoami printed %q, want %q", out, want)
+		}
+	}
+	if account.GetLastName() != "Smith" {
+		t.Errorf("whoami kept the account from login: %v", account)
+	}
+
+	srv.failFirst("GetAccount", 1, codes.Internal)
+	account = &pkg.Account{Id: testEmail, Email: testEmail, FirstName: "Known"}
+	out = runCommand(t, runWhoami)
+	if !strings.Contains(out, "[ERROR] Unable to fetch your account, showing what was known at login") || !strings.Contains(out, "First Name     Known") {
+		t.Errorf("whoami printed %q with the server failing", out)
+	}
+}
+
+func TestProfileEdit(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		out   []string
+		// saved is the account the server ends up with, nil when unchanged.
+		saved *pkg.Account
+	}{
+		{
+			name:  "save",
+			input: []string{"Alice", "", "", "+1 415 555 2671", "y"},
+			out: []string{
+				"About to save:",
+				`  First Name     "` + testEmail + `" -> "Alice"`,
+				`  Phone Number   "" -> "+14155552671"`,
+				"Profile saved",
+			},
+			saved: &pkg.Account{Id: testEmail, Email: testEmail, FirstName: "Alice", LastName: "Smith", PhoneNumber: "+14155552671"},
+		},
+		{
+			name:  "discard",
+			input: []string{"Alice", "", "", "", "n"},
+			out:   []string{`  First Name     "` + testEmail + `" -> "Alice"`, "Discarded"},
+		},
+		{
+			name:  "nothing changed",
+			input: []string{"", "", "", ""},
+			out:   []string{"Nothing changed"},
+		},
+		{
+			name:  "invalid answer asked again",
+			input: []string{"", "", "bob", "", ""},
+			out:   []string{"Email [" + testEmail + "]: ", "Email [" + testEmail + "]: ", "Nothing changed"},
+		},
+		{
+			name:  "taken email",
+			input: []string{"", "", "bob@example.com", "", "y"},
+			out:   []string{"[ERROR] Another account already uses email bob@example.com"},
+		},
+		{
+			name:  "refused phone",
+			input: []string{"", "", "", unreachablePhone, "y"},
+			out:   []string{"[ERROR] phone_number: is not in service"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := withProfile(t)
+			srv.addAccount("bob@example.com", "pass-word1")
+			before := srv.account(testEmail)
+
+			out := runCommand(t, runProfileEdit, tt.input...)
+			seen := 0
+			for _, want := range tt.out {
+				i := strings.Index(out[seen:], want)
+				if i < 0 {
+					t.Fatalf("profile edit printed %q, want %q in order", out, want)
+				}
+				seen += i + len(want)
+			}
+			want := tt.saved
+			if want == nil {
+				want = before
+			}
+			if got := srv.account(testEmail); !proto.Equal(got, want) {
+				t.Errorf("the server has %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestProfileEditRenames(t *testing.T) {
+	srv := withProfile(t)
+	openConversation(t)
+	before := me()
+	members := &pkg.Conversation{Id: conversation.GetId(), Members: append([]*pkg.Client{bob}, conversation.GetMembers()...)}
+
+	// Mentions of the user, which the receiving goroutine checks against
+	// the user's name, keep arriving while the name changes.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			srv.forward(members, &pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{
+				Conversation: members,
+				From:         bob,
+				Content:      "@Alice look",
+			}}})
+		}
+	}()
+	out := runCommand(t, runProfileEdit, "Alice", "", "", "", "y")
+	close(stop)
+	wg.Wait()
+
+	if !strings.Contains(out, "Profile saved") {
+		t.Fatalf("profile edit printed %q", out)
+	}
+	if me().GetName() != "Alice" || me().GetClientId() != testEmail {
+		t.Errorf("the user chats as %v, want the new name", me())
+	}
+	if before.GetName() != testEmail {
+		t.Errorf("the client handed out before was changed to %q", before.GetName())
+	}
+	for _, member := range conversation.GetMembers() {
+		if member.GetClientId() == testEmail && member.GetName() != "Alice" {
+			t.Errorf("the conversation lists the user as %q, want the new name", member.GetName())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+)
+
+// proxyDirect as the -proxy value ignores the proxy environment variables.
+const proxyDirect = "direct"
+
+// proxyAddress is the -proxy value: an http://, https:// or socks5:// URL,
+// with optional user:password, overriding HTTPS_PROXY and ALL_PROXY.
+var proxyAddress string
+
+func isUnixTarget(target string) bool {
+	return strings.HasPrefix(target, "unix:") || strings.HasPrefix(target, "unix-abstract:")
+}
+
+// proxyFor returns the proxy to reach addr through, or nil to dial it
+// directly. Without -proxy it follows HTTPS_PROXY, then ALL_PROXY, both
+// subject to NO_PROXY, with the same default ports as -proxy.
+func proxyFor(addr string) (*url.URL, error) {
+	switch proxyAddress {
+	case proxyDirect:
+		return nil, nil
+	case "":
+	default:
+		return parseProxyURL(proxyAddress)
+	}
+
+	config := httpproxy.FromEnvironment()
+	if config.HTTPSProxy == "" {
+		config.HTTPSProxy = firstEnv("ALL_PROXY", "all_proxy")
+	}
+	if config.HTTPSProxy == "" {
+		return nil, nil
+	}
+	if _, err := parseProxyURL(config.HTTPSProxy); err != nil {
+		return nil, err
+	}
+	u, err := config.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if u == nil || err != nil {
+		return u, err
+	}
+	return parseProxyURL(u.String())
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Port() == "" {
+		port := "1080"
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// proxyDialOptions routes connections through the configured proxy. Unix
+// socket targets are local and always dialed directly.
+func proxyDialOptions(target string) ([]grpc.DialOption, error) {
+	if isUnixTarget(target) {
+		return nil, nil
+	}
+	if proxyAddress != "" && proxyAddress != proxyDirect {
+		if _, err := parseProxyURL(proxyAddress); err != nil {
+			return nil, err
+		}
+	}
+	return []grpc.DialOption{grpc.WithNoProxy(), grpc.WithContextDialer(dialViaProxy)}, nil
+}
+
+func dialViaProxy(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL, err := proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	logger.Debug("dialing through proxy", "proxy", redactProxy(proxyURL), "target", addr)
+
+	if strings.HasPrefix(proxyURL.Scheme, "socks5") {
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, &dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	}
+	return dialHTTPConnect(ctx, proxyURL, addr)
+}
+
+// dialHTTPConnect opens a tunnel to addr with an HTTP CONNECT request.
+func dialHTTPConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("dialing proxy %s: %w", proxyURL.Host, err)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending CONNECT to proxy: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused tunnel to %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		// The server spoke first and its bytes were read along with the
+		// proxy's response.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// redactProxy hides the proxy password in logs.
+func redactProxy(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = url.UserPassword(u.User.Username(), "REDACTED")
+	return redacted.String()
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// testProxy is an in-process HTTP CONNECT or SOCKS5 proxy that tunnels
+// every connection to backend, whatever target the client asks for.
+type testProxy struct {
+	addr    string
+	backend string
+	// user and password are the credentials the proxy requires, none when
+	// user is empty.
+	user     string
+	password string
+	// eager has the HTTP proxy send the backend's first bytes in the same
+	// write as its CONNECT response, as a busy proxy may.
+	eager bool
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// startProxy serves a proxy speaking scheme, http or socks5, until the test
+// ends.
+func startProxy(t *testing.T, scheme string, backend string, configure func(*testProxy)) *testProxy {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProxy{addr: lis.Addr().String(), backend: backend}
+	if configure != nil {
+		configure(p)
+	}
+	serve := p.serveHTTP
+	if scheme == "socks5" {
+		serve = p.serveSOCKS
+	}
+	var conns sync.WaitGroup
+	t.Cleanup(func() {
+		lis.Close()
+		conns.Wait()
+	})
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return p
+}
+
+// requested returns the targets clients asked the proxy for.
+func (p *testProxy) requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *testProxy) serveHTTP(conn net.Conn) {
+	client := bufio.NewReader(conn)
+	req, err := http.ReadRequest(client)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(p.user + ":" + p.password))
+	if p.user != "" && req.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	p.record(req.Host)
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	defer backend.Close()
+
+	response := []byte("HTTP/1.1 200 Connection established\r\n\r\n")
+	if p.eager {
+		// An HTTP/2 server sends its settings without waiting for the
+		// client, so there is something to read straight away.
+		buf := make([]byte, 4096)
+		n, _ := backend.Read(buf)
+		response = append(response, buf[:n]...)
+	}
+	if _, err := conn.Write(response); err != nil {
+		return
+	}
+	pipe(conn, client, backend)
+}
+
+func (p *testProxy) serveSOCKS(conn net.Conn) {
+	client := bufio.NewReader(conn)
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(client, greeting); err != nil || greeting[0] != 5 {
+		return
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(client, methods); err != nil {
+		return
+	}
+	method := byte(0) // no authentication
+	if p.user != "" {
+		method = 2 // username and password
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		conn.Write([]byte{5, 0xff})
+		return
+	}
+	conn.Write([]byte{5, method})
+	if method == 2 {
+		user, password, ok := readSOCKSCredentials(client)
+		if !ok || user != p.user || password != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(client, request); err != nil || request[1] != 1 {
+		return
+	}
+	var host []byte
+	switch request[3] {
+	case 1:
+		host = make([]byte, net.IPv4len)
+	case 4:
+		host = make([]byte, net.IPv6len)
+	case 3:
+		n, err := client.ReadByte()
+		if err != nil {
+			return
+		}
+		host = make([]byte, n)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(client, host); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(client, port); err != nil {
+		return
+	}
+	name := string(host)
+	if request[3] != 3 {
+		name = net.IP(host).String()
+	}
+	p.record(net.JoinHostPort(name, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, client, backend)
+}
+
+func readSOCKSCredentials(r *bufio.Reader) (string, string, bool) {
+	field := func() (string, bool) {
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", false
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err == nil
+	}
+	if version, err := r.ReadByte(); err != nil || version != 1 {
+		return "", "", false
+	}
+	user, ok := field()
+	if !ok {
+		return "", "", false
+	}
+	password, ok := field()
+	return user, password, ok
+}
+
+func (p *testProxy) record(target string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets = append(p.targets, target)
+}
+
+// pipe copies between the client, read through its buffered reader, and
+// the backend until either side is done.
+func pipe(conn net.Conn, client io.Reader, backend net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(backend, client); done <- struct{}{} }()
+	go func() { io.Copy(conn, backend); done <- struct{}{} }()
+	<-done
+	conn.Close()
+	backend.Close()
+	<-done
+}
+
+// useProxy sets -proxy for the rest of the test.
+func useProxy(t *testing.T, address string) {
+	t.Helper()
+	saved := proxyAddress
+	proxyAddress = address
+	t.Cleanup(func() { proxyAddress = saved })
+}
+
+// clearProxyEnv unsets the proxy environment variables for the rest of the
+// test.
+func clearProxyEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy", "REQUEST_METHOD"} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestProxyFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		flag  string
+		env   map[string]string
+		addr  string
+		want  string
+		isErr bool
+	}{
+		{"nothing set", "", nil, "chat.test:3000", "", false},
+		{"flag", "socks5://proxy.test", nil, "chat.test:3000", "socks5://proxy.test:1080", false},
+		{"flag without a scheme", "proxy.test:3128", nil, "chat.test:3000", "http://proxy.test:3128", false},
+		{"flag with a bad scheme", "ftp://proxy.test", nil, "chat.test:3000", "", true},
+		{"direct ignores the environment", "direct", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128"}, "chat.test:3000", "", false},
+		{"flag overrides the environment", "http://other.test:8080", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128"}, "chat.test:3000", "http://other.test:8080", false},
+		{"HTTPS_PROXY", "", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128"}, "chat.test:3000", "http://proxy.test:3128", false},
+		{"ALL_PROXY", "", map[string]string{"ALL_PROXY": "socks5://proxy.test:1080"}, "chat.test:3000", "socks5://proxy.test:1080", false},
+		{"ALL_PROXY without a port", "", map[string]string{"ALL_PROXY": "socks5://proxy.test"}, "chat.test:3000", "socks5://proxy.test:1080", false},
+		{"HTTPS_PROXY without a port", "", map[string]string{"HTTPS_PROXY": "http://proxy.test"}, "chat.test:3000", "http://proxy.test:80", false},
+		{"HTTPS_PROXY without a scheme or port", "", map[string]string{"HTTPS_PROXY": "proxy.test"}, "chat.test:3000", "http://proxy.test:80", false},
+		{"HTTPS_PROXY over TLS without a port", "", map[string]string{"HTTPS_PROXY": "https://user:pw@proxy.test"}, "chat.test:3000", "https://user:pw@proxy.test:443", false},
+		{"HTTPS_PROXY before ALL_PROXY", "", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128", "ALL_PROXY": "socks5://proxy.test:1080"}, "chat.test:3000", "http://proxy.test:3128", false},
+		{"HTTP_PROXY is not for gRPC", "", map[string]string{"HTTP_PROXY": "http://proxy.test:3128"}, "chat.test:3000", "", false},
+		{"NO_PROXY host", "", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128", "NO_PROXY": "chat.test"}, "chat.test:3000", "", false},
+		{"NO_PROXY domain", "", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128", "NO_PROXY": "other.test,.test"}, "chat.test:3000", "", false},
+		{"NO_PROXY other host", "", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128", "NO_PROXY": "other.test"}, "chat.test:3000", "http://proxy.test:3128", false},
+		{"NO_PROXY applies to ALL_PROXY", "", map[string]string{"ALL_PROXY": "socks5://proxy.test:1080", "NO_PROXY": "*"}, "chat.test:3000", "", false},
+		{"NO_PROXY does not apply to the flag", "http://proxy.test:3128", map[string]string{"NO_PROXY": "chat.test"}, "chat.test:3000", "http://proxy.test:3128", false},
+		{"loopback is never proxied", "", map[string]string{"HTTPS_PROXY": "http://proxy.test:3128"}, "127.0.0.1:3000", "", false},
+		{"bad environment proxy", "", map[string]string{"HTTPS_PROXY": "gopher://proxy.test"}, "chat.test:3000", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			useProxy(t, tt.flag)
+
+			got, err := proxyFor(tt.addr)
+			if (err != nil) != tt.isErr {
+				t.Fatalf("proxyFor(%q) error = %v, want an error: %v", tt.addr, err, tt.isErr)
+			}
+			gotURL := ""
+			if got != nil {
+				gotURL = got.String()
+			}
+			if gotURL != tt.want {
+				t.Errorf("proxyFor(%q) = %q, want %q", tt.addr, gotURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectThroughProxy(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		user   string
+		eager  bool
+	}{
+		{"http", "http", "", false},
+		{"http with auth", "http", "alice", false},
+		{"http sending server bytes early", "http", "", true},
+		{"socks5", "socks5", "", false},
+		{"socks5 with auth", "socks5", "alice", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			srv := startFakeServer(t)
+			srv.addAccount(testEmail, "pass-word1")
+			p := startProxy(t, tt.scheme, srv.addr, func(p *testProxy) {
+				p.user, p.password, p.eager = tt.user, "s3cret", tt.eager
+			})
+			proxyURL := tt.scheme + "://" + p.addr
+			if tt.user != "" {
+				proxyURL = tt.scheme + "://" + tt.user + ":s3cret@" + p.addr
+			}
+			useProxy(t, proxyURL)
+
+			connectTo(t, "chat.test:3000")
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"}); err != nil {
+				t.Fatalf("SignIn through the proxy: %v", err)
+			}
+			if got := p.requested(); len(got) == 0 || got[0] != "chat.test:3000" {
+				t.Errorf("the proxy was asked for %q, want chat.test:3000", got)
+			}
+		})
+	}
+}
+
+func TestConnectThroughProxyFromEnvironment(t *testing.T) {
+	clearProxyEnv(t)
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "pass-word1")
+	p := startProxy(t, "http", srv.addr, nil)
+	t.Setenv("HTTPS_PROXY", "http://"+p.addr)
+	t.Setenv("NO_PROXY", "other.test")
+	useProxy(t, "")
+
+	connectTo(t, "chat.test:3000")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"}); err != nil {
+		t.Fatalf("SignIn through the proxy: %v", err)
+	}
+	if got := p.requested(); len(got) == 0 {
+		t.Error("the connection did not go through HTTPS_PROXY")
+	}
+}
+
+func TestUnixTargetSkipsProxy(t *testing.T) {
+	clearProxyEnv(t)
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "pass-word1")
+	// Socket paths are limited to about a hundred bytes, which a test's
+	// own temporary directory can exceed.
+	dir, err := os.MkdirTemp("", "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "chat.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.server.Serve(lis)
+
+	// Nothing listens on either proxy, so going through one fails.
+	t.Setenv("HTTPS_PROXY", "http://127.0.0.1:1")
+	t.Setenv("ALL_PROXY", "socks5://127.0.0.1:1")
+	for _, flag := range []string{"", "http://127.0.0.1:1"} {
+		t.Run("proxy "+flag, func(t *testing.T) {
+			useProxy(t, flag)
+			connectTo(t, "unix://"+socket)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"}); err != nil {
+				t.Errorf("SignIn over unix://%s: %v", socket, err)
+			}
+		})
+	}
+}
+
+func TestProxyRejectsCredentials(t *testing.T) {
+	tests := []struct {
+		scheme   string
+		password string
+		want     string
+	}{
+		{"http", "", "407 Proxy Authentication Required"},
+		{"http", "wrong", "407 Proxy Authentication Required"},
+		{"socks5", "", "no acceptable authentication methods"},
+		{"socks5", "wrong", "username/password authentication failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+"/"+tt.password, func(t *testing.T) {
+			clearProxyEnv(t)
+			p := startProxy(t, tt.scheme, "127.0.0.1:1", func(p *testProxy) {
+				p.user, p.password = "alice", "s3cret"
+			})
+			proxyURL := tt.scheme + "://" + p.addr
+			if tt.password != "" {
+				proxyURL = tt.scheme + "://alice:" + tt.password + "@" + p.addr
+			}
+			useProxy(t, proxyURL)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := dialViaProxy(ctx, "chat.test:3000")
+			if err == nil {
+				conn.Close()
+				t.Fatal("the proxy let the connection through")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("dialViaProxy = %v, want an error about %q", err, tt.want)
+			}
+			if got := p.requested(); len(got) != 0 {
+				t.Errorf("the proxy tunnelled to %q without the right credentials", got)
+			}
+		})
+	}
+}
+
+func TestBufferedConnKeepsEarlyBytes(t *testing.T) {
+	clearProxyEnv(t)
+	srv := startFakeServer(t)
+	p := startProxy(t, "http", srv.addr, func(p *testProxy) { p.eager = true })
+	useProxy(t, "http://"+p.addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialViaProxy(ctx, "chat.test:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*bufferedConn); !ok {
+		t.Fatalf("dialViaProxy returned a %T, want a *bufferedConn holding the server's first bytes", conn)
+	}
+	// The server's HTTP/2 settings frame must come first, not be lost in
+	// the buffer used to read the proxy's response.
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	if frameType := header[3]; frameType != 4 {
+		t.Errorf("the first frame has type %d, want a settings frame", frameType)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// remote holds the connection to the server, the clients on it and the chat
+// stream. Recovery replaces them from the heartbeat or receive goroutine
+// while commands are using them, so they are only read and swapped under
+// its lock.
+var remote = struct {
+	sync.RWMutex
+	connection   *grpc.ClientConn
+	chat         pkg.ChatroomClient
+	auth         pkg.AuthClient
+	stream       pkg.Chatroom_ConverseClient
+	streamCancel context.CancelFunc
+}{streamCancel: func() {}}
+
+func connection() *grpc.ClientConn {
+	remote.RLock()
+	defer remote.RUnlock()
+	return remote.connection
+}
+
+func chatClient() pkg.ChatroomClient {
+	remote.RLock()
+	defer remote.RUnlock()
+	return remote.chat
+}
+
+func authClient() pkg.AuthClient {
+	remote.RLock()
+	defer remote.RUnlock()
+	return remote.auth
+}
+
+func currentStream() pkg.Chatroom_ConverseClient {
+	remote.RLock()
+	defer remote.RUnlock()
+	return remote.stream
+}
+
+// setConnection makes conn the connection every later call goes through.
+func setConnection(conn *grpc.ClientConn) {
+	remote.Lock()
+	defer remote.Unlock()
+	remote.connection = conn
+	remote.chat = pkg.NewChatroomClient(conn)
+	remote.auth = pkg.NewAuthClient(conn)
+}
+
+// swapStream makes s the chat stream, cancelled with cancel, and returns
+// the cancel function of the stream it replaces.
+func swapStream(s pkg.Chatroom_ConverseClient, cancel context.CancelFunc) context.CancelFunc {
+	remote.Lock()
+	defer remote.Unlock()
+	old := remote.streamCancel
+	remote.stream, remote.streamCancel = s, cancel
+	return old
+}
+
+// cancelStream cancels the current chat stream.
+func cancelStream() {
+	remote.RLock()
+	cancel := remote.streamCancel
+	remote.RUnlock()
+	cancel()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// retryableMethods maps the RPCs that are safe to send again to their
+// service. Anything missing here, SignUp above all, is never retried since a
+// repeated call could create a second account.
+var retryableMethods = map[string]string{
+	"SignIn":             "pkg.Auth",
+	"SearchAccounts":     "pkg.Auth",
+	"GetAccount":         "pkg.Auth",
+	"CreateConversation": "pkg.Chatroom",
+}
+
+// retryPolicy is the part of the service config retry policy that can be
+// tuned from the command line.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	codes          []string
+	// perMethod overrides maxAttempts for single methods; 1 disables retries.
+	perMethod map[string]int
+}
+
+var retries = retryPolicy{
+	maxAttempts:    4,
+	initialBackoff: 200 * time.Millisecond,
+	maxBackoff:     2 * time.Second,
+	codes:          []string{"UNAVAILABLE"},
+	perMethod:      map[string]int{},
+}
+
+// retryAttempts implements flag.Value for -retries, a comma separated list of
+// Method=attempts pairs.
+type retryAttempts struct{ policy *retryPolicy }
+
+func (r retryAttempts) String() string {
+	if r.policy == nil {
+		return ""
+	}
+	var parts []string
+	for method, n := range r.policy.perMethod {
+		parts = append(parts, method+"="+strconv.Itoa(n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (r retryAttempts) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		method, attempts, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected Method=attempts, got %q", part)
+		}
+		if _, ok := retryableMethods[method]; !ok {
+			return fmt.Errorf("%s is not safe to retry", method)
+		}
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid attempts %q for %s", attempts, method)
+		}
+		r.policy.perMethod[method] = n
+	}
+	return nil
+}
+
+// retryCodes implements flag.Value for -retry-codes.
+type retryCodes struct{ policy *retryPolicy }
+
+func (r retryCodes) String() string {
+	if r.policy == nil {
+		return ""
+	}
+	return strings.Join(r.policy.codes, ",")
+}
+
+// Set takes status code names as grpc spells them, e.g. UNAVAILABLE. They
+// are checked here since the service config they end up in is only parsed
+// when dialing, where a bad name would fail the connection instead.
+func (r retryCodes) Set(value string) error {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToUpper(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return fmt.Errorf("unknown status code %s", name)
+		}
+		if code == codes.OK {
+			return errors.New("OK is not an error and cannot be retried")
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return errors.New("give at least one status code; use -retry-attempts 1 to disable retries")
+	}
+	r.policy.codes = names
+	return nil
+}
+
+type methodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+type methodRetryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+type methodConfig struct {
+	Name        []methodName       `json:"name"`
+	RetryPolicy *methodRetryPolicy `json:"retryPolicy,omitempty"`
+}
+
+// methodConfigs renders the policy as service config entries, one per
+// retryable method.
+func (p *retryPolicy) methodConfigs() []methodConfig {
+	var configs []methodConfig
+	for _, method := range sortedKeys(retryableMethods) {
+		attempts := p.maxAttempts
+		if n, ok := p.perMethod[method]; ok {
+			attempts = n
+		}
+		if attempts < 2 {
+			continue
+		}
+		configs = append(configs, methodConfig{
+			Name: []methodName{{Service: retryableMethods[method], Method: method}},
+			RetryPolicy: &methodRetryPolicy{
+				MaxAttempts:          attempts,
+				InitialBackoff:       durationJSON(p.initialBackoff),
+				MaxBackoff:           durationJSON(p.maxBackoff),
+				BackoffMultiplier:    2,
+				RetryableStatusCodes: p.codes,
+			},
+		})
+	}
+	return configs
+}
+
+// durationJSON formats d the way service configs expect, in seconds with an
+// "s" suffix.
+func durationJSON(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
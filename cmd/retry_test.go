@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+func TestRetryCodesSet(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+		err   string
+	}{
+		{"UNAVAILABLE", []string{"UNAVAILABLE"}, ""},
+		{" unavailable , Resource_Exhausted,", []string{"UNAVAILABLE", "RESOURCE_EXHAUSTED"}, ""},
+		{"UNAVAILABLE,UNAVIALABLE", nil, "unknown status code UNAVIALABLE"},
+		{"14", nil, "unknown status code 14"},
+		{"OK", nil, "OK is not an error"},
+		{"", nil, "at least one status code"},
+		{" , ", nil, "at least one status code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			policy := retryPolicy{codes: []string{"UNAVAILABLE"}}
+			err := retryCodes{&policy}.Set(tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Set(%q) = %v, want an error about %q", tt.value, err, tt.err)
+				}
+				if len(policy.codes) != 1 || policy.codes[0] != "UNAVAILABLE" {
+					t.Errorf("a refused Set changed the codes to %q", policy.codes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set(%q): %v", tt.value, err)
+			}
+			if strings.Join(policy.codes, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Set(%q) gave %q, want %q", tt.value, policy.codes, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAttemptsSet(t *testing.T) {
+	tests := []struct {
+		value string
+		err   bool
+	}{
+		{"SearchAccounts=5,SignIn=1", false},
+		{"SignUp=3", true},
+		{"SignIn", true},
+		{"SignIn=0", true},
+		{"SignIn=many", true},
+	}
+	for _, tt := range tests {
+		policy := retryPolicy{perMethod: map[string]int{}}
+		if err := (retryAttempts{&policy}).Set(tt.value); (err != nil) != tt.err {
+			t.Errorf("Set(%q) = %v, want an error: %v", tt.value, err, tt.err)
+		}
+	}
+}
+
+// useRetries replaces the retry policy for the rest of the test, with
+// backoffs short enough for tests.
+func useRetries(t *testing.T, policy retryPolicy) {
+	t.Helper()
+	saved := retries
+	policy.initialBackoff, policy.maxBackoff = time.Millisecond, 10*time.Millisecond
+	if policy.perMethod == nil {
+		policy.perMethod = map[string]int{}
+	}
+	retries = policy
+	t.Cleanup(func() { retries = saved })
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    retryPolicy
+		method    string
+		failures  int
+		code      codes.Code
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{"retried until it works", retryPolicy{maxAttempts: 4, codes: []string{"UNAVAILABLE"}}, "SignIn", 3, codes.Unavailable, 4, codes.OK},
+		{"gives up after the attempts", retryPolicy{maxAttempts: 4, codes: []string{"UNAVAILABLE"}}, "SignIn", 4, codes.Unavailable, 4, codes.Unavailable},
+		{"other codes are not retried", retryPolicy{maxAttempts: 4, codes: []string{"UNAVAILABLE"}}, "SignIn", 1, codes.Internal, 1, codes.Internal},
+		{"chosen codes are retried", retryPolicy{maxAttempts: 4, codes: []string{"UNAVAILABLE", "RESOURCE_EXHAUSTED"}}, "SignIn", 2, codes.ResourceExhausted, 3, codes.OK},
+		{"per method attempts", retryPolicy{maxAttempts: 4, codes: []string{"UNAVAILABLE"}, perMethod: map[string]int{"SignIn": 2}}, "SignIn", 3, codes.Unavailable, 2, codes.Unavailable},
+		{"retries disabled", retryPolicy{maxAttempts: 1, codes: []string{"UNAVAILABLE"}}, "SignIn", 1, codes.Unavailable, 1, codes.Unavailable},
+		{"sign up is never retried", retryPolicy{maxAttempts: 4, codes: []string{"UNAVAILABLE"}}, "SignUp", 1, codes.Unavailable, 1, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRetries(t, tt.policy)
+			srv := startFakeServer(t)
+			srv.addAccount(testEmail, "pass-word1")
+			connectTo(t, srv.addr)
+			srv.failFirst(tt.method, tt.failures, tt.code)
+
+			var err error
+			switch tt.method {
+			case "SignIn":
+				_, err = authClient().SignIn(context.Background(), &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"})
+			case "SignUp":
+				_, err = authClient().SignUp(context.Background(), &pkg.SignUpRequest{Email: "bob@example.com", Password: "pass-word1"})
+			}
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("%s returned %v, want %s", tt.method, err, tt.wantCode)
+			}
+			if got := srv.callCount(tt.method); got != tt.wantCalls {
+				t.Errorf("%s reached the server %d times, want %d", tt.method, got, tt.wantCalls)
+			}
+		})
+	}
+}
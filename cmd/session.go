@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// sessionStopTimeout bounds how long logout waits for the session's
+// goroutines to notice it has ended.
+const sessionStopTimeout = 5 * time.Second
+
+// notifyLogout has logout send a logout event on the chat stream before
+// closing it, for servers that understand one.
+var notifyLogout bool
+
+// runLogin signs the user in, opens the chat stream and starts the
+// goroutines serving the session until logout.
+func runLogin(c *ishell.Context) {
+	cred, ok := askCredentials(c)
+	if !ok {
+		return
+	}
+	if err := authenticate(commandContext(), cred.email, cred.password); err != nil {
+		rejectCredentials(c, cred, err)
+		return
+	}
+	approveCredentials(c, cred)
+
+	if err := startStream(); err != nil {
+		c.Println("[ERROR] Unable to open chat stream")
+		return
+	}
+
+	if err := login(commandContext()); err != nil {
+		printRPCError(c, err, "Unable to login to chat")
+		return
+	}
+
+	if err := setState(stateStreaming); err != nil {
+		c.Println("[ERROR] Unable to login to chat:", err)
+		endSession()
+		return
+	}
+	sessionCtx, sessionCancel = context.WithCancel(ctx)
+	userCtx := sessionCtx
+	goSession(func() { receive(c, selectedAccount) })
+	goSession(func() { heartbeat(userCtx, c) })
+}
+
+// runLogout ends the session and redials, so the next login starts on a
+// fresh connection.
+func runLogout(c *ishell.Context) {
+	endSession()
+	c.Println("Logged out")
+
+	if conn := connection(); conn != nil {
+		conn.Close()
+	}
+	if err := connect(serverAddress); err != nil {
+		endState(false)
+		c.Println("[ERROR] Unable to connect to", serverAddress, "- run doctor for details")
+	}
+}
+
+// endSession tears down everything belonging to the logged in user: it stops
+// the heartbeat and recovery, closes the chat stream, waits for the
+// goroutines reading it to return and forgets the user's state, leaving the
+// connection ready for another login.
+func endSession() {
+	sessionCancel()
+	if currentStream() != nil {
+		if notifyLogout && me().GetClientId() != "" {
+			err := sendEvent(ctx, &pkg.ChatEvent{Command: &pkg.ChatEvent_Logout{Logout: me()}})
+			if err != nil {
+				logger.Error("failed to send logout event", "err", err)
+			}
+		}
+		if err := closeSend(); err != nil {
+			logger.Error("failed to close stream", "err", err)
+		}
+	}
+	cancelStream()
+
+	done := make(chan struct{})
+	go func() {
+		sessionGoroutines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(sessionStopTimeout):
+		logger.Warn("session goroutines still running after logout", "waited", sessionStopTimeout)
+	}
+
+	if me().GetClientId() != "" {
+		logger.Info("logged out", "client_id", me().GetClientId())
+	}
+	swapStream(nil, func() {})
+	sessionCtx, sessionCancel = context.Background(), func() {}
+	self.Store(&pkg.Client{})
+	account = nil
+	setSelectedAccount(&pkg.Account{})
+	conversation = pkg.Conversation{}
+	history.reset()
+	partial.reset()
+	topics.Clear()
+	mutedConversations.Clear()
+	notifyRules.Clear()
+	notifications.reset()
+	mentions.reset()
+	setDegraded(false)
+	vault.lock()
+	endState(connection() != nil)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+func TestLogoutThenLoginAsAnotherUser(t *testing.T) {
+	srv := startFakeServer(t)
+	loginTo(t, srv, srv.addr, "alice@example.com")
+	conversation = pkg.Conversation{Id: "c1"}
+	history.add("c1", &pkg.ConversationMessage{Id: "m1", Content: "hi"})
+
+	if out := runCommand(t, runLogout); !strings.Contains(out, "Logged out") {
+		t.Fatalf("logout printed %q", out)
+	}
+	if got := currentState(); got != stateConnected {
+		t.Errorf("the session is %s after logout, want connected", got)
+	}
+	if me().GetClientId() != "" || account != nil || conversation.GetId() != "" || len(history.last("c1", 10)) != 0 {
+		t.Errorf("logout kept the user's state: me %q, account %v, conversation %q", me().GetClientId(), account, conversation.GetId())
+	}
+
+	loginAs(t, srv, "bob@example.com")
+	if got := me().GetClientId(); got != "bob@example.com" {
+		t.Errorf("logged in as %q, want bob@example.com", got)
+	}
+	if got, want := srv.loggedIn(), []string{"alice@example.com", "bob@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("the server saw logins %q, want %q", got, want)
+	}
+}
+
+func TestLogoutNotifiesServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		notify bool
+		want   []string
+	}{
+		{"notify", true, []string{testEmail}},
+		{"silent", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notify := notifyLogout
+			notifyLogout = tt.notify
+			t.Cleanup(func() { notifyLogout = notify })
+			srv := startFakeServer(t)
+			loginTo(t, srv, srv.addr, testEmail)
+
+			runCommand(t, runLogout)
+			// Logging in again gives the server time to read the first
+			// stream to its end.
+			loginAs(t, srv, testEmail)
+			eventually(t, "the server has seen the logouts", func() bool {
+				return len(srv.loggedOut()) >= len(tt.want)
+			})
+			if got := srv.loggedOut(); !slices.Equal(got, tt.want) {
+				t.Errorf("the server saw logouts %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogoutBeforeLogin(t *testing.T) {
+	srv := startFakeServer(t)
+	serverAddress = srv.addr
+	t.Cleanup(func() { serverAddress = "" })
+	resetSession(t)
+	selectedAccount = &pkg.Account{}
+	connectTo(t, srv.addr)
+	if err := setState(stateConnected); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := runCommand(t, runLogout); !strings.Contains(out, "Logged out") {
+		t.Fatalf("logout printed %q", out)
+	}
+	if got := currentState(); got != stateConnected {
+		t.Errorf("the session is %s after logout, want connected", got)
+	}
+}
+
+func TestStartStreamCancelsPreviousStream(t *testing.T) {
+	srv := startFakeServer(t)
+	connectTo(t, srv.addr)
+	t.Cleanup(func() {
+		swapStream(nil, func() {})()
+	})
+
+	if err := startStream(); err != nil {
+		t.Fatal(err)
+	}
+	first := currentStream()
+	if err := startStream(); err != nil {
+		t.Fatal(err)
+	}
+	if first.Context().Err() == nil {
+		t.Error("the replaced stream is still open")
+	}
+	if currentStream().Context().Err() != nil {
+		t.Error("the new stream was cancelled")
+	}
+}
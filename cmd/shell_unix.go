@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// shellCommand runs command through sh with args as its positional
+// parameters.
+func shellCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", command + ` "$@"`, "chit-chat-go"}, args...)...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shellCommand runs command through cmd.exe with args appended. The command
+// line is handed over as written: cmd.exe does not follow the quoting rules
+// exec applies to the arguments of other programs.
+func shellCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
+	shell := os.Getenv("ComSpec")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	line := strings.Join(append([]string{command}, args...), " ")
+	cmd := exec.CommandContext(ctx, shell)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `"` + shell + `" /d /s /c "` + line + `"`}
+	cmd.Stderr = os.Stderr
+	return cmd
+}
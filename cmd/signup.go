@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+
+	"github.com/abiosoft/ishell/v2"
+
+	"github.com/Madslick/chit-chat-go-client/pkg/rpcerr"
+)
+
+const minPasswordLength = 8
+
+// signupField is one answer the signup command asks for. Names match the
+// SignUpRequest fields the server reports violations against.
+type signupField struct {
+	name     string
+	label    string
+	validate func(string) (string, error)
+}
+
+var signupFields = []signupField{
+	{"email", "Email: ", validateEmail},
+	{"password", "Password: ", nil},
+	{"first_name", "First Name: ", required("first name")},
+	{"last_name", "Last Name: ", required("last name")},
+	{"phone_number", "Phone Number (+country code, optional): ", normalizePhone},
+}
+
+func validateEmail(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Address != raw || !strings.Contains(raw[strings.LastIndex(raw, "@"):], ".") {
+		return "", errors.New("enter an email address like name@example.com")
+	}
+	return raw, nil
+}
+
+func required(what string) func(string) (string, error) {
+	return func(raw string) (string, error) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return "", fmt.Errorf("%s is required", what)
+		}
+		return raw, nil
+	}
+}
+
+// normalizePhone turns a phone number into E.164 form, "+" followed by the
+// country code and number, dropping the usual separators. An empty number is
+// allowed since the phone is optional.
+func normalizePhone(raw string) (string, error) {
+	phone := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -().", r) {
+			return -1
+		}
+		return r
+	}, raw)
+	if phone == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(phone, "00") {
+		phone = "+" + phone[2:]
+	}
+	if !strings.HasPrefix(phone, "+") {
+		return "", errors.New("start with + and the country code, e.g. +14155552671")
+	}
+	digits := phone[1:]
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return "", errors.New("enter 8 to 15 digits after the country code")
+	}
+	return phone, nil
+}
+
+func checkPasswordStrength(password string, email string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("use at least %d characters", minPasswordLength)
+	}
+	if strings.IndexFunc(password, unicode.IsLetter) < 0 || strings.IndexFunc(password, unicode.IsDigit) < 0 {
+		return errors.New("use both letters and digits")
+	}
+	if email != "" && strings.EqualFold(password, email) {
+		return errors.New("do not use your email as your password")
+	}
+	return nil
+}
+
+// promptField asks for a value until validate accepts it, showing what is
+// wrong after each rejected answer.
+func promptField(c *ishell.Context, label string, validate func(string) (string, error)) (string, bool) {
+	for {
+		value, ok := prompt(c, label)
+		if !ok {
+			return "", false
+		}
+		if validate == nil {
+			return value, true
+		}
+		value, err := validate(value)
+		if err == nil {
+			return value, true
+		}
+		c.Println("  " + err.Error())
+	}
+}
+
+// promptNewPassword asks for a password strong enough for the account and
+// has it typed a second time.
+func promptNewPassword(c *ishell.Context, label string, email string) (string, bool) {
+	for {
+		password, ok := promptPassword(c, label)
+		if !ok {
+			return "", false
+		}
+		if err := checkPasswordStrength(password, email); err != nil {
+			c.Println("  " + err.Error())
+			continue
+		}
+		confirmation, ok := promptPassword(c, "Confirm Password: ")
+		if !ok {
+			return "", false
+		}
+		if confirmation != password {
+			c.Println("  passwords do not match")
+			continue
+		}
+		return password, true
+	}
+}
+
+// signupFieldNamed matches a field named by the server, which may spell it
+// in snake or camel case.
+func signupFieldNamed(name string) (signupField, bool) {
+	name = strings.ToLower(strings.ReplaceAll(name, "_", ""))
+	for _, field := range signupFields {
+		if strings.ReplaceAll(field.name, "_", "") == name {
+			return field, true
+		}
+	}
+	return signupField{}, false
+}
+
+// runSignup walks through the signup form. When the server rejects some of
+// the answers only those fields are asked for again.
+func runSignup(c *ishell.Context) {
+	values := map[string]string{}
+	todo := signupFields
+	for {
+		for _, field := range todo {
+			var value string
+			var ok bool
+			if field.name == "password" {
+				value, ok = promptNewPassword(c, field.label, values["email"])
+			} else {
+				value, ok = promptField(c, field.label, field.validate)
+			}
+			if !ok {
+				return
+			}
+			values[field.name] = value
+		}
+
+		err := signup(commandContext(), values["email"], values["password"], values["first_name"], values["last_name"], values["phone_number"])
+		if err == nil {
+			return
+		}
+
+		todo = nil
+		switch e := rpcerr.From(err); e.Kind {
+		case rpcerr.Conflict:
+			c.Println("[ERROR] An account with email", values["email"], "already exists; login instead or use another email")
+			field, _ := signupFieldNamed("email")
+			todo = append(todo, field)
+		case rpcerr.Invalid:
+			for _, violation := range e.Violations {
+				c.Printf("[ERROR] %s: %s\n", violation.Field, violation.Description)
+				if field, ok := signupFieldNamed(violation.Field); ok {
+					todo = append(todo, field)
+				}
+			}
+			if len(todo) == 0 {
+				c.Println("[ERROR] The server rejected the signup:", e.Message)
+				return
+			}
+		default:
+			printRPCError(c, err, "Unable to signup user with email "+values["email"])
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"alice@example.com", "alice@example.com", true},
+		{"  alice@example.com\t", "alice@example.com", true},
+		{"alice+chat@example.com", "alice+chat@example.com", true},
+		{"alice.smith@mail.example.co.uk", "alice.smith@mail.example.co.uk", true},
+		{"josé@exämple.de", "josé@exämple.de", true},
+		{"用户@例子.广告", "用户@例子.广告", true},
+		{"alice@xn--exmple-cua.de", "alice@xn--exmple-cua.de", true},
+		{"", "", false},
+		{"alice", "", false},
+		{"alice@localhost", "", false},
+		{"alice@@example.com", "", false},
+		{"alice.@example.com", "", false},
+		{"@example.com", "", false},
+		{"Alice <alice@example.com>", "", false},
+		{"alice@example.com, bob@example.com", "", false},
+	}
+	for _, tt := range tests {
+		got, err := validateEmail(tt.raw)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("validateEmail(%q) = %q, %v; want %q, ok %t", tt.raw, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  string
+	}{
+		{"", "", ""},
+		{"  ", "", ""},
+		{"+14155552671", "+14155552671", ""},
+		{"+1 (415) 555-2671", "+14155552671", ""},
+		{"+44.20.7946.0958", "+442079460958", ""},
+		{"0044 20 7946 0958", "+442079460958", ""},
+		{"+353 1 234 5678", "+35312345678", ""},
+		{"+86 139 1234 5678", "+8613912345678", ""},
+		{"4155552671", "", "start with + and the country code"},
+		{"(415) 555-2671", "", "start with + and the country code"},
+		{"+1234567", "", "enter 8 to 15 digits"},
+		{"+1234567890123456", "", "enter 8 to 15 digits"},
+		{"+0 415 555 2671", "", "enter 8 to 15 digits"},
+		{"+1 415 555 267x", "", "enter 8 to 15 digits"},
+		{"++14155552671", "", "enter 8 to 15 digits"},
+	}
+	for _, tt := range tests {
+		got, err := normalizePhone(tt.raw)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) || got != tt.want {
+			t.Errorf("normalizePhone(%q) = %q, %v; want %q, %q", tt.raw, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		email    string
+		err      string
+	}{
+		{"pass-word1", testEmail, ""},
+		{"pässwörd1", testEmail, ""},
+		{"12345abc", "", ""},
+		{"short1", testEmail, "use at least 8 characters"},
+		{"", testEmail, "use at least 8 characters"},
+		{"abcdefgh", testEmail, "use both letters and digits"},
+		{"12345678", testEmail, "use both letters and digits"},
+		{"--------", testEmail, "use both letters and digits"},
+		{"alice1@example.com", "alice1@example.com", "do not use your email"},
+		{"ALICE1@example.com", "alice1@example.com", "do not use your email"},
+		{"alice1@example.com", "", ""},
+	}
+	for _, tt := range tests {
+		err := checkPasswordStrength(tt.password, tt.email)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("checkPasswordStrength(%q, %q) = %v, want %q", tt.password, tt.email, err, tt.err)
+		}
+	}
+}
+
+func TestSignupRepromptsRejectedField(t *testing.T) {
+	const newEmail = "alice2@example.com"
+	tests := []struct {
+		name  string
+		input []string
+		// asked is how often each prompt is shown; the others once.
+		asked map[string]int
+		error string
+		email string
+		phone string
+	}{
+		{
+			name: "taken email",
+			input: []string{testEmail, "pass-word1", "pass-word1", "Alice", "Smith", "+1 415 555 2671",
+				newEmail},
+			asked: map[string]int{"Email: ": 2},
+			error: "[ERROR] An account with email " + testEmail + " already exists",
+			email: newEmail,
+			phone: "+14155552671",
+		},
+		{
+			name: "refused phone",
+			input: []string{newEmail, "pass-word1", "pass-word1", "Alice", "Smith", unreachablePhone,
+				"+1 415 555 2671"},
+			asked: map[string]int{"Phone Number (+country code, optional): ": 2},
+			error: "[ERROR] phoneNumber: is not in service",
+			email: newEmail,
+			phone: "+14155552671",
+		},
+		{
+			name: "refused password",
+			input: []string{newEmail, breachedPassword, breachedPassword, "Alice", "Smith", "",
+				"pass-word1", "pass-word1"},
+			asked: map[string]int{"Password: ": 2, "Confirm Password: ": 2},
+			error: "[ERROR] password: appears in a known data breach",
+			email: newEmail,
+		},
+		{
+			name:  "invalid answers asked again at once",
+			input: []string{"alice", newEmail, "short1", "pass-word1", "pass-word1", " ", "Alice", "Smith", "415", ""},
+			asked: map[string]int{"Email: ": 2, "Password: ": 2, "First Name: ": 2, "Phone Number (+country code, optional): ": 2},
+			email: newEmail,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := startFakeServer(t)
+			srv.addAccount(testEmail, "pass-word1")
+			connectTo(t, srv.addr)
+
+			out := runCommand(t, runSignup, tt.input...)
+			for _, label := range []string{"Email: ", "Password: ", "Confirm Password: ", "First Name: ", "Last Name: ", "Phone Number (+country code, optional): "} {
+				want := tt.asked[label]
+				if want == 0 {
+					want = 1
+				}
+				got := strings.Count(out, label)
+				if label == "Password: " {
+					got -= strings.Count(out, "Confirm Password: ")
+				}
+				if got != want {
+					t.Errorf("asked %q %d times, want %d: %q", label, got, want, out)
+				}
+			}
+			if tt.error != "" && !strings.Contains(out, tt.error) {
+				t.Errorf("signup printed %q, want %q", out, tt.error)
+			}
+
+			signups := srv.signedUp()
+			if len(signups) == 0 {
+				t.Fatal("the server got no signup")
+			}
+			last := signups[len(signups)-1]
+			if last.GetEmail() != tt.email || last.GetPassword() != "pass-word1" || last.GetFirstName() != "Alice" ||
+				last.GetLastName() != "Smith" || last.GetPhoneNumber() != tt.phone {
+				t.Errorf("the last signup was %v", last)
+			}
+			if got := srv.password(tt.email); got != "pass-word1" {
+				t.Errorf("the account for %s has password %q, want it created", tt.email, got)
+			}
+		})
+	}
+}
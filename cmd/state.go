@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/abiosoft/ishell/v2"
+)
+
+// sessionState is how far the user has got towards chatting. Each state
+// implies the ones before it.
+type sessionState int
+
+const (
+	stateDisconnected sessionState = iota
+	stateConnected
+	stateAuthenticated
+	stateStreaming
+	stateInConversation
+)
+
+var stateNames = [...]string{"disconnected", "connected", "authenticated", "streaming", "in a conversation"}
+
+func (s sessionState) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// transitions lists the states each state may move to. Every state can
+// fall back to disconnected or connected when the session ends.
+var transitions = map[sessionState][]sessionState{
+	stateDisconnected:   {stateConnected},
+	stateConnected:      {stateDisconnected, stateAuthenticated},
+	stateAuthenticated:  {stateDisconnected, stateConnected, stateStreaming},
+	stateStreaming:      {stateDisconnected, stateConnected, stateInConversation},
+	stateInConversation: {stateDisconnected, stateConnected, stateStreaming},
+}
+
+// commandStates lists the states each shell command may run in. Commands
+// not listed, like help and doctor, run in any state.
+var commandStates = map[string][]sessionState{
+	"signup":         {stateConnected},
+	"reset-password": {stateConnected},
+	"login":          {stateConnected, stateAuthenticated},
+	"search":         {stateStreaming},
+	"logout":         {stateAuthenticated, stateStreaming},
+	"passwd":         {stateAuthenticated, stateStreaming},
+	"whoami":         {stateAuthenticated, stateStreaming},
+	"profile":        {stateAuthenticated, stateStreaming},
+}
+
+var errInvalidTransition = errors.New("invalid session transition")
+
+var session = struct {
+	sync.Mutex
+	state sessionState
+	// offline is set while the server cannot be reached. The session then
+	// counts as disconnected, but keeps state to return to once it is back.
+	offline bool
+	// commands holds every top level command, including the ones hidden
+	// in the current state.
+	commands []*ishell.Cmd
+}{state: stateDisconnected}
+
+func currentState() sessionState {
+	session.Lock()
+	defer session.Unlock()
+	return visibleState()
+}
+
+// visibleState is the state commands are checked against. The caller holds
+// the session lock.
+func visibleState() sessionState {
+	if session.offline {
+		return stateDisconnected
+	}
+	return session.state
+}
+
+// setState moves the session to next, showing only the commands that can
+// run there. A move the state machine does not allow is refused and logged.
+func setState(next sessionState) error {
+	session.Lock()
+	defer session.Unlock()
+	if next == session.state {
+		return nil
+	}
+	if !slices.Contains(transitions[session.state], next) {
+		logger.Error("invalid session transition", "from", session.state, "to", next)
+		return fmt.Errorf("%w from %s to %s", errInvalidTransition, session.state, next)
+	}
+	logger.Info("session state changed", "from", session.state, "to", next)
+	session.state = next
+	showCommands()
+	return nil
+}
+
+// endState drops the session back to connected, or to disconnected when
+// there is no connection. Every state may fall back to these, so unlike
+// setState it cannot fail.
+func endState(connected bool) {
+	next := stateDisconnected
+	if connected {
+		next = stateConnected
+	}
+	session.Lock()
+	defer session.Unlock()
+	if next != session.state {
+		logger.Info("session state changed", "from", session.state, "to", next)
+		session.state = next
+		showCommands()
+	}
+}
+
+// setOffline records whether the server can be reached, hiding the commands
+// that need it while it cannot.
+func setOffline(offline bool) {
+	session.Lock()
+	defer session.Unlock()
+	if offline == session.offline {
+		return
+	}
+	logger.Info("server reachability changed", "offline", offline)
+	session.offline = offline
+	showCommands()
+}
+
+// commandAllowed reports whether the command called name can run in state.
+func commandAllowed(name string, state sessionState) bool {
+	allowed, gated := commandStates[name]
+	return !gated || slices.Contains(allowed, state)
+}
+
+// unavailableReason explains why a command that runs in allowed cannot run
+// in state.
+func unavailableReason(state sessionState, allowed []sessionState) string {
+	switch {
+	case state == stateDisconnected:
+		return fmt.Sprintf("not connected to %s - run doctor for details", serverAddress)
+	case state == stateAuthenticated && allowed[0] >= stateStreaming:
+		return "the chat stream is not open, login again"
+	case state < allowed[0]:
+		return "you must login first"
+	case state == stateInConversation:
+		return "leave the conversation first"
+	}
+	return "you are logged in, logout first"
+}
+
+// gateCommands hides the shell's commands that cannot run in the current
+// state and explains why when one of them is typed anyway.
+func gateCommands(shell *ishell.Shell) {
+	session.Lock()
+	defer session.Unlock()
+	session.commands = shell.Cmds()
+	showCommands()
+
+	shell.NotFound(func(c *ishell.Context) {
+		name := c.Args[0]
+		if allowed, gated := commandStates[name]; gated {
+			state := currentState()
+			c.Printf("[ERROR] %s is not available while %s: %s\n", name, state, unavailableReason(state, allowed))
+			return
+		}
+		c.Err(fmt.Errorf("incorrect input, try 'help'"))
+	})
+}
+
+// showCommands registers the commands allowed in the current state and
+// removes the rest, so help and completion only offer what can run.
+func showCommands() {
+	for _, cmd := range session.commands {
+		if commandAllowed(cmd.Name, visibleState()) {
+			shell.AddCmd(cmd)
+		} else {
+			shell.DeleteCmd(cmd.Name)
+		}
+	}
+}
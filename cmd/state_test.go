@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc/connectivity"
+)
+
+var allStates = []sessionState{stateDisconnected, stateConnected, stateAuthenticated, stateStreaming, stateInConversation}
+
+// resetSession starts the test disconnected with no commands registered,
+// and leaves it that way afterwards.
+func resetSession(t *testing.T) {
+	t.Helper()
+	clear := func() {
+		session.Lock()
+		defer session.Unlock()
+		session.state, session.offline, session.commands = stateDisconnected, false, nil
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+// gatedShell sets up the global shell with a command for every name given,
+// gated by state. It returns the shell's output and the commands that ran.
+func gatedShell(t *testing.T, names []string) (*syncBuffer, *[]string) {
+	t.Helper()
+	out := &syncBuffer{}
+	shell = newTestShell(t, io.NopCloser(strings.NewReader("")), out)
+	t.Cleanup(func() { shell = nil })
+	ran := &[]string{}
+	for _, name := range names {
+		shell.AddCmd(&ishell.Cmd{Name: name, Func: func(c *ishell.Context) {
+			*ran = append(*ran, name)
+		}})
+	}
+	gateCommands(shell)
+	return out, ran
+}
+
+func TestCommandsInEveryState(t *testing.T) {
+	serverAddress = "chat.example.com:3000"
+	t.Cleanup(func() { serverAddress = "" })
+	const (
+		notConnected = "not connected to chat.example.com:3000 - run doctor for details"
+		noStream     = "the chat stream is not open, login again"
+		loginFirst   = "you must login first"
+		logoutFirst  = "you are logged in, logout first"
+		leaveFirst   = "leave the conversation first"
+	)
+	// Each command maps to why it cannot run in each state, in the order
+	// disconnected, connected, authenticated, streaming, in a conversation.
+	// An empty reason means the command runs.
+	tests := map[string][5]string{
+		"signup":         {notConnected, "", logoutFirst, logoutFirst, leaveFirst},
+		"reset-password": {notConnected, "", logoutFirst, logoutFirst, leaveFirst},
+		"login":          {notConnected, "", "", logoutFirst, leaveFirst},
+		"search":         {notConnected, loginFirst, noStream, "", leaveFirst},
+		"logout":         {notConnected, loginFirst, "", "", leaveFirst},
+		"passwd":         {notConnected, loginFirst, "", "", leaveFirst},
+		"whoami":         {notConnected, loginFirst, "", "", leaveFirst},
+		"profile":        {notConnected, loginFirst, "", "", leaveFirst},
+		"doctor":         {"", "", "", "", ""},
+		"vault":          {"", "", "", "", ""},
+	}
+	for name := range commandStates {
+		if _, ok := tests[name]; !ok {
+			t.Errorf("the gated command %s has no test cases", name)
+		}
+	}
+
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	for _, state := range allStates {
+		for name, reasons := range tests {
+			t.Run(state.String()+"/"+name, func(t *testing.T) {
+				resetSession(t)
+				out, ran := gatedShell(t, names)
+				session.Lock()
+				session.state = state
+				showCommands()
+				session.Unlock()
+
+				if err := shell.Process(name); err != nil {
+					t.Fatalf("Process(%q): %v", name, err)
+				}
+				reason := reasons[state]
+				if reason == "" {
+					if len(*ran) != 1 || (*ran)[0] != name {
+						t.Errorf("%s did not run while %s; it printed %q", name, state, out.String())
+					}
+					return
+				}
+				if len(*ran) != 0 {
+					t.Errorf("%s ran while %s", name, state)
+				}
+				want := "[ERROR] " + name + " is not available while " + state.String() + ": " + reason
+				if got := strings.TrimSpace(out.String()); got != want {
+					t.Errorf("%s while %s printed %q, want %q", name, state, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSetState(t *testing.T) {
+	allowed := map[[2]sessionState]bool{
+		{stateDisconnected, stateConnected}:      true,
+		{stateConnected, stateDisconnected}:      true,
+		{stateConnected, stateAuthenticated}:     true,
+		{stateAuthenticated, stateDisconnected}:  true,
+		{stateAuthenticated, stateConnected}:     true,
+		{stateAuthenticated, stateStreaming}:     true,
+		{stateStreaming, stateDisconnected}:      true,
+		{stateStreaming, stateConnected}:         true,
+		{stateStreaming, stateInConversation}:    true,
+		{stateInConversation, stateDisconnected}: true,
+		{stateInConversation, stateConnected}:    true,
+		{stateInConversation, stateStreaming}:    true,
+	}
+	for _, from := range allStates {
+		for _, to := range allStates {
+			t.Run(from.String()+" to "+to.String(), func(t *testing.T) {
+				resetSession(t)
+				session.state = from
+				err := setState(to)
+				want := to
+				if from != to && !allowed[[2]sessionState{from, to}] {
+					if !errors.Is(err, errInvalidTransition) {
+						t.Errorf("setState(%s) from %s = %v, want errInvalidTransition", to, from, err)
+					}
+					want = from
+				} else if err != nil {
+					t.Errorf("setState(%s) from %s: %v", to, from, err)
+				}
+				if got := currentState(); got != want {
+					t.Errorf("state is %s, want %s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestEndState(t *testing.T) {
+	for _, from := range allStates {
+		for _, connected := range []bool{true, false} {
+			resetSession(t)
+			session.state = from
+			endState(connected)
+			want := stateDisconnected
+			if connected {
+				want = stateConnected
+			}
+			if got := currentState(); got != want {
+				t.Errorf("endState(%v) from %s left the session %s, want %s", connected, from, got, want)
+			}
+		}
+	}
+}
+
+func TestOfflineShowsDisconnected(t *testing.T) {
+	resetSession(t)
+	out, ran := gatedShell(t, []string{"search"})
+	if err := setState(stateConnected); err != nil {
+		t.Fatal(err)
+	}
+	session.state = stateStreaming
+
+	setOffline(true)
+	if got := currentState(); got != stateDisconnected {
+		t.Fatalf("state while offline is %s, want disconnected", got)
+	}
+	shell.Process("search")
+	if len(*ran) != 0 || !strings.Contains(out.String(), "search is not available while disconnected") {
+		t.Errorf("search while offline ran %v and printed %q", *ran, out.String())
+	}
+	// Losing the server does not lose the session: it comes back with it.
+	setOffline(false)
+	if got := currentState(); got != stateStreaming {
+		t.Fatalf("state once back online is %s, want streaming", got)
+	}
+	shell.Process("search")
+	if len(*ran) != 1 {
+		t.Errorf("search did not run once back online")
+	}
+}
+
+// waitForState waits for the session to reach want, as it does on its own
+// when the connection changes.
+func waitForState(t *testing.T, want sessionState) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for currentState() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("the session is %s, want %s; the connection is %s", currentState(), want, connection().GetState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerDownShowsDisconnected(t *testing.T) {
+	resetSession(t)
+	srv := startFakeServer(t)
+	connectTo(t, srv.addr)
+	if err := setState(stateConnected); err != nil {
+		t.Fatal(err)
+	}
+	for connection().GetState() != connectivity.Ready {
+		if !connection().WaitForStateChange(ctx, connection().GetState()) {
+			t.Fatal("the connection never became ready")
+		}
+	}
+
+	srv.stop()
+	waitForState(t, stateDisconnected)
+
+	srv.serve(t, srv.addr)
+	waitForState(t, stateConnected)
+}
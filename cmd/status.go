@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/abiosoft/ishell/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const shellPromptSuffix = ">>> "
+
+type connStatus string
+
+const (
+	statusOffline      connStatus = "offline"
+	statusConnecting   connStatus = "connecting"
+	statusOnline       connStatus = "online"
+	statusDegraded     connStatus = "degraded"
+	statusReconnecting connStatus = "reconnecting"
+)
+
+// linkStatus combines the transport state reported by grpc with what the
+// heartbeat and recovery know about the chat stream.
+var linkStatus = struct {
+	sync.Mutex
+	transport    connStatus
+	degraded     bool
+	reconnecting bool
+	shown        connStatus
+	// settled is set once the first connection attempt has finished;
+	// transitions before then are not announced.
+	settled bool
+}{transport: statusOffline, shown: statusOffline}
+
+// connectionWatchers tracks the watchConnection goroutines, so shutting down
+// can wait for them to stop touching the shell.
+var connectionWatchers sync.WaitGroup
+
+// commandRunning is set while a shell command owns the terminal, when the
+// shell prompt must not be redrawn underneath it.
+var commandRunning atomic.Bool
+
+func currentStatus() connStatus {
+	linkStatus.Lock()
+	defer linkStatus.Unlock()
+	return linkStatus.shown
+}
+
+func setTransportStatus(s connStatus) {
+	linkStatus.Lock()
+	linkStatus.transport = s
+	linkStatus.Unlock()
+	refreshStatus()
+}
+
+func setDegraded(degraded bool) {
+	linkStatus.Lock()
+	linkStatus.degraded = degraded
+	linkStatus.Unlock()
+	refreshStatus()
+}
+
+func setReconnecting(reconnecting bool) {
+	linkStatus.Lock()
+	linkStatus.reconnecting = reconnecting
+	linkStatus.Unlock()
+	refreshStatus()
+}
+
+// refreshStatus recomputes the overall status and, when it changed, prints
+// a status line and redraws the prompt.
+func refreshStatus() {
+	linkStatus.Lock()
+	next := linkStatus.transport
+	switch {
+	case linkStatus.reconnecting:
+		next = statusReconnecting
+	case linkStatus.degraded:
+		next = statusDegraded
+	}
+	prev := linkStatus.shown
+	linkStatus.shown = next
+	announce := linkStatus.settled
+	if next == statusOnline || next == statusOffline {
+		linkStatus.settled = true
+	}
+	linkStatus.Unlock()
+
+	if next == prev {
+		return
+	}
+	logger.Info("connection status changed", "from", string(prev), "to", string(next))
+	if shell == nil {
+		return
+	}
+
+	shell.SetPrompt(shellPrompt())
+	switch {
+	case chatActive.Load():
+		lineEditor.SetPrompt(chatPrompt(selectedAccount.GetFirstName()))
+	case !commandRunning.Load():
+		lineEditor.SetPrompt(shellPrompt())
+	}
+	if announce {
+		fmt.Fprintf(lineEditor.Stdout(), "-- %s --\n", next)
+	} else {
+		lineEditor.Refresh()
+	}
+}
+
+func shellPrompt() string {
+	return fmt.Sprintf("[%s] %s", currentStatus(), shellPromptSuffix)
+}
+
+func chatPrompt(name string) string {
+	return fmt.Sprintf("[%s] To %s: ", currentStatus(), name)
+}
+
+func transportStatus(state connectivity.State) connStatus {
+	switch state {
+	case connectivity.Ready:
+		return statusOnline
+	case connectivity.Idle, connectivity.Connecting:
+		return statusConnecting
+	}
+	return statusOffline
+}
+
+// watchConnection follows the connection's state until it is closed,
+// updating the status shown to the user, counting reconnects and treating
+// the session as disconnected while the server cannot be reached.
+func watchConnection(ctx context.Context, conn *grpc.ClientConn) {
+	state := conn.GetState()
+	setTransportStatus(transportStatus(state))
+	lost := false
+	for conn.WaitForStateChange(ctx, state) {
+		state = conn.GetState()
+		switch state {
+		case connectivity.Shutdown:
+			return
+		case connectivity.Idle:
+			// The connection was dropped. Reconnect right away rather than
+			// on the next call, so a server that went away shows as down.
+			lost = true
+			conn.Connect()
+		case connectivity.TransientFailure:
+			lost = true
+			setOffline(true)
+		case connectivity.Ready:
+			if lost {
+				reconnects.Add(ctx, 1)
+				logger.Info("reconnected to server")
+			}
+			lost = false
+			setOffline(false)
+		}
+		setTransportStatus(transportStatus(state))
+	}
+}
+
+// trackCommands marks every shell command as running while it executes so
+// status changes leave its prompts alone, and gives it a context that Ctrl-C
+// cancels. Running a command also keeps an unlocked vault from idling out.
+func trackCommands(cmds []*ishell.Cmd) {
+	for _, cmd := range cmds {
+		if run := cmd.Func; run != nil {
+			cmd.Func = func(c *ishell.Context) {
+				commandRunning.Store(true)
+				defer commandRunning.Store(false)
+				beginCommand()
+				defer endCommand()
+				vault.touch()
+				run(c)
+			}
+		}
+		trackCommands(cmd.Children())
+	}
+}
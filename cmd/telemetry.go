@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+const instrumentationName = "github.com/Madslick/chit-chat-go-client"
+
+var tracer trace.Tracer
+var sendLatency metric.Float64Histogram
+var reconnects metric.Int64Counter
+
+var telemetry bool
+
+func defaultTelemetryPath() string {
+	return filepath.Join(filepath.Dir(defaultLogPath()), "telemetry.jsonl")
+}
+
+// otlpConfigured reports whether the standard OTLP environment variables
+// point somewhere, in which case spans and metrics go there instead of the
+// local file.
+func otlpConfigured() bool {
+	for _, key := range []string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT",
+	} {
+		if os.Getenv(key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setupTelemetry installs the global tracer and meter providers. Output goes
+// to OTLP when configured through the environment, otherwise to path ("-"
+// for stdout). The returned function flushes and shuts everything down.
+func setupTelemetry(ctx context.Context, path string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var metricExporter sdkmetric.Exporter
+	var out io.WriteCloser
+
+	if otlpConfigured() {
+		var err error
+		if spanExporter, err = otlptracegrpc.New(ctx); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = otlpmetricgrpc.New(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		if path == "-" {
+			out = os.Stdout
+		} else {
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				return nil, err
+			}
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				return nil, err
+			}
+			out = file
+		}
+
+		var err error
+		if spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(out)); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = stdoutmetric.New(stdoutmetric.WithWriter(out)); err != nil {
+			return nil, err
+		}
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName("chit-chat-go-client"))
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+
+	shutdown := func(ctx context.Context) error {
+		err := errors.Join(
+			tracerProvider.Shutdown(ctx),
+			meterProvider.Shutdown(ctx),
+		)
+		if out != nil && out != os.Stdout {
+			err = errors.Join(err, out.Close())
+		}
+		return err
+	}
+	return shutdown, nil
+}
+
+// initInstruments creates the client's tracer and metric instruments from
+// the global providers. Without setupTelemetry they are no-ops.
+func initInstruments() {
+	tracer = otel.Tracer(instrumentationName)
+
+	meter := otel.Meter(instrumentationName)
+	var err error
+	sendLatency, err = meter.Float64Histogram(
+		"chat.send.latency",
+		metric.WithDescription("Time taken to hand a ChatEvent to the Converse stream"),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		logger.Error("failed to create send latency histogram", "err", err)
+	}
+	reconnects, err = meter.Int64Counter(
+		"chat.reconnects",
+		metric.WithDescription("Number of times the connection to the server was re-established"),
+	)
+	if err != nil {
+		logger.Error("failed to create reconnect counter", "err", err)
+	}
+}
+
+func telemetryDialOptions() []grpc.DialOption {
+	if !telemetry {
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithStatsHandler(otelgrpc.NewClientHandler())}
+}
+
+// eventConversationId returns the conversation event belongs to, empty for
+// events that belong to none such as login.
+func eventConversationId(event *pkg.ChatEvent) string {
+	switch {
+	case event.GetMessage() != nil:
+		return event.GetMessage().GetConversation().GetId()
+	case event.GetEdit() != nil:
+		return event.GetEdit().GetConversation().GetId()
+	case event.GetDelete() != nil:
+		return event.GetDelete().GetConversation().GetId()
+	}
+	return ""
+}
+
+// sendMu serialises sends on the Converse stream, which the heartbeat shares
+// with the user.
+var sendMu sync.Mutex
+
+// sendEvent sends event on the Converse stream inside its own span and
+// records how long the send took.
+func sendEvent(ctx context.Context, event *pkg.ChatEvent) error {
+	eventType := chatEventType(event)
+	ctx, span := tracer.Start(ctx, "chat.send", trace.WithAttributes(
+		attribute.String("chat.event", eventType),
+		attribute.String("chat.conversation_id", eventConversationId(event)),
+	))
+	defer span.End()
+
+	sendMu.Lock()
+	start := time.Now()
+	err := currentStream().Send(event)
+	elapsed := time.Since(start)
+	sendMu.Unlock()
+	sendLatency.Record(ctx, float64(elapsed)/float64(time.Millisecond),
+		metric.WithAttributes(attribute.String("chat.event", eventType)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// closeSend half-closes the Converse stream once any send in progress is
+// done, as grpc does not allow the two at once.
+func closeSend() error {
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	return currentStream().CloseSend()
+}
+
+// traceReceived records a span for an event read from the Converse stream.
+func traceReceived(ctx context.Context, event *pkg.ChatEvent) {
+	_, span := tracer.Start(ctx, "chat.receive", trace.WithAttributes(
+		attribute.String("chat.event", chatEventType(event)),
+		attribute.String("chat.conversation_id", eventConversationId(event)),
+	))
+	span.End()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// recordTelemetry points the client's instruments at an in-memory exporter
+// and reader for the rest of the test.
+func recordTelemetry(t *testing.T) (*tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	tracer = tracerProvider.Tracer(instrumentationName)
+	var err error
+	sendLatency, err = meterProvider.Meter(instrumentationName).Float64Histogram("chat.send.latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(initInstruments)
+	return exporter, reader
+}
+
+// sentEvents is a Converse stream that keeps what is sent on it.
+type sentEvents struct {
+	pkg.Chatroom_ConverseClient
+	events []*pkg.ChatEvent
+	err    error
+}
+
+func (s *sentEvents) Send(event *pkg.ChatEvent) error {
+	s.events = append(s.events, event)
+	return s.err
+}
+
+// useStream replaces the chat stream for the rest of the test.
+func useStream(t *testing.T, s pkg.Chatroom_ConverseClient) {
+	t.Helper()
+	swapStream(s, func() {})
+	t.Cleanup(func() { swapStream(nil, func() {}) })
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) string {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value.AsString()
+		}
+	}
+	return ""
+}
+
+func TestSendEventTrace(t *testing.T) {
+	chat := &pkg.Conversation{Id: "c1"}
+	from := &pkg.Client{ClientId: "alice"}
+	tests := []struct {
+		name           string
+		event          *pkg.ChatEvent
+		eventType      string
+		conversationId string
+	}{
+		{"login", &pkg.ChatEvent{Command: &pkg.ChatEvent_Login{Login: from}}, "login", ""},
+		{"message", &pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{Conversation: chat, From: from, Content: "hi"}}}, "message", "c1"},
+		{"edit", &pkg.ChatEvent{Command: &pkg.ChatEvent_Edit{Edit: &pkg.MessageEdit{Conversation: chat, From: from, Id: "m1"}}}, "edit", "c1"},
+		{"delete", &pkg.ChatEvent{Command: &pkg.ChatEvent_Delete{Delete: &pkg.MessageDelete{Conversation: chat, From: from, Id: "m1"}}}, "delete", "c1"},
+		{"logout", &pkg.ChatEvent{Command: &pkg.ChatEvent_Logout{Logout: from}}, "logout", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter, reader := recordTelemetry(t)
+			sent := &sentEvents{}
+			useStream(t, sent)
+
+			if err := sendEvent(context.Background(), tt.event); err != nil {
+				t.Fatalf("sendEvent: %v", err)
+			}
+			if len(sent.events) != 1 || sent.events[0] != tt.event {
+				t.Fatalf("the stream got %v, want the event", sent.events)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("recorded %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name != "chat.send" {
+				t.Errorf("span name = %q, want chat.send", span.Name)
+			}
+			if got := spanAttribute(span, "chat.event"); got != tt.eventType {
+				t.Errorf("chat.event = %q, want %q", got, tt.eventType)
+			}
+			if got := spanAttribute(span, "chat.conversation_id"); got != tt.conversationId {
+				t.Errorf("chat.conversation_id = %q, want %q", got, tt.conversationId)
+			}
+			if span.Status.Code == codes.Error || len(span.Events) != 0 {
+				t.Errorf("a successful send recorded an error: %+v", span.Status)
+			}
+
+			var rm metricdata.ResourceMetrics
+			if err := reader.Collect(context.Background(), &rm); err != nil {
+				t.Fatal(err)
+			}
+			if got := latencyCount(rm, tt.eventType); got != 1 {
+				t.Errorf("recorded %d send latencies for %s, want 1", got, tt.eventType)
+			}
+		})
+	}
+}
+
+// latencyCount is how many send latencies were recorded for eventType.
+func latencyCount(rm metricdata.ResourceMetrics, eventType string) uint64 {
+	var count uint64
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			hist, ok := m.Data.(metricdata.Histogram[float64])
+			if m.Name != "chat.send.latency" || !ok {
+				continue
+			}
+			for _, point := range hist.DataPoints {
+				if v, _ := point.Attributes.Value("chat.event"); v.AsString() == eventType {
+					count += point.Count
+				}
+			}
+		}
+	}
+	return count
+}
+
+func TestSendEventTraceRecordsErrors(t *testing.T) {
+	exporter, _ := recordTelemetry(t)
+	failed := errors.New("stream closed")
+	useStream(t, &sentEvents{err: failed})
+
+	event := &pkg.ChatEvent{Command: &pkg.ChatEvent_Login{Login: &pkg.Client{ClientId: "alice"}}}
+	if err := sendEvent(context.Background(), event); err != failed {
+		t.Fatalf("sendEvent = %v, want the stream's error", err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	if events := spans[0].Events; len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("span events = %+v, want the error recorded", events)
+	}
+	if status := spans[0].Status; status.Code != codes.Error || status.Description != "stream closed" {
+		t.Errorf("span status = %+v, want an error", status)
+	}
+}
+
+func TestTraceReceived(t *testing.T) {
+	exporter, _ := recordTelemetry(t)
+	parentCtx, parent := tracer.Start(context.Background(), "parent")
+	traceReceived(parentCtx, &pkg.ChatEvent{Command: &pkg.ChatEvent_Message{Message: &pkg.Message{
+		Conversation: &pkg.Conversation{Id: "c1"},
+		From:         &pkg.Client{ClientId: "bob"},
+		Content:      "hi",
+	}}})
+	traceReceived(parentCtx, &pkg.ChatEvent{})
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+	for i, want := range []struct{ eventType, conversationId string }{{"message", "c1"}, {"empty", ""}} {
+		span := spans[i]
+		if span.Name != "chat.receive" {
+			t.Errorf("span %d name = %q, want chat.receive", i, span.Name)
+		}
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d is not a child of the span in the context", i)
+		}
+		if got := spanAttribute(span, "chat.event"); got != want.eventType {
+			t.Errorf("span %d chat.event = %q, want %q", i, got, want.eventType)
+		}
+		if got := spanAttribute(span, "chat.conversation_id"); got != want.conversationId {
+			t.Errorf("span %d chat.conversation_id = %q, want %q", i, got, want.conversationId)
+		}
+	}
+}
+
+func TestUnaryCallsTraced(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	saved, savedTelemetry := otel.GetTracerProvider(), telemetry
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	telemetry = true
+	t.Cleanup(func() {
+		otel.SetTracerProvider(saved)
+		telemetry = savedTelemetry
+	})
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "pass-word1")
+	connectTo(t, srv.addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "wrong"}); err == nil {
+		t.Fatal("SignIn with the wrong password succeeded")
+	}
+	if found, err := searchAccounts(ctx, "alice"); err != nil || len(found) != 1 {
+		t.Fatalf("searchAccounts = %v, %v", found, err)
+	}
+	if _, err := chatClient().CreateConversation(ctx, &pkg.ConversationRequest{Members: []*pkg.Client{alice, bob}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name   string
+		status codes.Code
+		code   int64
+	}{
+		{"pkg.Auth/SignIn", codes.Unset, 0},
+		{"pkg.Auth/SignIn", codes.Error, 16},
+		{"pkg.Auth/SearchAccounts", codes.Unset, 0},
+		{"pkg.Chatroom/CreateConversation", codes.Unset, 0},
+	}
+	var spans tracetest.SpanStubs
+	eventually(t, "every call has ended its span", func() bool {
+		spans = exporter.GetSpans()
+		return len(spans) >= len(want)
+	})
+	if len(spans) != len(want) {
+		t.Fatalf("recorded %d spans, want %d", len(spans), len(want))
+	}
+	for i, span := range spans {
+		var code int64 = -1
+		for _, attr := range span.Attributes {
+			if attr.Key == "rpc.grpc.status_code" {
+				code = attr.Value.AsInt64()
+			}
+		}
+		if span.Name != want[i].name || span.SpanKind != trace.SpanKindClient || span.Status.Code != want[i].status || code != want[i].code {
+			t.Errorf("span %d is %s, kind %s, status %v, grpc code %d; want a client span %s, status %v, grpc code %d",
+				i, span.Name, span.SpanKind, span.Status.Code, code, want[i].name, want[i].status, want[i].code)
+		}
+	}
+}
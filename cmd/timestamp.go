@@ -0,0 +1,112 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// timeFormat is the Go time layout messages are stamped with, empty to show
+// no times.
+var timeFormat = "15:04"
+
+// messageTime is when msg was sent: the time the server received it, or the
+// sender's own clock for servers that do not stamp messages.
+func messageTime(msg *pkg.ConversationMessage) time.Time {
+	switch {
+	case msg.GetReceivedAt() != nil:
+		return msg.GetReceivedAt().AsTime()
+	case msg.GetSentAt() != nil:
+		return msg.GetSentAt().AsTime()
+	}
+	return time.Time{}
+}
+
+// formatTimestamp renders t in local time ahead of a chat line.
+func formatTimestamp(t time.Time) string {
+	if timeFormat == "" || t.IsZero() {
+		return ""
+	}
+	return "[" + t.Local().Format(timeFormat) + "] "
+}
+
+// formatStamped renders a stored message with its time, marking edited
+// messages and leaving a tombstone for deleted ones.
+func formatStamped(msg *pkg.ConversationMessage) string {
+	stamp := formatTimestamp(messageTime(msg))
+	switch {
+	case msg.GetDeleted():
+		return stamp + "(" + msg.GetFrom().GetName() + " deleted a message)"
+	case msg.GetEditedAt() != nil:
+		return stamp + formatMessage(msg.GetFrom(), msg.GetContent()) + " (edited)"
+	}
+	return stamp + formatMessage(msg.GetFrom(), msg.GetContent())
+}
+
+// dayLabel names the local day of t relative to now.
+func dayLabel(t time.Time, now time.Time) string {
+	t, now = t.Local(), now.Local()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local); {
+	case day.Equal(today):
+		return "Today"
+	case day.Equal(today.AddDate(0, 0, -1)):
+		return "Yesterday"
+	case t.Year() == now.Year():
+		return t.Format("Monday, 2 January")
+	}
+	return t.Format("Monday, 2 January 2006")
+}
+
+// daySeparator tells when a run of messages crosses into another day.
+type daySeparator struct {
+	last time.Time
+}
+
+// next returns the separator line to print before a message sent at t, if
+// it is on a later local day than the message before it.
+func (d *daySeparator) next(t time.Time) (string, bool) {
+	if timeFormat == "" || t.IsZero() {
+		return "", false
+	}
+	y1, m1, d1 := d.last.Local().Date()
+	y2, m2, d2 := t.Local().Date()
+	d.last = t
+	if y1 == y2 && m1 == m2 && d1 == d2 {
+		return "", false
+	}
+	return "-- " + dayLabel(t, time.Now()) + " --", true
+}
+
+// orderMessages sorts msgs oldest first and drops repeats of the same id.
+// A message without a time keeps its place after the one before it.
+func orderMessages(msgs []*pkg.ConversationMessage) []*pkg.ConversationMessage {
+	type timed struct {
+		msg *pkg.ConversationMessage
+		at  time.Time
+	}
+	seen := map[string]bool{}
+	var ordered []timed
+	var last time.Time
+	for _, msg := range msgs {
+		if id := msg.GetId(); id != "" {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+		if at := messageTime(msg); !at.IsZero() {
+			last = at
+		}
+		ordered = append(ordered, timed{msg, last})
+	}
+	slices.SortStableFunc(ordered, func(a, b timed) int {
+		return a.at.Compare(b.at)
+	})
+	result := make([]*pkg.ConversationMessage, len(ordered))
+	for i, t := range ordered {
+		result[i] = t.msg
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// at is a message sent at minute m past noon on 15 January 2025, local time.
+func at(id string, m int) *pkg.ConversationMessage {
+	return &pkg.ConversationMessage{
+		Id:         id,
+		Content:    id,
+		ReceivedAt: timestamppb.New(time.Date(2025, 1, 15, 12, m, 0, 0, time.Local)),
+	}
+}
+
+// untimed is a message from a server that stamps nothing.
+func untimed(id string) *pkg.ConversationMessage {
+	return &pkg.ConversationMessage{Id: id, Content: id}
+}
+
+func contents(msgs []*pkg.ConversationMessage) []string {
+	var got []string
+	for _, msg := range msgs {
+		got = append(got, msg.GetContent())
+	}
+	return got
+}
+
+func TestOrderMessages(t *testing.T) {
+	sentOnly := &pkg.ConversationMessage{
+		Id:      "sent",
+		Content: "sent",
+		SentAt:  timestamppb.New(time.Date(2025, 1, 15, 12, 2, 30, 0, time.Local)),
+	}
+	tests := []struct {
+		name string
+		msgs []*pkg.ConversationMessage
+		want []string
+	}{
+		{"ordered", []*pkg.ConversationMessage{at("a", 1), at("b", 2)}, []string{"a", "b"}},
+		{"out of order", []*pkg.ConversationMessage{at("c", 3), at("a", 1), at("b", 2)}, []string{"a", "b", "c"}},
+		{"repeated id", []*pkg.ConversationMessage{at("a", 1), at("b", 2), at("a", 1)}, []string{"a", "b"}},
+		{"same time keeps order", []*pkg.ConversationMessage{at("b", 1), at("a", 1)}, []string{"b", "a"}},
+		{"untimed follows the one before", []*pkg.ConversationMessage{at("c", 3), at("a", 1), untimed("x")}, []string{"a", "x", "c"}},
+		{"untimed first", []*pkg.ConversationMessage{untimed("x"), at("a", 1)}, []string{"x", "a"}},
+		{"sender's clock", []*pkg.ConversationMessage{at("c", 3), sentOnly, at("a", 1)}, []string{"a", "sent", "c"}},
+		{"no ids are never repeats", []*pkg.ConversationMessage{{Content: "hi"}, {Content: "hi"}}, []string{"hi", "hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contents(orderMessages(tt.msgs)); !slices.Equal(got, tt.want) {
+				t.Errorf("orderMessages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryAdd(t *testing.T) {
+	h := emptyHistory()
+	steps := []struct {
+		msg  *pkg.ConversationMessage
+		want bool
+	}{
+		{at("b", 2), true},
+		{at("d", 4), true},
+		{at("a", 1), true},
+		{at("c", 3), true},
+		{at("b", 2), false},
+		{untimed("x"), true},
+		{untimed("x"), false},
+		{&pkg.ConversationMessage{Content: "no id"}, true},
+	}
+	for _, step := range steps {
+		if got := h.add("c1", step.msg); got != step.want {
+			t.Errorf("add(%s) = %v, want %v", step.msg.GetContent(), got, step.want)
+		}
+	}
+	if got, want := contents(h.last("c1", 10)), []string{"a", "b", "c", "d", "x", "no id"}; !slices.Equal(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+	if !h.add("c2", at("a", 1)) {
+		t.Error("an id seen in one conversation was a repeat in another")
+	}
+
+	h.replace("c1", []*pkg.ConversationMessage{at("a", 1)})
+	if h.add("c1", at("a", 1)) || !h.add("c1", at("b", 2)) {
+		t.Error("replace did not reset the ids seen to the ones it was given")
+	}
+}
+
+func TestHistoryEchoedTakesServerId(t *testing.T) {
+	h := emptyHistory()
+	sent := &pkg.ConversationMessage{Content: "hi"}
+	h.addSent("c1", sent)
+	echo := at("m1", 1)
+	echo.Content = "hi"
+	if !h.echoed("c1", echo) {
+		t.Fatal("the echo of a sent message was not recognised")
+	}
+	confirmed := h.last("c1", 1)[0]
+	if confirmed.GetId() != "m1" || !confirmed.GetReceivedAt().AsTime().Equal(echo.GetReceivedAt().AsTime()) {
+		t.Errorf("sent message has id %q and time %v, want the server's", confirmed.GetId(), confirmed.GetReceivedAt())
+	}
+	if sent.GetId() != "" {
+		t.Error("echoed changed the message handed out instead of a copy")
+	}
+	if h.echoed("c1", echo) {
+		t.Error("a second echo consumed the same sent message")
+	}
+	if h.add("c1", echo) {
+		t.Error("the echo's id was not recorded")
+	}
+}
+
+func TestDayLabel(t *testing.T) {
+	midnight := time.Date(2025, 1, 15, 0, 0, 0, 0, time.Local)
+	newYear := time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		t    time.Time
+		now  time.Time
+		want string
+	}{
+		{"just after midnight", midnight, midnight.Add(time.Second), "Today"},
+		{"just before midnight", midnight.Add(-time.Second), midnight.Add(time.Second), "Yesterday"},
+		{"late the same day", midnight.Add(time.Second), midnight.Add(24*time.Hour - time.Second), "Today"},
+		{"two days ago", midnight.Add(-24*time.Hour - time.Second), midnight, "Monday, 13 January"},
+		{"across the new year", newYear.Add(-time.Second), newYear.Add(time.Second), "Yesterday"},
+		{"last year", newYear.Add(-24*time.Hour - time.Second), newYear, "Monday, 30 December 2024"},
+		{"in another zone", midnight.Add(-time.Second).UTC(), midnight.UTC(), "Yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dayLabel(tt.t, tt.now); got != tt.want {
+				t.Errorf("dayLabel(%v, %v) = %q, want %q", tt.t, tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDaySeparator(t *testing.T) {
+	midnight := time.Date(2025, 1, 15, 0, 0, 0, 0, time.Local)
+	d := daySeparator{last: midnight.Add(-time.Minute)}
+	if _, ok := d.next(midnight.Add(-time.Second)); ok {
+		t.Error("a separator between messages on the same day")
+	}
+	if _, ok := d.next(midnight); !ok {
+		t.Error("no separator at midnight")
+	}
+	if _, ok := d.next(time.Time{}); ok {
+		t.Error("a separator before an untimed message")
+	}
+
+	format := timeFormat
+	timeFormat = ""
+	t.Cleanup(func() { timeFormat = format })
+	if _, ok := d.next(midnight.Add(48 * time.Hour)); ok {
+		t.Error("a separator with timestamps turned off")
+	}
+}
+
+func TestServerStampsMessages(t *testing.T) {
+	srv := startFakeServer(t)
+	loginTo(t, srv, srv.addr, testEmail)
+	openConversation(t)
+
+	for _, content := range []string{"one", "two"} {
+		if err := sendMessage(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the server's ids reach the history", func() bool {
+		msgs := history.last(conversation.GetId(), 10)
+		return len(msgs) == 2 && msgs[1].GetId() != ""
+	})
+	sent := history.last(conversation.GetId(), 10)
+	if sent[0].GetId() != "m1" || sent[1].GetId() != "m2" || sent[0].GetReceivedAt() == nil {
+		t.Errorf("sent messages have ids %q and %q, received at %v", sent[0].GetId(), sent[1].GetId(), sent[0].GetReceivedAt())
+	}
+
+	// Messages replayed by the server are recognised by id.
+	srv.redeliver(&conversation)
+	if err := sendMessage("three"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the third message is echoed", func() bool {
+		msgs := history.last(conversation.GetId(), 10)
+		return len(msgs) >= 3 && msgs[len(msgs)-1].GetId() != ""
+	})
+	if got, want := contents(history.last(conversation.GetId(), 10)), []string{"one", "two", "three"}; !slices.Equal(got, want) {
+		t.Errorf("history after the replay = %q, want %q", got, want)
+	}
+
+	// Stored history comes back stamped, in order.
+	response, err := chatClient().CreateConversation(context.Background(), &pkg.ConversationRequest{Members: []*pkg.Client{me()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := orderMessages(slices.Concat(response.GetMessages(), response.GetMessages()))
+	if got, want := contents(stored), []string{"one", "two", "three"}; !slices.Equal(got, want) {
+		t.Errorf("stored history = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const vaultVersion = 1
+
+// scrypt cost for newly written vaults. The parameters are stored in the
+// file so they can be raised without breaking existing vaults.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Bounds on the scrypt parameters read from a vault file. They are used
+// before anything in the file is authenticated, so a damaged or tampered
+// file must not be able to make unlocking take all memory or run forever.
+const (
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 256 << 20
+	minSaltLength   = 16
+)
+
+var errVaultLocked = errors.New("vault is locked")
+var errVaultPassphrase = errors.New("wrong passphrase or the vault file is damaged")
+
+var vaultPath = defaultVaultPath()
+var vaultIdle = 15 * time.Minute
+var profileName = "default"
+
+var vault = &credentialVault{}
+
+func defaultVaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "chit-chat-go", "vault")
+}
+
+// vaultEntry is the login saved for a profile.
+type vaultEntry struct {
+	Server   string    `json:"server"`
+	Email    string    `json:"email"`
+	Password string    `json:"password"`
+	Saved    time.Time `json:"saved"`
+}
+
+// vaultFile is the vault as written to disk. Everything but the entries is
+// in the clear and authenticated as additional data.
+type vaultFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+func (f *vaultFile) header() []byte {
+	return fmt.Appendf(nil, "chit-chat-go vault v%d %s %x %d %d %d", f.Version, f.KDF, f.Salt, f.N, f.R, f.P)
+}
+
+func (f *vaultFile) deriveKey(passphrase string) ([]byte, error) {
+	if f.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation %q", f.KDF)
+	}
+	if err := f.checkParams(); err != nil {
+		return nil, err
+	}
+	return scrypt.Key([]byte(passphrase), f.Salt, f.N, f.R, f.P, 32)
+}
+
+// checkParams rejects key derivation parameters no vault written by this
+// client has, before they cost any time or memory.
+func (f *vaultFile) checkParams() error {
+	switch {
+	case f.N < 2 || f.N > maxScryptN || f.N&(f.N-1) != 0,
+		f.R < 1 || f.R > maxScryptR,
+		f.P < 1 || f.P > maxScryptP,
+		128*f.N*f.R > maxScryptMemory,
+		len(f.Salt) < minSaltLength:
+		return fmt.Errorf("vault file is damaged: key derivation parameters out of range (N=%d r=%d p=%d salt %d bytes)", f.N, f.R, f.P, len(f.Salt))
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// credentialVault holds the saved logins, decrypted only while unlocked. It
+// locks itself again once unused for vaultIdle.
+type credentialVault struct {
+	mu      sync.Mutex
+	file    *vaultFile
+	key     []byte
+	entries map[string]vaultEntry
+	timer   *time.Timer
+}
+
+func (v *credentialVault) exists() bool {
+	_, err := os.Stat(vaultPath)
+	return err == nil
+}
+
+func (v *credentialVault) unlocked() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.key != nil
+}
+
+// create starts an empty vault protected by passphrase and leaves it unlocked.
+func (v *credentialVault) create(passphrase string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.rekey(passphrase); err != nil {
+		return err
+	}
+	v.entries = map[string]vaultEntry{}
+	if err := v.save(); err != nil {
+		v.clear()
+		return err
+	}
+	logger.Info("vault created", "path", vaultPath)
+	v.touchLocked()
+	return nil
+}
+
+func (v *credentialVault) unlock(passphrase string) error {
+	raw, err := os.ReadFile(vaultPath)
+	if err != nil {
+		return err
+	}
+	file := &vaultFile{}
+	if err := json.Unmarshal(raw, file); err != nil {
+		return fmt.Errorf("reading %s: %w", vaultPath, err)
+	}
+	if file.Version != vaultVersion {
+		return fmt.Errorf("unsupported vault version %d", file.Version)
+	}
+	key, err := file.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, file.header())
+	if err != nil {
+		logger.Warn("vault unlock failed", "path", vaultPath)
+		return errVaultPassphrase
+	}
+	entries := map[string]vaultEntry{}
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return fmt.Errorf("reading %s: %w", vaultPath, err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.file, v.key, v.entries = file, key, entries
+	logger.Info("vault unlocked", "path", vaultPath, "profiles", len(entries))
+	v.touchLocked()
+	return nil
+}
+
+func (v *credentialVault) lock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key != nil {
+		logger.Info("vault locked", "path", vaultPath)
+	}
+	v.clear()
+}
+
+func (v *credentialVault) clear() {
+	clear(v.key)
+	v.key = nil
+	v.entries = nil
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+}
+
+// touch restarts the idle timer of an unlocked vault.
+func (v *credentialVault) touch() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.touchLocked()
+}
+
+func (v *credentialVault) touchLocked() {
+	if v.key == nil || vaultIdle <= 0 {
+		return
+	}
+	if v.timer != nil {
+		v.timer.Reset(vaultIdle)
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(vaultIdle, func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if v.timer != timer {
+			return
+		}
+		logger.Info("vault idle, locking", "idle", vaultIdle)
+		v.clear()
+	})
+	v.timer = timer
+}
+
+func (v *credentialVault) get(profile string) (vaultEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.entries[profile]
+	if ok {
+		v.touchLocked()
+	}
+	return entry, ok
+}
+
+func (v *credentialVault) profiles() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	names := make([]string, 0, len(v.entries))
+	for name := range v.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	v.touchLocked()
+	return names
+}
+
+func (v *credentialVault) put(profile string, entry vaultEntry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return errVaultLocked
+	}
+	previous, had := v.entries[profile]
+	v.entries[profile] = entry
+	if err := v.save(); err != nil {
+		if had {
+			v.entries[profile] = previous
+		} else {
+			delete(v.entries, profile)
+		}
+		return err
+	}
+	logger.Info("vault entry saved", "profile", profile, "email", entry.Email)
+	v.touchLocked()
+	return nil
+}
+
+func (v *credentialVault) remove(profile string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return false, errVaultLocked
+	}
+	entry, ok := v.entries[profile]
+	if !ok {
+		return false, nil
+	}
+	delete(v.entries, profile)
+	if err := v.save(); err != nil {
+		v.entries[profile] = entry
+		return false, err
+	}
+	logger.Info("vault entry removed", "profile", profile)
+	v.touchLocked()
+	return true, nil
+}
+
+// updatePassword replaces the saved password of every profile logging in
+// with email, returning how many were changed.
+func (v *credentialVault) updatePassword(email string, password string) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return 0, errVaultLocked
+	}
+	previous := map[string]vaultEntry{}
+	for name, entry := range v.entries {
+		if entry.Email == email {
+			previous[name] = entry
+			entry.Password = password
+			entry.Saved = time.Now()
+			v.entries[name] = entry
+		}
+	}
+	if len(previous) == 0 {
+		return 0, nil
+	}
+	if err := v.save(); err != nil {
+		for name, entry := range previous {
+			v.entries[name] = entry
+		}
+		return 0, err
+	}
+	v.touchLocked()
+	return len(previous), nil
+}
+
+// rotate re-encrypts the vault under a new passphrase and a fresh salt.
+func (v *credentialVault) rotate(passphrase string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return errVaultLocked
+	}
+	file, key := v.file, v.key
+	if err := v.rekey(passphrase); err != nil {
+		return err
+	}
+	if err := v.save(); err != nil {
+		clear(v.key)
+		v.file, v.key = file, key
+		return err
+	}
+	clear(key)
+	logger.Info("vault passphrase changed", "path", vaultPath)
+	v.touchLocked()
+	return nil
+}
+
+// rekey derives a new key from passphrase with a fresh salt.
+func (v *credentialVault) rekey(passphrase string) error {
+	file := &vaultFile{Version: vaultVersion, KDF: "scrypt", Salt: make([]byte, 16), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	key, err := file.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	v.file, v.key = file, key
+	return nil
+}
+
+// save encrypts the entries with a new nonce and replaces the vault file.
+func (v *credentialVault) save() error {
+	plain, err := json.Marshal(v.entries)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(v.key)
+	if err != nil {
+		return err
+	}
+	file := *v.file
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, file.header())
+	clear(plain)
+	raw, err := json.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(vaultPath), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(vaultPath), ".vault-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), vaultPath); err != nil {
+		return err
+	}
+	v.file = &file
+	return nil
+}
+
+// maxPassphraseAttempts is how many passphrases are tried when unlocking.
+const maxPassphraseAttempts = 3
+
+const minPassphraseLength = 8
+
+// rememberLogin has login offer to save the credentials it was given, which
+// it otherwise only does once a vault exists.
+var rememberLogin bool
+
+// unlockVault asks for the passphrase of a locked vault. An empty answer
+// skips it for now; false is returned unless the vault ends up unlocked.
+func unlockVault(c *ishell.Context) bool {
+	if vault.unlocked() {
+		return true
+	}
+	if !vault.exists() {
+		return false
+	}
+	for attempt := 1; attempt <= maxPassphraseAttempts; attempt++ {
+		passphrase, ok := promptPassword(c, "Vault passphrase (enter to skip): ")
+		if !ok || passphrase == "" {
+			return false
+		}
+		err := vault.unlock(passphrase)
+		if err == nil {
+			return true
+		}
+		if !errors.Is(err, errVaultPassphrase) {
+			c.Println("[ERROR] Unable to open the vault:", err)
+			return false
+		}
+		c.Println("  " + err.Error())
+	}
+	return false
+}
+
+// promptPassphrase asks for a new vault passphrase twice.
+func promptPassphrase(c *ishell.Context, label string) (string, bool) {
+	for {
+		passphrase, ok := promptPassword(c, label)
+		if !ok {
+			return "", false
+		}
+		if len(passphrase) < minPassphraseLength {
+			c.Printf("  use at least %d characters\n", minPassphraseLength)
+			continue
+		}
+		confirmation, ok := promptPassword(c, "Confirm Passphrase: ")
+		if !ok {
+			return "", false
+		}
+		if confirmation != passphrase {
+			c.Println("  passphrases do not match")
+			continue
+		}
+		return passphrase, true
+	}
+}
+
+// savedLogin returns the credentials saved for the current profile,
+// unlocking the vault first if needed. A login saved for another server is
+// only used once the user confirms sending its password to this one.
+func savedLogin(c *ishell.Context) (vaultEntry, bool) {
+	if !unlockVault(c) {
+		return vaultEntry{}, false
+	}
+	entry, ok := vault.get(profileName)
+	if !ok || entry.Server == serverAddress {
+		return entry, ok
+	}
+	saved := entry.Server
+	if saved == "" {
+		saved = "an unknown server"
+	}
+	c.Printf("  the saved login for profile %s is for %s, not %s\n", profileName, saved, serverAddress)
+	answer, ok := prompt(c, fmt.Sprintf("Send the saved password for %s to %s? [y/N] ", entry.Email, serverAddress))
+	if !ok || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		logger.Info("saved login not used for another server", "profile", profileName, "saved_for", entry.Server, "server", serverAddress)
+		return vaultEntry{}, false
+	}
+	return entry, true
+}
+
+// offerToSave asks whether to keep credentials that just signed in, creating
+// the vault when -remember is set and there is none yet.
+func offerToSave(c *ishell.Context, email string, password string) {
+	if !vault.unlocked() && !(rememberLogin && !vault.exists()) {
+		return
+	}
+	answer, ok := prompt(c, fmt.Sprintf("Save this login in the vault as profile %s? [y/N] ", profileName))
+	if !ok || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return
+	}
+	if !vault.unlocked() {
+		c.Println("Choose a passphrase for the new vault at", vaultPath)
+		passphrase, ok := promptPassphrase(c, "New Passphrase: ")
+		if !ok {
+			return
+		}
+		if err := vault.create(passphrase); err != nil {
+			c.Println("[ERROR] Unable to create the vault:", err)
+			return
+		}
+	}
+	entry := vaultEntry{Server: serverAddress, Email: email, Password: password, Saved: time.Now()}
+	if err := vault.put(profileName, entry); err != nil {
+		c.Println("[ERROR] Unable to save the login:", err)
+		return
+	}
+	c.Println("Login saved")
+}
+
+// updateSavedPassword brings saved logins up to date after the password for
+// email changed. A locked vault is left alone.
+func updateSavedPassword(c *ishell.Context, email string, password string) {
+	if !vault.unlocked() {
+		return
+	}
+	n, err := vault.updatePassword(email, password)
+	if err != nil {
+		c.Println("[ERROR] Unable to update the saved login:", err)
+		return
+	}
+	if n > 0 {
+		c.Println("Saved login updated")
+	}
+}
+
+func runVaultList(c *ishell.Context) {
+	if !vault.exists() {
+		c.Println("There is no vault yet; login with -remember to create one")
+		return
+	}
+	if !unlockVault(c) {
+		return
+	}
+	names := vault.profiles()
+	if len(names) == 0 {
+		c.Println("The vault is empty")
+		return
+	}
+	for _, name := range names {
+		entry, _ := vault.get(name)
+		current := " "
+		if name == profileName {
+			current = "*"
+		}
+		c.Printf("%s %-12s %-28s %-24s saved %s\n", current, name, entry.Email, entry.Server, entry.Saved.Format(time.DateTime))
+	}
+}
+
+func runVaultRemove(c *ishell.Context) {
+	if len(c.Args) != 1 {
+		c.Println("Usage: vault remove <profile>")
+		return
+	}
+	if !unlockVault(c) {
+		return
+	}
+	removed, err := vault.remove(c.Args[0])
+	switch {
+	case err != nil:
+		c.Println("[ERROR] Unable to remove the login:", err)
+	case !removed:
+		c.Println("[ERROR] No saved login for profile", c.Args[0])
+	default:
+		c.Println("Removed profile", c.Args[0])
+	}
+}
+
+// runVaultPasswd re-encrypts the vault under a new passphrase. The current
+// one is asked for even when the vault is unlocked.
+func runVaultPasswd(c *ishell.Context) {
+	if !vault.exists() {
+		c.Println("There is no vault yet")
+		return
+	}
+	current, ok := promptPassword(c, "Current Passphrase: ")
+	if !ok {
+		return
+	}
+	if err := vault.unlock(current); err != nil {
+		c.Println("[ERROR]", err)
+		return
+	}
+	next, ok := promptPassphrase(c, "New Passphrase: ")
+	if !ok {
+		return
+	}
+	if err := vault.rotate(next); err != nil {
+		c.Println("[ERROR] Unable to change the passphrase:", err)
+		return
+	}
+	c.Println("Passphrase changed")
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abiosoft/ishell/v2"
+)
+
+const testPassphrase = "correct horse"
+
+// useVault points the vault at a fresh file in a temporary directory for
+// the rest of the test.
+func useVault(t *testing.T) {
+	t.Helper()
+	path, idle, profile, previous := vaultPath, vaultIdle, profileName, vault
+	vaultPath = filepath.Join(t.TempDir(), "vault")
+	vault = &credentialVault{}
+	t.Cleanup(func() {
+		vault.lock()
+		vaultPath, vaultIdle, profileName, vault = path, idle, profile, previous
+	})
+}
+
+// createVault creates a vault holding entries and locks it again.
+func createVault(t *testing.T, entries map[string]vaultEntry) {
+	t.Helper()
+	if err := vault.create(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	for name, entry := range entries {
+		if err := vault.put(name, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vault.lock()
+}
+
+// tamperVault rewrites the vault file after passing it through change.
+func tamperVault(t *testing.T, change func(f *vaultFile)) {
+	t.Helper()
+	raw, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &vaultFile{}
+	if err := json.Unmarshal(raw, file); err != nil {
+		t.Fatal(err)
+	}
+	change(file)
+	if raw, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(vaultPath, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var aliceLogin = vaultEntry{Server: "chat.test:443", Email: testEmail, Password: "pass-word1"}
+
+func TestVaultRoundTrip(t *testing.T) {
+	useVault(t)
+	createVault(t, map[string]vaultEntry{"default": aliceLogin})
+	if vault.unlocked() {
+		t.Fatal("the vault is unlocked after lock")
+	}
+	if _, ok := vault.get("default"); ok {
+		t.Fatal("a locked vault handed out a login")
+	}
+
+	if err := vault.unlock(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := vault.get("default")
+	if !ok || entry.Email != aliceLogin.Email || entry.Password != aliceLogin.Password || entry.Server != aliceLogin.Server {
+		t.Fatalf("unlocked vault holds %+v, %t; want %+v", entry, ok, aliceLogin)
+	}
+	raw, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), aliceLogin.Password) || strings.Contains(string(raw), aliceLogin.Email) {
+		t.Errorf("the vault file holds the login in the clear: %s", raw)
+	}
+}
+
+func TestVaultWrongPassphrase(t *testing.T) {
+	useVault(t)
+	createVault(t, map[string]vaultEntry{"default": aliceLogin})
+
+	if err := vault.unlock("wrong horse"); !errors.Is(err, errVaultPassphrase) {
+		t.Fatalf("unlock with the wrong passphrase = %v, want %v", err, errVaultPassphrase)
+	}
+	if vault.unlocked() {
+		t.Fatal("the wrong passphrase unlocked the vault")
+	}
+
+	out := runCommand(t, runVaultList, "wrong horse", "still wrong", "")
+	if got := strings.Count(out, errVaultPassphrase.Error()); got != 2 {
+		t.Errorf("vault list reported %d wrong passphrases, want 2: %q", got, out)
+	}
+	if strings.Contains(out, testEmail) {
+		t.Errorf("vault list showed the locked vault: %q", out)
+	}
+}
+
+func TestVaultTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *vaultFile)
+		err    string
+	}{
+		{"salt", func(f *vaultFile) { f.Salt[0] ^= 1 }, errVaultPassphrase.Error()},
+		{"cost lowered", func(f *vaultFile) { f.N /= 2 }, errVaultPassphrase.Error()},
+		{"nonce", func(f *vaultFile) { f.Nonce[0] ^= 1 }, errVaultPassphrase.Error()},
+		{"ciphertext", func(f *vaultFile) { f.Data[len(f.Data)/2] ^= 1 }, errVaultPassphrase.Error()},
+		{"truncated", func(f *vaultFile) { f.Data = f.Data[:len(f.Data)-1] }, errVaultPassphrase.Error()},
+		{"version", func(f *vaultFile) { f.Version = 2 }, "unsupported vault version 2"},
+		{"kdf", func(f *vaultFile) { f.KDF = "none" }, `unsupported key derivation "none"`},
+		{"huge N", func(f *vaultFile) { f.N = 1 << 30 }, "out of range"},
+		{"N not a power of two", func(f *vaultFile) { f.N = 3 << 10 }, "out of range"},
+		{"huge r", func(f *vaultFile) { f.R = 1 << 20 }, "out of range"},
+		{"huge p", func(f *vaultFile) { f.P = 1 << 20 }, "out of range"},
+		{"memory", func(f *vaultFile) { f.N, f.R = maxScryptN, maxScryptR }, "out of range"},
+		{"short salt", func(f *vaultFile) { f.Salt = f.Salt[:4] }, "out of range"},
+		{"zero", func(f *vaultFile) { f.N, f.R, f.P = 0, 0, 0 }, "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useVault(t)
+			createVault(t, map[string]vaultEntry{"default": aliceLogin})
+			tamperVault(t, tt.change)
+
+			start := time.Now()
+			err := vault.unlock(testPassphrase)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unlock = %v, want an error containing %q", err, tt.err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("rejecting the vault took %s", elapsed)
+			}
+			if vault.unlocked() {
+				t.Fatal("a tampered vault unlocked")
+			}
+		})
+	}
+
+	t.Run("not json", func(t *testing.T) {
+		useVault(t)
+		if err := os.WriteFile(vaultPath, []byte("{not json"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := vault.unlock(testPassphrase); err == nil {
+			t.Fatal("a vault file that is not JSON unlocked")
+		}
+	})
+}
+
+func TestVaultPasswd(t *testing.T) {
+	useVault(t)
+	createVault(t, map[string]vaultEntry{"default": aliceLogin})
+	before, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	term := startCommand(t, runVaultPasswd)
+	term.answer("Current Passphrase: ", testPassphrase)
+	term.answer("New Passphrase: ", "short")
+	term.expect("use at least 8 characters")
+	term.answer("New Passphrase: ", "battery staple")
+	term.answer("Confirm Passphrase: ", "battery stapel")
+	term.expect("passphrases do not match")
+	term.answer("New Passphrase: ", "battery staple")
+	term.answer("Confirm Passphrase: ", "battery staple")
+	if out := term.wait(); !strings.Contains(out, "Passphrase changed") {
+		t.Fatalf("vault passwd printed %q", out)
+	}
+
+	after, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old, rotated vaultFile
+	if json.Unmarshal(before, &old) != nil || json.Unmarshal(after, &rotated) != nil {
+		t.Fatal("unable to read the vault files")
+	}
+	if string(old.Salt) == string(rotated.Salt) {
+		t.Error("the salt was kept when the passphrase changed")
+	}
+
+	vault.lock()
+	if err := vault.unlock(testPassphrase); !errors.Is(err, errVaultPassphrase) {
+		t.Fatalf("the old passphrase still opens the vault: %v", err)
+	}
+	if err := vault.unlock("battery staple"); err != nil {
+		t.Fatalf("the new passphrase does not open the vault: %v", err)
+	}
+	if entry, ok := vault.get("default"); !ok || entry.Password != aliceLogin.Password {
+		t.Errorf("the login did not survive the new passphrase: %+v, %t", entry, ok)
+	}
+}
+
+func TestVaultPasswdWrongPassphrase(t *testing.T) {
+	useVault(t)
+	createVault(t, map[string]vaultEntry{"default": aliceLogin})
+
+	out := runCommand(t, runVaultPasswd, "wrong horse")
+	if !strings.Contains(out, "[ERROR] "+errVaultPassphrase.Error()) || strings.Contains(out, "New Passphrase") {
+		t.Fatalf("vault passwd with the wrong passphrase printed %q", out)
+	}
+	if err := vault.unlock(testPassphrase); err != nil {
+		t.Fatalf("the passphrase changed anyway: %v", err)
+	}
+}
+
+// withArgs runs a command as if typed with args.
+func withArgs(run func(*ishell.Context), args ...string) func(*ishell.Context) {
+	return func(c *ishell.Context) {
+		c.Args = args
+		run(c)
+	}
+}
+
+func TestVaultListAndRemove(t *testing.T) {
+	useVault(t)
+	if out := runCommand(t, runVaultList); !strings.Contains(out, "There is no vault yet") {
+		t.Fatalf("vault list without a vault printed %q", out)
+	}
+
+	bob := vaultEntry{Server: "chat.test:443", Email: "bob@example.com", Password: "pass-word2"}
+	createVault(t, map[string]vaultEntry{"default": aliceLogin, "work": bob})
+	out := runCommand(t, runVaultList, testPassphrase)
+	lines := strings.Split(strings.TrimSpace(out[strings.Index(out, "* "):]), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "* default") || !strings.Contains(lines[0], testEmail) ||
+		!strings.HasPrefix(lines[1], "  work") || !strings.Contains(lines[1], bob.Email) {
+		t.Fatalf("vault list printed %q", out)
+	}
+	if strings.Contains(out, aliceLogin.Password) || strings.Contains(out, bob.Password) {
+		t.Errorf("vault list printed a password: %q", out)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "Usage: vault remove <profile>"},
+		{[]string{"home"}, "[ERROR] No saved login for profile home"},
+		{[]string{"work"}, "Removed profile work"},
+		{[]string{"work"}, "[ERROR] No saved login for profile work"},
+		{[]string{"default"}, "Removed profile default"},
+	}
+	for _, tt := range tests {
+		if out := runCommand(t, withArgs(runVaultRemove, tt.args...)); !strings.Contains(out, tt.want) {
+			t.Errorf("vault remove %v printed %q, want %q", tt.args, out, tt.want)
+		}
+	}
+
+	vault.lock()
+	if out := runCommand(t, runVaultList, testPassphrase); !strings.Contains(out, "The vault is empty") {
+		t.Errorf("removed profiles came back after unlocking again: %q", out)
+	}
+}
+
+func TestVaultIdleLock(t *testing.T) {
+	useVault(t)
+	vaultIdle = 100 * time.Millisecond
+	createVault(t, map[string]vaultEntry{"default": aliceLogin})
+	if err := vault.unlock(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	// Using the vault keeps it open.
+	for range 5 {
+		time.Sleep(vaultIdle / 2)
+		if _, ok := vault.get("default"); !ok {
+			t.Fatal("the vault locked while in use")
+		}
+	}
+	eventually(t, "the idle vault locks", func() bool { return !vault.unlocked() })
+	if _, ok := vault.get("default"); ok {
+		t.Fatal("the vault handed out a login after locking itself")
+	}
+}
+
+func TestSavedLoginForAnotherServer(t *testing.T) {
+	useVault(t)
+	address := serverAddress
+	t.Cleanup(func() { serverAddress = address })
+	createVault(t, map[string]vaultEntry{"default": aliceLogin})
+	if err := vault.unlock(testPassphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		server string
+		answer string
+		used   bool
+	}{
+		{"same server", aliceLogin.Server, "", true},
+		{"declined", "other.test:443", "n", false},
+		{"by default", "other.test:443", "", false},
+		{"confirmed", "other.test:443", "y", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverAddress = tt.server
+			var entry vaultEntry
+			var ok bool
+			term := startCommand(t, func(c *ishell.Context) { entry, ok = savedLogin(c) })
+			if tt.server != aliceLogin.Server {
+				term.expect("the saved login for profile default is for chat.test:443, not other.test:443")
+				term.answer("Send the saved password for "+testEmail+" to other.test:443? [y/N] ", tt.answer)
+			}
+			out := term.wait()
+			if tt.server == aliceLogin.Server && strings.Contains(out, "Send the saved password") {
+				t.Errorf("asked before using the login on the server it was saved for: %q", out)
+			}
+			if ok != tt.used || tt.used && entry.Password != aliceLogin.Password || !tt.used && entry.Password != "" {
+				t.Errorf("savedLogin = %+v, %t; want the saved login used %t", entry, ok, tt.used)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+)
+
+// webSocketProtocol is offered during the handshake so bridges can tell a
+// tunnelled gRPC connection from other WebSocket traffic. It is this
+// client's own convention, not gRPC-Web: a bridge accepting it relays the
+// binary frames unchanged to the server's gRPC port, as the one in the tests
+// does.
+const webSocketProtocol = "grpc-tunnel"
+
+func isWebSocketTarget(address string) bool {
+	return strings.HasPrefix(address, "ws://") || strings.HasPrefix(address, "wss://")
+}
+
+// transport picks how to reach address: tunnelled over a WebSocket for ws://
+// and wss:// URLs, otherwise natively over HTTP/2, through a proxy when one
+// is configured.
+func transport(address string) (string, []grpc.DialOption, error) {
+	if isWebSocketTarget(address) {
+		return webSocketTransport(address)
+	}
+	target, opts := dialTarget(address)
+	proxyOpts, err := proxyDialOptions(target)
+	if err != nil {
+		return "", nil, err
+	}
+	return target, append(opts, proxyOpts...), nil
+}
+
+// webSocketTransport carries the client's HTTP/2 connection inside a single
+// WebSocket, for networks that let HTTP/1.1 upgrades through but not HTTP/2.
+// The server end needs a bridge that unwraps the frames onto the gRPC port.
+func webSocketTransport(address string) (string, []grpc.DialOption, error) {
+	u, err := parseWebSocketURL(address)
+	if err != nil {
+		return "", nil, err
+	}
+	dial := func(ctx context.Context, _ string) (net.Conn, error) {
+		return dialWebSocket(ctx, u)
+	}
+	return "passthrough:///" + u.Host, []grpc.DialOption{grpc.WithNoProxy(), grpc.WithContextDialer(dial)}, nil
+}
+
+// parseWebSocketURL parses a ws:// or wss:// URL, filling in the default
+// port.
+func parseWebSocketURL(address string) (*url.URL, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL %q: %w", address, err)
+	}
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// dialWebSocket opens the WebSocket at u, through the proxy when one applies,
+// and returns it as a connection carrying binary frames.
+func dialWebSocket(ctx context.Context, u *url.URL) (net.Conn, error) {
+	conn, err := dialViaProxy(ctx, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s: %w", u.Host, err)
+		}
+		conn = tlsConn
+	}
+
+	origin := "http://" + u.Host
+	if u.Scheme == "wss" {
+		origin = "https://" + u.Host
+	}
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	config.Protocol = []string{webSocketProtocol}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake with %s: %w", u, err)
+	}
+	conn.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+	logger.Info("WebSocket tunnel opened", "url", u.String())
+	return ws, nil
+}
+
+// checkWebSocket reports whether the WebSocket endpoint accepts the tunnel.
+func checkWebSocket(ctx context.Context, address string) checkResult {
+	u, err := parseWebSocketURL(address)
+	if err != nil {
+		return checkResult{"WebSocket handshake", checkFail, err.Error(), "Pass the server as ws://host:port/path or wss://host/path with -s"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	start := time.Now()
+	ws, err := dialWebSocket(ctx, u)
+	if err != nil {
+		return checkResult{"WebSocket handshake", checkFail, err.Error(), "Make sure the WebSocket bridge is running at that URL"}
+	}
+	ws.Close()
+	return checkResult{"WebSocket handshake", checkPass, fmt.Sprintf("%s in %s", u.Host, time.Since(start).Round(time.Millisecond)), ""}
+}
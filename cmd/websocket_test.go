@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/Madslick/chit-chat-go-client/pkg"
+)
+
+// startWebSocketBridge serves the server end of the WebSocket transport on
+// /chat: it accepts tunnels offering webSocketProtocol and relays their
+// binary frames to the gRPC server at backend. It returns the ws:// URL.
+func startWebSocketBridge(t *testing.T, backend string) string {
+	t.Helper()
+	bridge := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if !slices.Contains(config.Protocol, webSocketProtocol) {
+				return errors.New("not a gRPC tunnel")
+			}
+			config.Protocol = []string{webSocketProtocol}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			conn, err := net.Dial("tcp", backend)
+			if err != nil {
+				ws.Close()
+				return
+			}
+			pipe(ws, ws, conn)
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/chat", bridge)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return "ws://" + srv.Listener.Addr().String() + "/chat"
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	clearProxyEnv(t)
+	srv := startFakeServer(t)
+	address := startWebSocketBridge(t, srv.addr)
+
+	// A login covers unary calls and the chat stream through the tunnel.
+	loginTo(t, srv, address, testEmail)
+	if got := srv.loggedIn(); len(got) != 1 || got[0] != testEmail {
+		t.Errorf("the server saw logins %q, want %s", got, testEmail)
+	}
+}
+
+func TestWebSocketTransportThroughProxy(t *testing.T) {
+	clearProxyEnv(t)
+	srv := startFakeServer(t)
+	srv.addAccount(testEmail, "pass-word1")
+	address := startWebSocketBridge(t, srv.addr)
+	bridgeHost := strings.TrimSuffix(strings.TrimPrefix(address, "ws://"), "/chat")
+	p := startProxy(t, "http", bridgeHost, nil)
+	useProxy(t, "http://"+p.addr)
+
+	connectTo(t, "ws://chat.test/chat")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := authClient().SignIn(ctx, &pkg.SignInRequest{Email: testEmail, Password: "pass-word1"}); err != nil {
+		t.Fatalf("SignIn through the proxy and tunnel: %v", err)
+	}
+	if got := p.requested(); len(got) == 0 || got[0] != "chat.test:80" {
+		t.Errorf("the proxy was asked for %q, want chat.test:80", got)
+	}
+}
+
+func TestCheckWebSocket(t *testing.T) {
+	clearProxyEnv(t)
+	srv := startFakeServer(t)
+	bridge := startWebSocketBridge(t, srv.addr)
+	plain := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(plain.Close)
+
+	tests := []struct {
+		name    string
+		address string
+		status  checkStatus
+		detail  string
+	}{
+		{"bridge", bridge, checkPass, ""},
+		{"no bridge at the path", strings.TrimSuffix(bridge, "/chat") + "/other", checkFail, "WebSocket handshake"},
+		{"not a WebSocket server", "ws://" + plain.Listener.Addr().String() + "/chat", checkFail, "WebSocket handshake"},
+		{"self-signed certificate", "wss://" + plain.Listener.Addr().String() + "/chat", checkFail, "TLS handshake"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkWebSocket(context.Background(), tt.address)
+			if got.status != tt.status || !strings.Contains(got.detail, tt.detail) {
+				t.Errorf("checkWebSocket(%q) = %+v, want %v mentioning %q", tt.address, got, tt.status, tt.detail)
+			}
+		})
+	}
+}
+
+func TestParseWebSocketURL(t *testing.T) {
+	tests := []struct {
+		address string
+		host    string
+	}{
+		{"ws://chat.test/chat", "chat.test:80"},
+		{"wss://chat.test/chat", "chat.test:443"},
+		{"wss://chat.test:8443/chat", "chat.test:8443"},
+	}
+	for _, tt := range tests {
+		u, err := parseWebSocketURL(tt.address)
+		if err != nil {
+			t.Fatalf("parseWebSocketURL(%q): %v", tt.address, err)
+		}
+		if u.Host != tt.host {
+			t.Errorf("parseWebSocketURL(%q) host = %q, want %q", tt.address, u.Host, tt.host)
+		}
+	}
+}
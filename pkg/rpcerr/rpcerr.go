@@ -0,0 +1,253 @@
+// Package rpcerr classifies the errors returned by the Auth and Chatroom
+// services into the few kinds a user can act on, such as a rejected login or
+// an unavailable server, each with a message saying what to do and whether
+// trying again may help.
+//
+// Errors from the client are *Error values, which keep the gRPC status so
+// status.Code still works, and match their Kind with errors.Is:
+//
+//	if errors.Is(err, rpcerr.NotFound) { ... }
+package rpcerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kind is the class of a failed call. Kinds are errors themselves so they
+// can be the target of errors.Is.
+type Kind int
+
+const (
+	Unknown Kind = iota
+	Canceled
+	AuthFailed
+	NotFound
+	Conflict
+	Invalid
+	Unavailable
+	RateLimited
+	Deadline
+	Unsupported
+	Internal
+)
+
+var kindNames = [...]string{
+	Unknown:     "unknown error",
+	Canceled:    "canceled",
+	AuthFailed:  "not authorized",
+	NotFound:    "not found",
+	Conflict:    "conflict",
+	Invalid:     "invalid request",
+	Unavailable: "server unavailable",
+	RateLimited: "rate limited",
+	Deadline:    "deadline exceeded",
+	Unsupported: "not supported",
+	Internal:    "server error",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+func (k Kind) Error() string { return "rpc: " + k.String() }
+
+// Classify returns the kind of a gRPC status code.
+func Classify(code codes.Code) Kind {
+	switch code {
+	case codes.Canceled:
+		return Canceled
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return AuthFailed
+	case codes.NotFound:
+		return NotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return Conflict
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return Invalid
+	case codes.Unavailable:
+		return Unavailable
+	case codes.ResourceExhausted:
+		return RateLimited
+	case codes.DeadlineExceeded:
+		return Deadline
+	case codes.Unimplemented:
+		return Unsupported
+	case codes.Internal, codes.DataLoss:
+		return Internal
+	}
+	return Unknown
+}
+
+// FieldViolation is a problem the server found with one request field.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is a classified failure of a call to the chat server.
+type Error struct {
+	Kind Kind
+	Code codes.Code
+	// Message is the server's own description of the failure.
+	Message    string
+	Violations []FieldViolation
+	// RetryAfter is how long the server asked to wait before trying again,
+	// zero when it did not say.
+	RetryAfter time.Duration
+
+	status *status.Status
+	err    error
+}
+
+// From classifies err. It returns nil for nil, and err itself when it is
+// already an *Error.
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var s *status.Status
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		s = status.FromContextError(err)
+	default:
+		s = status.Convert(err)
+	}
+	e = &Error{Kind: Classify(s.Code()), Code: s.Code(), Message: s.Message(), status: s, err: err}
+	for _, detail := range s.Details() {
+		switch d := detail.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				e.Violations = append(e.Violations, FieldViolation{v.GetField(), v.GetDescription()})
+			}
+		case *errdetails.RetryInfo:
+			e.RetryAfter = d.GetRetryDelay().AsDuration()
+		}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.err }
+
+// Is matches the error's Kind.
+func (e *Error) Is(target error) bool {
+	kind, ok := target.(Kind)
+	return ok && kind == e.Kind
+}
+
+// GRPCStatus keeps status.Code and status.FromError working on classified
+// errors.
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// Retryable reports whether the same call may succeed if tried again later.
+// Callers must still only repeat calls that are safe to repeat.
+func (e *Error) Retryable() bool {
+	switch e.Kind {
+	case Unavailable, RateLimited, Deadline:
+		return true
+	}
+	return false
+}
+
+// Hint says what went wrong in terms the user can act on.
+func (e *Error) Hint() string {
+	switch e.Kind {
+	case Canceled:
+		return "canceled"
+	case AuthFailed:
+		return "the server did not accept your credentials"
+	case NotFound:
+		return "it does not exist on the server"
+	case Conflict:
+		return "it conflicts with something that already exists"
+	case Invalid:
+		if len(e.Violations) > 0 {
+			var fields []string
+			for _, v := range e.Violations {
+				fields = append(fields, v.Field+": "+v.Description)
+			}
+			return "the server rejected " + strings.Join(fields, "; ")
+		}
+		return "the server rejected the request: " + e.Message
+	case Unavailable:
+		return "the server is unavailable, check your connection and try again shortly"
+	case RateLimited:
+		if e.RetryAfter > 0 {
+			return fmt.Sprintf("too many requests, wait %s before trying again", e.RetryAfter.Round(time.Second))
+		}
+		return "too many requests, wait a little before trying again"
+	case Deadline:
+		return "the server did not answer in time"
+	case Unsupported:
+		return "the server does not support this"
+	case Internal:
+		return "the server failed: " + e.Message
+	}
+	if e.Message == "" {
+		return e.Kind.String()
+	}
+	return e.Message
+}
+
+// UnaryClientInterceptor turns the errors of unary calls into *Error.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+		return From(err)
+	}
+	return nil
+}
+
+// StreamClientInterceptor turns the errors of streams into *Error. The
+// io.EOF ending a stream is left alone.
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, From(err)
+	}
+	return classifiedStream{stream}, nil
+}
+
+type classifiedStream struct {
+	grpc.ClientStream
+}
+
+func (s classifiedStream) SendMsg(m interface{}) error {
+	return classify(s.ClientStream.SendMsg(m))
+}
+
+func (s classifiedStream) RecvMsg(m interface{}) error {
+	return classify(s.ClientStream.RecvMsg(m))
+}
+
+func classify(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return From(err)
+}
@@ -0,0 +1,318 @@
+package rpcerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want Kind
+	}{
+		{codes.OK, Unknown},
+		{codes.Unknown, Unknown},
+		{codes.Canceled, Canceled},
+		{codes.Unauthenticated, AuthFailed},
+		{codes.PermissionDenied, AuthFailed},
+		{codes.NotFound, NotFound},
+		{codes.AlreadyExists, Conflict},
+		{codes.Aborted, Conflict},
+		{codes.InvalidArgument, Invalid},
+		{codes.FailedPrecondition, Invalid},
+		{codes.OutOfRange, Invalid},
+		{codes.Unavailable, Unavailable},
+		{codes.ResourceExhausted, RateLimited},
+		{codes.DeadlineExceeded, Deadline},
+		{codes.Unimplemented, Unsupported},
+		{codes.Internal, Internal},
+		{codes.DataLoss, Internal},
+		{codes.Code(99), Unknown},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.code); got != tt.want {
+			t.Errorf("Classify(%s) = %s, want %s", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestKindString(t *testing.T) {
+	if got := NotFound.Error(); got != "rpc: not found" {
+		t.Errorf("NotFound.Error() = %q", got)
+	}
+	if got := Kind(-1).String(); got != "kind(-1)" {
+		t.Errorf("Kind(-1).String() = %q", got)
+	}
+	if got := Kind(100).String(); got != "kind(100)" {
+		t.Errorf("Kind(100).String() = %q", got)
+	}
+}
+
+// withDetails returns a status error carrying details.
+func withDetails(t *testing.T, code codes.Code, msg string, details ...*errdetails.BadRequest) error {
+	t.Helper()
+	s := status.New(code, msg)
+	for _, d := range details {
+		var err error
+		if s, err = s.WithDetails(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s.Err()
+}
+
+func TestFrom(t *testing.T) {
+	if From(nil) != nil {
+		t.Fatal("From(nil) is not nil")
+	}
+
+	retry, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(3 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	badRequest := withDetails(t, codes.InvalidArgument, "bad signup", &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "email", Description: "is not an email address"},
+			{Field: "phone", Description: "is too short"},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		err        error
+		kind       Kind
+		code       codes.Code
+		message    string
+		violations []FieldViolation
+		retryAfter time.Duration
+	}{
+		{"status", status.Error(codes.NotFound, "no such conversation"), NotFound, codes.NotFound, "no such conversation", nil, 0},
+		{"wrapped status", fmt.Errorf("loading: %w", status.Error(codes.Unavailable, "down")), Unavailable, codes.Unavailable, "loading: rpc error: code = Unavailable desc = down", nil, 0},
+		{"canceled context", context.Canceled, Canceled, codes.Canceled, context.Canceled.Error(), nil, 0},
+		{"wrapped deadline", fmt.Errorf("calling: %w", context.DeadlineExceeded), Deadline, codes.DeadlineExceeded, "calling: " + context.DeadlineExceeded.Error(), nil, 0},
+		{"plain error", errors.New("boom"), Unknown, codes.Unknown, "boom", nil, 0},
+		{"field violations", badRequest, Invalid, codes.InvalidArgument, "bad signup", []FieldViolation{
+			{"email", "is not an email address"},
+			{"phone", "is too short"},
+		}, 0},
+		{"retry info", retry.Err(), RateLimited, codes.ResourceExhausted, "slow down", nil, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Kind != tt.kind || e.Code != tt.code || e.Message != tt.message {
+				t.Errorf("From = {%s %s %q}, want {%s %s %q}", e.Kind, e.Code, e.Message, tt.kind, tt.code, tt.message)
+			}
+			if fmt.Sprint(e.Violations) != fmt.Sprint(tt.violations) {
+				t.Errorf("violations = %v, want %v", e.Violations, tt.violations)
+			}
+			if e.RetryAfter != tt.retryAfter {
+				t.Errorf("RetryAfter = %s, want %s", e.RetryAfter, tt.retryAfter)
+			}
+			if From(e) != e {
+				t.Error("From of an *Error is not the error itself")
+			}
+			if wrapped := fmt.Errorf("outer: %w", e); From(wrapped) != e {
+				t.Error("From of a wrapped *Error is not the error itself")
+			}
+		})
+	}
+}
+
+func TestErrorMatchesKind(t *testing.T) {
+	err := fmt.Errorf("signing in: %w", From(status.Error(codes.PermissionDenied, "nope")))
+
+	if !errors.Is(err, AuthFailed) {
+		t.Error("errors.Is(err, AuthFailed) = false")
+	}
+	for _, other := range []Kind{Unknown, NotFound, Canceled, Invalid} {
+		if errors.Is(err, other) {
+			t.Errorf("errors.Is(err, %s) = true", other)
+		}
+	}
+	var kind Kind
+	if errors.As(err, &kind) {
+		t.Errorf("errors.As found the Kind %s, which is not in the chain", kind)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != AuthFailed || e.Code != codes.PermissionDenied {
+		t.Fatalf("errors.As(err, *Error) = %v", e)
+	}
+	if got := err.Error(); got != "signing in: rpc: not authorized: nope" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := From(errors.New("")).Error(); got != "rpc: unknown error" {
+		t.Errorf("Error() without a message = %q", got)
+	}
+}
+
+func TestUnwrapAndStatus(t *testing.T) {
+	cause := status.Error(codes.Unavailable, "connection refused")
+	e := From(cause)
+	if !errors.Is(e, cause) || errors.Unwrap(e) != cause {
+		t.Errorf("Unwrap = %v, want the original error", errors.Unwrap(e))
+	}
+
+	// status keeps working on a classified error, wrapped or not.
+	if s, ok := status.FromError(e); !ok || s.Code() != codes.Unavailable || s.Message() != "connection refused" {
+		t.Errorf("status.FromError(%v) = %v, %t", e, s, ok)
+	}
+	wrapped := fmt.Errorf("sending: %w", e)
+	if s, ok := status.FromError(wrapped); !ok || s.Code() != codes.Unavailable {
+		t.Errorf("status.FromError(%v) = %v, %t", wrapped, s, ok)
+	}
+	if got := status.Code(wrapped); got != codes.Unavailable {
+		t.Errorf("status.Code(%v) = %s", wrapped, got)
+	}
+	if got := status.Code(From(context.DeadlineExceeded)); got != codes.DeadlineExceeded {
+		t.Errorf("status.Code of a classified context error = %s", got)
+	}
+	if !errors.Is(From(context.Canceled), context.Canceled) {
+		t.Error("a classified context.Canceled no longer matches it")
+	}
+}
+
+func TestRetryableAndHint(t *testing.T) {
+	violations := withDetails(t, codes.InvalidArgument, "bad", &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "email", Description: "is taken"}},
+	})
+	retry, err := status.New(codes.ResourceExhausted, "").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		err       error
+		retryable bool
+		hint      string
+	}{
+		{status.Error(codes.Canceled, ""), false, "canceled"},
+		{status.Error(codes.Unauthenticated, ""), false, "the server did not accept your credentials"},
+		{status.Error(codes.NotFound, ""), false, "it does not exist on the server"},
+		{status.Error(codes.AlreadyExists, ""), false, "it conflicts with something that already exists"},
+		{status.Error(codes.InvalidArgument, "name too long"), false, "the server rejected the request: name too long"},
+		{violations, false, "the server rejected email: is taken"},
+		{status.Error(codes.Unavailable, ""), true, "the server is unavailable, check your connection and try again shortly"},
+		{status.Error(codes.ResourceExhausted, ""), true, "too many requests, wait a little before trying again"},
+		{retry.Err(), true, "too many requests, wait 2s before trying again"},
+		{status.Error(codes.DeadlineExceeded, ""), true, "the server did not answer in time"},
+		{status.Error(codes.Unimplemented, ""), false, "the server does not support this"},
+		{status.Error(codes.Internal, "nil pointer"), false, "the server failed: nil pointer"},
+		{status.Error(codes.Unknown, "odd"), false, "odd"},
+		{status.Error(codes.Unknown, ""), false, "unknown error"},
+	}
+	for _, tt := range tests {
+		e := From(tt.err)
+		if got := e.Retryable(); got != tt.retryable {
+			t.Errorf("Retryable of %v = %t, want %t", tt.err, got, tt.retryable)
+		}
+		if got := e.Hint(); got != tt.hint {
+			t.Errorf("Hint of %v = %q, want %q", tt.err, got, tt.hint)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	tests := []struct {
+		name   string
+		result error
+		kind   Kind
+	}{
+		{"success", nil, Unknown},
+		{"status", status.Error(codes.NotFound, "gone"), NotFound},
+		{"context", context.DeadlineExceeded, Deadline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoked := false
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				invoked = method == "/chat.Auth/SignIn"
+				return tt.result
+			}
+			err := UnaryClientInterceptor(context.Background(), "/chat.Auth/SignIn", nil, nil, nil, invoker)
+			if !invoked {
+				t.Fatal("the call was not passed on")
+			}
+			if tt.result == nil {
+				if err != nil {
+					t.Fatalf("a successful call failed with %v", err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Kind != tt.kind {
+				t.Fatalf("the call failed with %#v, want an *Error of kind %s", err, tt.kind)
+			}
+		})
+	}
+}
+
+// fakeStream answers every receive with recv and every send with send.
+type fakeStream struct {
+	grpc.ClientStream
+	send, recv error
+}
+
+func (s fakeStream) SendMsg(m interface{}) error { return s.send }
+func (s fakeStream) RecvMsg(m interface{}) error { return s.recv }
+
+func TestStreamClientInterceptor(t *testing.T) {
+	t.Run("open fails", func(t *testing.T) {
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return nil, status.Error(codes.Unauthenticated, "log in first")
+		}
+		stream, err := StreamClientInterceptor(context.Background(), &grpc.StreamDesc{}, nil, "/chat.Chatroom/Converse", streamer)
+		if stream != nil || !errors.Is(err, AuthFailed) {
+			t.Fatalf("opening the stream = %v, %v; want no stream and AuthFailed", stream, err)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		send, recv error
+		sendKind   Kind
+		recvKind   Kind
+	}{
+		{"ok", nil, nil, Unknown, Unknown},
+		{"end of stream", nil, io.EOF, Unknown, Unknown},
+		{"send fails", status.Error(codes.Unavailable, "reset"), nil, Unavailable, Unknown},
+		{"recv fails", nil, status.Error(codes.Internal, "panic"), Unknown, Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return fakeStream{send: tt.send, recv: tt.recv}, nil
+			}
+			stream, err := StreamClientInterceptor(context.Background(), &grpc.StreamDesc{}, nil, "/chat.Chatroom/Converse", streamer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check := func(op string, err, want error, kind Kind) {
+				t.Helper()
+				switch {
+				case want == nil && err != nil:
+					t.Errorf("%s failed with %v", op, err)
+				case want == io.EOF && err != io.EOF:
+					t.Errorf("%s = %#v, want io.EOF itself", op, err)
+				case want != nil && want != io.EOF && !errors.Is(err, kind):
+					t.Errorf("%s = %v, want an *Error of kind %s", op, err, kind)
+				case want != nil && want != io.EOF && !strings.Contains(err.Error(), status.Convert(want).Message()):
+					t.Errorf("%s = %v, lost the server's message", op, err)
+				}
+			}
+			check("SendMsg", stream.SendMsg(nil), tt.send, tt.sendKind)
+			check("RecvMsg", stream.RecvMsg(nil), tt.recv, tt.recvKind)
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Package slash implements the registry of slash commands available in the
+// chat view, e.g. "/who" or "/history 20". It knows nothing about the
+// terminal so commands can be registered by plugins and bots and exercised
+// without a shell.
+package slash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prefix starts every slash command. A doubled prefix ("//") escapes it so a
+// message can begin with a literal slash.
+const Prefix = "/"
+
+var (
+	ErrNotCommand     = errors.New("slash: not a command")
+	ErrUnknownCommand = errors.New("slash: unknown command")
+	ErrUsage          = errors.New("slash: invalid arguments")
+	ErrDuplicate      = errors.New("slash: command already registered")
+)
+
+// Invocation is a parsed command line.
+type Invocation struct {
+	// Name is the command name without the prefix, as typed.
+	Name string
+	// Args are the whitespace separated arguments.
+	Args []string
+	// Text is everything after the command name with surrounding space
+	// trimmed, for commands such as /me that take free text.
+	Text string
+}
+
+// Command describes a single slash command.
+type Command struct {
+	Name    string
+	Aliases []string
+	// Usage is the argument synopsis shown in help, e.g. "[n]".
+	Usage string
+	Help  string
+	// MinArgs and MaxArgs bound len(Invocation.Args). A negative MaxArgs
+	// means there is no upper bound.
+	MinArgs int
+	MaxArgs int
+	// Complete returns candidates for the argument being typed, given the
+	// arguments before it. It may be nil.
+	Complete func(args []string, prefix string) []string
+	Run      func(inv Invocation) error
+}
+
+// Registry holds the commands available in the chat view. It is safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+	aliases  map[string]string
+}
+
+func New() *Registry {
+	return &Registry{
+		commands: map[string]*Command{},
+		aliases:  map[string]string{},
+	}
+}
+
+// Register adds cmd to the registry. Names and aliases are case-insensitive
+// and must not clash with an existing command.
+func (r *Registry) Register(cmd Command) error {
+	if cmd.Name == "" || cmd.Run == nil {
+		return fmt.Errorf("slash: command needs a name and a Run function")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, n := range names {
+		if _, ok := r.resolve(n); ok {
+			return fmt.Errorf("%w: %s", ErrDuplicate, n)
+		}
+	}
+	name := strings.ToLower(cmd.Name)
+	r.commands[name] = &cmd
+	for _, alias := range cmd.Aliases {
+		r.aliases[strings.ToLower(alias)] = name
+	}
+	return nil
+}
+
+// Unregister removes the command called name along with its aliases.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name, ok := r.resolve(name)
+	if !ok {
+		return
+	}
+	for _, alias := range r.commands[name].Aliases {
+		delete(r.aliases, strings.ToLower(alias))
+	}
+	delete(r.commands, name)
+}
+
+func (r *Registry) resolve(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if _, ok := r.commands[name]; ok {
+		return name, true
+	}
+	if target, ok := r.aliases[name]; ok {
+		return target, true
+	}
+	return "", false
+}
+
+// Lookup returns the command registered under name or one of its aliases.
+func (r *Registry) Lookup(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.resolve(name)
+	if !ok {
+		return nil, false
+	}
+	return r.commands[name], true
+}
+
+// Commands returns every registered command sorted by name.
+func (r *Registry) Commands() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmds := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+// IsCommand reports whether line should be handled as a command rather than
+// sent as a message.
+func IsCommand(line string) bool {
+	return strings.HasPrefix(line, Prefix) && !strings.HasPrefix(line, Prefix+Prefix)
+}
+
+// Unescape turns an escaped message ("//path") back into the text to send.
+func Unescape(line string) string {
+	if strings.HasPrefix(line, Prefix+Prefix) {
+		return line[len(Prefix):]
+	}
+	return line
+}
+
+// Parse splits a command line into its name and arguments.
+func Parse(line string) (Invocation, error) {
+	line = strings.TrimSpace(line)
+	if !IsCommand(line) {
+		return Invocation{}, ErrNotCommand
+	}
+	body := strings.TrimSpace(strings.TrimPrefix(line, Prefix))
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return Invocation{}, ErrUnknownCommand
+	}
+	return Invocation{
+		Name: fields[0],
+		Args: fields[1:],
+		Text: strings.TrimSpace(strings.TrimPrefix(body, fields[0])),
+	}, nil
+}
+
+// Execute parses line and runs the matching command.
+func (r *Registry) Execute(line string) error {
+	inv, err := Parse(line)
+	if err != nil {
+		return err
+	}
+	cmd, ok := r.Lookup(inv.Name)
+	if !ok {
+		return fmt.Errorf("%w: %s%s", ErrUnknownCommand, Prefix, inv.Name)
+	}
+	if len(inv.Args) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(inv.Args) > cmd.MaxArgs) {
+		return fmt.Errorf("%w: usage: %s", ErrUsage, cmd.Synopsis())
+	}
+	return cmd.Run(inv)
+}
+
+// Synopsis returns the command name followed by its usage, e.g.
+// "/history [n]".
+func (c *Command) Synopsis() string {
+	if c.Usage == "" {
+		return Prefix + c.Name
+	}
+	return Prefix + c.Name + " " + c.Usage
+}
+
+// HelpText lists every command with its usage and help.
+func (r *Registry) HelpText() string {
+	var b strings.Builder
+	for _, cmd := range r.Commands() {
+		fmt.Fprintf(&b, "  %-20s %s\n", cmd.Synopsis(), cmd.Help)
+	}
+	return b.String()
+}
+
+// Complete returns completions for line, which is the chat input up to the
+// cursor. Candidates are whole words; the caller strips the typed prefix.
+func (r *Registry) Complete(line string) (candidates []string, prefix string) {
+	if !IsCommand(line) {
+		return nil, ""
+	}
+	body := strings.TrimPrefix(line, Prefix)
+	fields := strings.Fields(body)
+	if len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(body, " ")) {
+		prefix = ""
+		if len(fields) == 1 {
+			prefix = fields[0]
+		}
+		for _, cmd := range r.Commands() {
+			if strings.HasPrefix(cmd.Name, strings.ToLower(prefix)) {
+				candidates = append(candidates, cmd.Name)
+			}
+		}
+		return candidates, prefix
+	}
+
+	cmd, ok := r.Lookup(fields[0])
+	if !ok || cmd.Complete == nil {
+		return nil, ""
+	}
+	args := fields[1:]
+	if !strings.HasSuffix(body, " ") {
+		prefix = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+	for _, candidate := range cmd.Complete(args, prefix) {
+		if strings.HasPrefix(candidate, prefix) {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, prefix
+}
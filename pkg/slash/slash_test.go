@@ -0,0 +1,240 @@
+package slash
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want Invocation
+		err  error
+	}{
+		{"/who", Invocation{Name: "who", Args: []string{}}, nil},
+		{"  /history 20  ", Invocation{Name: "history", Args: []string{"20"}, Text: "20"}, nil},
+		{"/me  waves   hello", Invocation{Name: "me", Args: []string{"waves", "hello"}, Text: "waves   hello"}, nil},
+		{"/ me waves", Invocation{Name: "me", Args: []string{"waves"}, Text: "waves"}, nil},
+		{"/", Invocation{}, ErrUnknownCommand},
+		{"/   ", Invocation{}, ErrUnknownCommand},
+		{"hello", Invocation{}, ErrNotCommand},
+		{"//not a command", Invocation{}, ErrNotCommand},
+		{"", Invocation{}, ErrNotCommand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := Parse(tt.line)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Parse(%q) error = %v, want %v", tt.line, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	tests := []struct {
+		line      string
+		isCommand bool
+		unescaped string
+	}{
+		{"/who", true, "/who"},
+		{"//etc/hosts is the file", false, "/etc/hosts is the file"},
+		{"///", false, "//"},
+		{"a /slash inside", false, "a /slash inside"},
+		{"plain text", false, "plain text"},
+	}
+	for _, tt := range tests {
+		if got := IsCommand(tt.line); got != tt.isCommand {
+			t.Errorf("IsCommand(%q) = %v, want %v", tt.line, got, tt.isCommand)
+		}
+		if got := Unescape(tt.line); got != tt.unescaped {
+			t.Errorf("Unescape(%q) = %q, want %q", tt.line, got, tt.unescaped)
+		}
+	}
+}
+
+// record registers a command that keeps the invocations it runs with.
+func record(t *testing.T, r *Registry, cmd Command) *[]Invocation {
+	t.Helper()
+	var runs []Invocation
+	cmd.Run = func(inv Invocation) error {
+		runs = append(runs, inv)
+		return nil
+	}
+	if err := r.Register(cmd); err != nil {
+		t.Fatalf("Register(%s): %v", cmd.Name, err)
+	}
+	return &runs
+}
+
+func TestExecuteArgumentBounds(t *testing.T) {
+	r := New()
+	record(t, r, Command{Name: "who", MaxArgs: 0})
+	record(t, r, Command{Name: "history", Usage: "[n]", MaxArgs: 1})
+	record(t, r, Command{Name: "kick", Usage: "<name> [reason]", MinArgs: 1, MaxArgs: 2})
+	record(t, r, Command{Name: "me", Usage: "<action>", MinArgs: 1, MaxArgs: -1})
+
+	tests := []struct {
+		line string
+		err  error
+	}{
+		{"/who", nil},
+		{"/who everyone", ErrUsage},
+		{"/history", nil},
+		{"/history 20", nil},
+		{"/history 20 30", ErrUsage},
+		{"/kick", ErrUsage},
+		{"/kick bob", nil},
+		{"/kick bob spam", nil},
+		{"/kick bob spam again", ErrUsage},
+		{"/me", ErrUsage},
+		{"/me waves at everyone in the room", nil},
+		{"/nope", ErrUnknownCommand},
+		{"hello", ErrNotCommand},
+	}
+	for _, tt := range tests {
+		if err := r.Execute(tt.line); !errors.Is(err, tt.err) {
+			t.Errorf("Execute(%q) = %v, want %v", tt.line, err, tt.err)
+		}
+	}
+}
+
+func TestExecuteUsageError(t *testing.T) {
+	r := New()
+	record(t, r, Command{Name: "kick", Usage: "<name> [reason]", MinArgs: 1, MaxArgs: 2})
+	err := r.Execute("/kick")
+	if err == nil || !strings.Contains(err.Error(), "usage: /kick <name> [reason]") {
+		t.Errorf("Execute(\"/kick\") = %v, want it to show the synopsis", err)
+	}
+}
+
+func TestExecuteReturnsRunError(t *testing.T) {
+	r := New()
+	failed := errors.New("failed")
+	r.Register(Command{Name: "fail", MaxArgs: -1, Run: func(Invocation) error { return failed }})
+	if err := r.Execute("/fail"); err != failed {
+		t.Errorf("Execute(\"/fail\") = %v, want the command's error", err)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	r := New()
+	runs := record(t, r, Command{Name: "history", Aliases: []string{"h", "Log"}, MaxArgs: 1})
+
+	for _, line := range []string{"/history 5", "/h 5", "/LOG 5", "/HiStOrY 5"} {
+		if err := r.Execute(line); err != nil {
+			t.Errorf("Execute(%q): %v", line, err)
+		}
+	}
+	if len(*runs) != 4 {
+		t.Fatalf("history ran %d times, want 4", len(*runs))
+	}
+	// The invocation keeps the name as typed.
+	if got := (*runs)[1].Name; got != "h" {
+		t.Errorf("Name = %q, want the alias as typed", got)
+	}
+	if cmd, ok := r.Lookup("log"); !ok || cmd.Name != "history" {
+		t.Errorf("Lookup(\"log\") = %v, %v", cmd, ok)
+	}
+
+	r.Unregister("h")
+	for _, name := range []string{"history", "h", "log"} {
+		if _, ok := r.Lookup(name); ok {
+			t.Errorf("Lookup(%q) found a command after Unregister", name)
+		}
+	}
+}
+
+func TestRegisterDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+	}{
+		{"same name", Command{Name: "who"}},
+		{"name in another case", Command{Name: "WHO"}},
+		{"name clashing with an alias", Command{Name: "w"}},
+		{"alias clashing with a name", Command{Name: "list", Aliases: []string{"who"}}},
+		{"alias clashing with an alias", Command{Name: "list", Aliases: []string{"W"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			record(t, r, Command{Name: "who", Aliases: []string{"w"}})
+			tt.cmd.Run = func(Invocation) error { return nil }
+			if err := r.Register(tt.cmd); !errors.Is(err, ErrDuplicate) {
+				t.Errorf("Register(%+v) = %v, want ErrDuplicate", tt.cmd, err)
+			}
+			if len(r.Commands()) != 1 {
+				t.Errorf("the registry has %d commands after a refused Register", len(r.Commands()))
+			}
+		})
+	}
+}
+
+func TestRegisterIncomplete(t *testing.T) {
+	r := New()
+	if err := r.Register(Command{Name: "who"}); err == nil {
+		t.Error("Register accepted a command without Run")
+	}
+	if err := r.Register(Command{Run: func(Invocation) error { return nil }}); err == nil {
+		t.Error("Register accepted a command without a name")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	r := New()
+	record(t, r, Command{Name: "history", Aliases: []string{"h"}, MaxArgs: 1})
+	record(t, r, Command{Name: "help", MaxArgs: 1})
+	record(t, r, Command{Name: "who"})
+	record(t, r, Command{Name: "notify", MaxArgs: 2, Complete: func(args []string, prefix string) []string {
+		if len(args) == 0 {
+			return []string{"on", "off", "mentions"}
+		}
+		return []string{"muted"}
+	}})
+
+	tests := []struct {
+		line       string
+		candidates []string
+		prefix     string
+	}{
+		{"/", []string{"help", "history", "notify", "who"}, ""},
+		{"/h", []string{"help", "history"}, "h"},
+		{"/hi", []string{"history"}, "hi"},
+		{"/x", nil, "x"},
+		{"/notify ", []string{"on", "off", "mentions"}, ""},
+		{"/notify o", []string{"on", "off"}, "o"},
+		{"/notify on ", []string{"muted"}, ""},
+		{"/who ", nil, ""},
+		{"/nope ", nil, ""},
+		{"hello", nil, ""},
+		{"//notify o", nil, ""},
+	}
+	for _, tt := range tests {
+		candidates, prefix := r.Complete(tt.line)
+		if !slices.Equal(candidates, tt.candidates) || prefix != tt.prefix {
+			t.Errorf("Complete(%q) = %q, %q; want %q, %q", tt.line, candidates, prefix, tt.candidates, tt.prefix)
+		}
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	r := New()
+	record(t, r, Command{Name: "who", Help: "List the members"})
+	record(t, r, Command{Name: "history", Usage: "[n]", Help: "Show messages"})
+	got := r.HelpText()
+	if strings.Index(got, "/history [n]") > strings.Index(got, "/who") {
+		t.Errorf("HelpText is not sorted by name:\n%s", got)
+	}
+	for _, want := range []string{"/history [n]", "Show messages", "/who", "List the members"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("HelpText lacks %q:\n%s", want, got)
+		}
+	}
+}